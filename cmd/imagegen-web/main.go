@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"imagegen/internal/webapp"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "listen address")
+	dataDir := flag.String("data-dir", defaultDataDir(), "data root for the database and images")
+	storage := flag.String("storage", webapp.StorageSQLite, "metadata storage backend: sqlite or files")
+	gcInterval := flag.Duration("gc-interval", 0, "delete orphaned image files on this schedule, e.g. 24h (0 disables)")
+	maxBytes := flag.Int("max-bytes", 0, "default byte budget for JPG and WEBP jobs that do not set one (0 disables)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: imagegen-web [flags] [command]\n\ncommands:\n  migrate status|up|down\n  gc [-delete]\n  fsck [-repair [-delete-missing]]\n  backup [-out DIR] [-incremental]\n  restore [-force] [-verify] ARCHIVE\n  export [-out FILE] PROJECT\n  import [-as SLUG] BUNDLE\n  backfill-images\n\ncommands work on imagegen.db and need -storage sqlite (the default).\n\nflags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() > 0 {
+		if err := webapp.RunCommand(*dataDir, *storage, flag.Args(), os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "imagegen-web: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *maxBytes < 0 {
+		log.Fatal("-max-bytes must be >= 0")
+	}
+	server, err := webapp.NewServer(*dataDir, *storage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.DefaultMaxBytes = *maxBytes
+	server.StartGarbageCollector(*gcInterval)
+	log.Printf("listening on %s (data dir %s, storage %s)", *addr, *dataDir, *storage)
+	log.Fatal(http.ListenAndServe(*addr, server.Routes()))
+}
+
+func defaultDataDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".imagegen"
+	}
+	return filepath.Join(home, ".imagegen")
+}
@@ -6,53 +6,89 @@ import (
 	"errors"
 	"fmt"
 	"image"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
 
 	"github.com/kolesa-team/go-webp/decoder"
 	"github.com/kolesa-team/go-webp/encoder"
 	"github.com/kolesa-team/go-webp/webp"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 )
 
+// ConvertFormats are the formats Convert writes.
+var ConvertFormats = []string{"png", "jpg", "webp", "tiff", "bmp", "ico"}
+
+// Convert re-encodes data in format, one of ConvertFormats.
+func Convert(data []byte, format string) ([]byte, error) {
+	switch format {
+	case "png":
+		return ToPNG(data)
+	case "jpg":
+		return ToJPG(data)
+	case "webp":
+		return ToWEBP(data)
+	case "tiff":
+		return ToTIFF(data)
+	case "bmp":
+		return ToBMP(data)
+	case "ico":
+		return ToICO(data)
+	}
+	return nil, fmt.Errorf("unsupported output format %q", format)
+}
+
 func ToJPG(data []byte) ([]byte, error) {
 	img, err := decodeImage(data)
 	if err != nil {
 		return nil, err
 	}
+	return encodeJPEG(img, 90)
+}
 
-	var out bytes.Buffer
-	if err := jpeg.Encode(&out, img, &jpeg.Options{Quality: 90}); err != nil {
+func ToWEBP(data []byte) ([]byte, error) {
+	img, err := decodeImage(data)
+	if err != nil {
 		return nil, err
 	}
-	return out.Bytes(), nil
+	return encodeWEBP(img, 85)
 }
 
-func ToWEBP(data []byte) ([]byte, error) {
+func ToPNG(data []byte) ([]byte, error) {
 	img, err := decodeImage(data)
 	if err != nil {
 		return nil, err
 	}
 
-	opts, err := encoder.NewLossyEncoderOptions(encoder.PresetDefault, 85)
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func ToTIFF(data []byte) ([]byte, error) {
+	img, err := decodeImage(data)
 	if err != nil {
 		return nil, err
 	}
 
 	var out bytes.Buffer
-	if err := webp.Encode(&out, img, opts); err != nil {
+	if err := tiff.Encode(&out, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true}); err != nil {
 		return nil, err
 	}
 	return out.Bytes(), nil
 }
 
-func ToPNG(data []byte) ([]byte, error) {
+func ToBMP(data []byte) ([]byte, error) {
 	img, err := decodeImage(data)
 	if err != nil {
 		return nil, err
 	}
 
 	var out bytes.Buffer
-	if err := png.Encode(&out, img); err != nil {
+	if err := bmp.Encode(&out, img); err != nil {
 		return nil, err
 	}
 	return out.Bytes(), nil
@@ -85,12 +121,39 @@ func ToICOWithSizes(data []byte, sizes []int) ([]byte, error) {
 	return wrapPNGsAsICO(icons), nil
 }
 
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func encodeWEBP(img image.Image, quality int) ([]byte, error) {
+	opts, err := encoder.NewLossyEncoderOptions(encoder.PresetDefault, float32(quality))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := webp.Encode(&out, img, opts); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
 func decodeImage(data []byte) (image.Image, error) {
-	if isWEBP(data) {
+	switch DetectFormat(data) {
+	case "webp":
 		return webp.Decode(bytes.NewReader(data), &decoder.Options{})
-	}
-	if isICO(data) {
+	case "ico":
 		return decodeICO(data)
+	case "tiff":
+		return tiff.Decode(bytes.NewReader(data))
+	case "bmp":
+		return bmp.Decode(bytes.NewReader(data))
+	case "gif":
+		return gif.Decode(bytes.NewReader(data))
 	}
 
 	img, _, err := image.Decode(bytes.NewReader(data))
@@ -100,6 +163,50 @@ func decodeImage(data []byte) (image.Image, error) {
 	return img, nil
 }
 
+// DetectFormat sniffs the encoded format from magic bytes and returns one of
+// png, jpg, webp, ico, tiff, bmp or gif, or "" when the data is not recognized.
+func DetectFormat(data []byte) string {
+	switch {
+	case isPNG(data):
+		return "png"
+	case isJPEG(data):
+		return "jpg"
+	case isWEBP(data):
+		return "webp"
+	case isICO(data):
+		return "ico"
+	case isTIFF(data):
+		return "tiff"
+	case isBMP(data):
+		return "bmp"
+	case isGIF(data):
+		return "gif"
+	}
+	return ""
+}
+
+func isJPEG(data []byte) bool {
+	return len(data) >= 3 && data[0] == 0xff && data[1] == 0xd8 && data[2] == 0xff
+}
+
+func isTIFF(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	return bytes.Equal(data[:4], []byte{'I', 'I', 42, 0}) || bytes.Equal(data[:4], []byte{'M', 'M', 0, 42})
+}
+
+func isBMP(data []byte) bool {
+	return len(data) >= 14 && data[0] == 'B' && data[1] == 'M'
+}
+
+func isGIF(data []byte) bool {
+	if len(data) < 6 {
+		return false
+	}
+	return string(data[:6]) == "GIF87a" || string(data[:6]) == "GIF89a"
+}
+
 func isWEBP(data []byte) bool {
 	if len(data) < 12 {
 		return false
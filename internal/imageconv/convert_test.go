@@ -0,0 +1,27 @@
+package imageconv
+
+import "testing"
+
+func TestConvertWritesEachFormat(t *testing.T) {
+	data := testPNG(t, 40, 30)
+	for _, format := range ConvertFormats {
+		out, err := Convert(data, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		info, err := Probe(out)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		wantW, wantH := 40, 30
+		if format == "ico" {
+			wantW, wantH = 48, 48
+		}
+		if info.Format != format || info.Width != wantW || info.Height != wantH || info.MIMEType != MIMEType(format) {
+			t.Errorf("%s: probed %+v", format, info)
+		}
+	}
+	if _, err := Convert(data, "gif"); err == nil {
+		t.Error("converted to an unsupported format")
+	}
+}
@@ -0,0 +1,92 @@
+package imageconv
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	minBudgetQuality = 10
+	maxBudgetQuality = 100
+	budgetScaleStep  = 0.85
+	minBudgetEdge    = 16
+)
+
+var ErrMaxBytesUnreachable = errors.New("image cannot be encoded under the requested byte budget")
+
+// BudgetResult describes the encoding chosen to fit a byte budget.
+type BudgetResult struct {
+	Data    []byte
+	Quality int
+	Width   int
+	Height  int
+}
+
+func ToJPGWithMaxBytes(data []byte, maxBytes int) (BudgetResult, error) {
+	return encodeWithMaxBytes(data, maxBytes, encodeJPEG)
+}
+
+func ToWEBPWithMaxBytes(data []byte, maxBytes int) (BudgetResult, error) {
+	return encodeWithMaxBytes(data, maxBytes, encodeWEBP)
+}
+
+type qualityEncoder func(img image.Image, quality int) ([]byte, error)
+
+// encodeWithMaxBytes binary-searches the highest quality that fits under
+// maxBytes and, when even the lowest quality is too large, downscales the
+// image in steps until it fits or becomes too small to be useful.
+func encodeWithMaxBytes(data []byte, maxBytes int, encode qualityEncoder) (BudgetResult, error) {
+	if maxBytes <= 0 {
+		return BudgetResult{}, fmt.Errorf("max bytes must be > 0, got %d", maxBytes)
+	}
+	src, err := decodeImage(data)
+	if err != nil {
+		return BudgetResult{}, err
+	}
+
+	b := src.Bounds()
+	width, height := b.Dx(), b.Dy()
+	img := src
+	for {
+		out, quality, err := searchQuality(img, maxBytes, encode)
+		if err != nil {
+			return BudgetResult{}, err
+		}
+		if out != nil {
+			return BudgetResult{Data: out, Quality: quality, Width: width, Height: height}, nil
+		}
+
+		width = int(float64(width) * budgetScaleStep)
+		height = int(float64(height) * budgetScaleStep)
+		if width < minBudgetEdge || height < minBudgetEdge {
+			return BudgetResult{}, fmt.Errorf("%w (%d bytes)", ErrMaxBytesUnreachable, maxBytes)
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, b, xdraw.Src, nil)
+		img = dst
+	}
+}
+
+func searchQuality(img image.Image, maxBytes int, encode qualityEncoder) ([]byte, int, error) {
+	var best []byte
+	bestQuality := 0
+	lo, hi := minBudgetQuality, maxBudgetQuality
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		out, err := encode(img, mid)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(out) <= maxBytes {
+			best = out
+			bestQuality = mid
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+	return best, bestQuality, nil
+}
@@ -0,0 +1,154 @@
+package imageconv
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"slices"
+	"testing"
+)
+
+// testPNG returns a PNG of the given size filled with a pattern that does not
+// compress well.
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := range width {
+		for y := range height {
+			img.Set(x, y, color.RGBA{uint8(x * y), uint8(x ^ y), uint8(x + 3*y), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// sizedEncoder returns an encoder whose output is size(width, height,
+// quality) bytes long, recording every call.
+func sizedEncoder(size func(w, h, q int) int, calls *[]image.Point) qualityEncoder {
+	return func(img image.Image, quality int) ([]byte, error) {
+		b := img.Bounds()
+		*calls = append(*calls, image.Pt(b.Dx(), quality))
+		return make([]byte, size(b.Dx(), b.Dy(), quality)), nil
+	}
+}
+
+func TestSearchQualityFindsHighestFit(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	byQuality := func(w, h, q int) int { return q * 100 }
+	for _, tc := range []struct {
+		maxBytes int
+		want     int
+	}{
+		{10000, maxBudgetQuality},
+		{5050, 50},
+		{5000, 50},
+		{4999, 49},
+		{minBudgetQuality * 100, minBudgetQuality},
+		{minBudgetQuality*100 - 1, 0},
+	} {
+		var calls []image.Point
+		out, quality, err := searchQuality(img, tc.maxBytes, sizedEncoder(byQuality, &calls))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if quality != tc.want || (tc.want == 0) != (out == nil) {
+			t.Errorf("budget %d: quality %d (output %d bytes), want %d", tc.maxBytes, quality, len(out), tc.want)
+		}
+		if out != nil && len(out) != quality*100 {
+			t.Errorf("budget %d: returned the output of another quality", tc.maxBytes)
+		}
+		// A binary search over 91 qualities needs at most 7 encodes.
+		if len(calls) > 7 {
+			t.Errorf("budget %d: %d encodes", tc.maxBytes, len(calls))
+		}
+	}
+}
+
+func TestEncodeWithMaxBytesDownscalesUntilItFits(t *testing.T) {
+	data := testPNG(t, 100, 80)
+	// Output size depends only on the pixel count, so quality cannot help.
+	byPixels := func(w, h, q int) int { return w * h }
+	var calls []image.Point
+	result, err := encodeWithMaxBytes(data, 4000, sizedEncoder(byPixels, &calls))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 100x80, 85x68 and 72x57 are too large; 61x48 is the first that fits.
+	if result.Width != 61 || result.Height != 48 || result.Quality != maxBudgetQuality || len(result.Data) != 61*48 {
+		t.Fatalf("result = %dx%d at quality %d, %d bytes", result.Width, result.Height, result.Quality, len(result.Data))
+	}
+	widths := []int{}
+	for _, c := range calls {
+		if len(widths) == 0 || widths[len(widths)-1] != c.X {
+			widths = append(widths, c.X)
+		}
+	}
+	if want := []int{100, 85, 72, 61}; !slices.Equal(widths, want) {
+		t.Fatalf("tried widths %v, want %v", widths, want)
+	}
+}
+
+func TestEncodeWithMaxBytesStopsAtMinimumEdge(t *testing.T) {
+	data := testPNG(t, 64, 32)
+	var calls []image.Point
+	_, err := encodeWithMaxBytes(data, 10, sizedEncoder(func(w, h, q int) int { return w * h }, &calls))
+	if !errors.Is(err, ErrMaxBytesUnreachable) {
+		t.Fatalf("error = %v, want ErrMaxBytesUnreachable", err)
+	}
+	smallest := calls[len(calls)-1].X
+	// 64x32 shrinks to 54x27, 45x22 and 38x18; the next step, 32x15, is
+	// below minBudgetEdge and is not tried.
+	if smallest != 38 {
+		t.Fatalf("smallest width tried = %d, want 38", smallest)
+	}
+	if _, err := encodeWithMaxBytes(data, 0, encodeJPEG); err == nil {
+		t.Fatal("a budget of 0 was accepted")
+	}
+}
+
+func TestToJPGAndWEBPWithMaxBytes(t *testing.T) {
+	data := testPNG(t, 256, 256)
+	for name, tc := range map[string]struct {
+		fit    func([]byte, int) (BudgetResult, error)
+		encode qualityEncoder
+	}{
+		"jpg":  {ToJPGWithMaxBytes, encodeJPEG},
+		"webp": {ToWEBPWithMaxBytes, encodeWEBP},
+	} {
+		t.Run(name, func(t *testing.T) {
+			const budget = 12000
+			result, err := tc.fit(data, budget)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Data) > budget {
+				t.Fatalf("%d bytes over a budget of %d", len(result.Data), budget)
+			}
+			info, err := Probe(result.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Format != name || info.Width != result.Width || info.Height != result.Height {
+				t.Fatalf("probed %+v, result %dx%d", info, result.Width, result.Height)
+			}
+			if result.Width == 256 && result.Quality < maxBudgetQuality {
+				img, err := decodeImage(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				next, err := tc.encode(img, result.Quality+1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(next) <= budget {
+					t.Fatalf("quality %d also fits (%d bytes)", result.Quality+1, len(next))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package imageconv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/tiff"
+)
+
+const metersPerInch = 0.0254
+
+// Limits on print exports. The output is held in memory as RGBA, so
+// MaxPrintPixels bounds it to about 400 MB.
+const (
+	MinPrintDPI    = 72
+	MaxPrintDPI    = 1200
+	MaxPrintPixels = 100_000_000
+)
+
+// PrintOptions describes a physical print size. Format is one of png, jpg or tiff.
+type PrintOptions struct {
+	WidthInches  float64
+	HeightInches float64
+	DPI          int
+	Format       string
+}
+
+type PrintResult struct {
+	Data     []byte
+	MIMEType string
+	Width    int
+	Height   int
+	Warnings []string
+}
+
+// PrintPixelSize returns the pixel dimensions needed for a physical size at dpi.
+func PrintPixelSize(widthInches float64, heightInches float64, dpi int) (int, int) {
+	return int(math.Round(widthInches * float64(dpi))), int(math.Round(heightInches * float64(dpi)))
+}
+
+// ToPrint center-crops the source to the requested aspect ratio, resamples it
+// to the pixel size implied by the physical size and DPI, and writes the DPI
+// into the output's resolution metadata.
+func ToPrint(data []byte, opts PrintOptions) (PrintResult, error) {
+	if !(opts.WidthInches > 0) || !(opts.HeightInches > 0) {
+		return PrintResult{}, errors.New("print size must be positive")
+	}
+	if opts.DPI < MinPrintDPI || opts.DPI > MaxPrintDPI {
+		return PrintResult{}, fmt.Errorf("print dpi must be between %d and %d", MinPrintDPI, MaxPrintDPI)
+	}
+	switch opts.Format {
+	case "png", "jpg", "jpeg", "tiff", "tif":
+	default:
+		return PrintResult{}, fmt.Errorf("unsupported print format %q (use png, jpg or tiff)", opts.Format)
+	}
+	// Check the pixel count in floating point so a huge size cannot overflow
+	// before the output buffer is allocated.
+	if pixels := opts.WidthInches * opts.HeightInches * float64(opts.DPI) * float64(opts.DPI); pixels > MaxPrintPixels {
+		return PrintResult{}, fmt.Errorf("print size %.2fx%.2f in at %d dpi needs %.0f megapixels; the limit is %d",
+			opts.WidthInches, opts.HeightInches, opts.DPI, pixels/1e6, MaxPrintPixels/1_000_000)
+	}
+	width, height := PrintPixelSize(opts.WidthInches, opts.HeightInches, opts.DPI)
+	if width < 1 || height < 1 {
+		return PrintResult{}, fmt.Errorf("print size %.2fx%.2f in at %d dpi is too small", opts.WidthInches, opts.HeightInches, opts.DPI)
+	}
+
+	src, err := decodeImage(data)
+	if err != nil {
+		return PrintResult{}, err
+	}
+	crop := cropToAspect(src.Bounds(), width, height)
+
+	var warnings []string
+	if crop.Dx() < width || crop.Dy() < height {
+		effective := int(math.Min(float64(crop.Dx())/opts.WidthInches, float64(crop.Dy())/opts.HeightInches))
+		warnings = append(warnings, fmt.Sprintf(
+			"source provides %dx%d px but %.2fx%.2f in at %d dpi needs %dx%d px (effective %d dpi)",
+			crop.Dx(), crop.Dy(), opts.WidthInches, opts.HeightInches, opts.DPI, width, height, effective,
+		))
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, xdraw.Src, nil)
+
+	var out []byte
+	var format string
+	switch opts.Format {
+	case "png":
+		out, err = encodePNGWithDPI(dst, opts.DPI)
+		format = "png"
+	case "jpg", "jpeg":
+		out, err = encodeJPEGWithDPI(dst, 95, opts.DPI)
+		format = "jpg"
+	case "tiff", "tif":
+		out, err = encodeTIFFWithDPI(dst, opts.DPI)
+		format = "tiff"
+	}
+	if err != nil {
+		return PrintResult{}, err
+	}
+	return PrintResult{Data: out, MIMEType: MIMEType(format), Width: width, Height: height, Warnings: warnings}, nil
+}
+
+func cropToAspect(b image.Rectangle, width int, height int) image.Rectangle {
+	srcW, srcH := b.Dx(), b.Dy()
+	if srcW*height > srcH*width {
+		cropW := srcH * width / height
+		x0 := b.Min.X + (srcW-cropW)/2
+		return image.Rect(x0, b.Min.Y, x0+cropW, b.Max.Y)
+	}
+	cropH := srcW * height / width
+	y0 := b.Min.Y + (srcH-cropH)/2
+	return image.Rect(b.Min.X, y0, b.Max.X, y0+cropH)
+}
+
+// encodePNGWithDPI inserts a pHYs chunk right after IHDR.
+func encodePNGWithDPI(img image.Image, dpi int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd {
+		return nil, errors.New("png encoder produced a truncated header")
+	}
+
+	ppm := uint32(math.Round(float64(dpi) / metersPerInch))
+	chunk := make([]byte, 4+4+9+4)
+	binary.BigEndian.PutUint32(chunk[0:4], 9)
+	copy(chunk[4:8], "pHYs")
+	binary.BigEndian.PutUint32(chunk[8:12], ppm)
+	binary.BigEndian.PutUint32(chunk[12:16], ppm)
+	chunk[16] = 1 // unit: meter
+	binary.BigEndian.PutUint32(chunk[17:21], crc32.ChecksumIEEE(chunk[4:17]))
+
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	out = append(out, data[ihdrEnd:]...)
+	return out, nil
+}
+
+// encodeJPEGWithDPI inserts (or replaces) a JFIF APP0 segment carrying the density.
+func encodeJPEGWithDPI(img image.Image, quality int, dpi int) ([]byte, error) {
+	data, err := encodeJPEG(img, quality)
+	if err != nil {
+		return nil, err
+	}
+	if !isJPEG(data) {
+		return nil, errors.New("jpeg encoder produced an invalid header")
+	}
+
+	density := uint16(min(dpi, math.MaxUint16))
+	app0 := []byte{
+		0xff, 0xe0, 0x00, 0x10,
+		'J', 'F', 'I', 'F', 0x00,
+		0x01, 0x01, // version 1.01
+		0x01, // units: dots per inch
+		byte(density >> 8), byte(density),
+		byte(density >> 8), byte(density),
+		0x00, 0x00, // no thumbnail
+	}
+
+	rest := data[2:]
+	if len(rest) >= 4 && rest[0] == 0xff && rest[1] == 0xe0 {
+		segLen := int(binary.BigEndian.Uint16(rest[2:4]))
+		if 2+segLen <= len(rest) {
+			rest = rest[2+segLen:]
+		}
+	}
+
+	out := make([]byte, 0, len(data)+len(app0))
+	out = append(out, data[:2]...)
+	out = append(out, app0...)
+	out = append(out, rest...)
+	return out, nil
+}
+
+// encodeTIFFWithDPI rewrites the XResolution/YResolution rationals that the
+// x/image encoder always sets to 72 dpi.
+func encodeTIFFWithDPI(img image.Image, dpi int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tiff.Encode(&buf, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true}); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	if err := setTIFFResolution(data, uint32(dpi)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func setTIFFResolution(data []byte, dpi uint32) error {
+	const (
+		tagXResolution = 282
+		tagYResolution = 283
+		typeRational   = 5
+		entrySize      = 12
+	)
+	if len(data) < 8 {
+		return errors.New("invalid tiff: header too short")
+	}
+	var order binary.ByteOrder
+	switch string(data[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return errors.New("invalid tiff: unknown byte order")
+	}
+
+	ifd := int(order.Uint32(data[4:8]))
+	if ifd+2 > len(data) {
+		return errors.New("invalid tiff: truncated ifd")
+	}
+	count := int(order.Uint16(data[ifd : ifd+2]))
+	if ifd+2+count*entrySize > len(data) {
+		return errors.New("invalid tiff: truncated ifd entries")
+	}
+
+	patched := 0
+	for i := 0; i < count; i++ {
+		entry := data[ifd+2+i*entrySize : ifd+2+(i+1)*entrySize]
+		tag := order.Uint16(entry[0:2])
+		if tag != tagXResolution && tag != tagYResolution {
+			continue
+		}
+		if order.Uint16(entry[2:4]) != typeRational {
+			return fmt.Errorf("invalid tiff: resolution tag %d is not rational", tag)
+		}
+		offset := int(order.Uint32(entry[8:12]))
+		if offset+8 > len(data) {
+			return errors.New("invalid tiff: resolution value out of range")
+		}
+		order.PutUint32(data[offset:offset+4], dpi)
+		order.PutUint32(data[offset+4:offset+8], 1)
+		patched++
+	}
+	if patched != 2 {
+		return errors.New("invalid tiff: resolution tags not found")
+	}
+	return nil
+}
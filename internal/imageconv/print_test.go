@@ -0,0 +1,126 @@
+package imageconv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// pngDensity returns the pixels per unit and unit of the pHYs chunk.
+func pngDensity(t *testing.T, data []byte) (x, y uint32, unit byte) {
+	t.Helper()
+	for pos := 8; pos+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		if pos+12+length > len(data) {
+			break
+		}
+		if string(data[pos+4:pos+8]) == "pHYs" && length == 9 {
+			body := data[pos+8 : pos+17]
+			return binary.BigEndian.Uint32(body[0:4]), binary.BigEndian.Uint32(body[4:8]), body[8]
+		}
+		pos += 12 + length
+	}
+	t.Fatal("no pHYs chunk")
+	return 0, 0, 0
+}
+
+// jpegDensity returns the density and units of the JFIF APP0 segment.
+func jpegDensity(t *testing.T, data []byte) (x, y uint16, units byte) {
+	t.Helper()
+	if len(data) < 20 || data[2] != 0xff || data[3] != 0xe0 || string(data[6:11]) != "JFIF\x00" {
+		t.Fatal("no JFIF segment after SOI")
+	}
+	return binary.BigEndian.Uint16(data[14:16]), binary.BigEndian.Uint16(data[16:18]), data[13]
+}
+
+// tiffResolution returns the XResolution and YResolution rationals of the
+// first IFD as floats, and its ResolutionUnit.
+func tiffResolution(t *testing.T, data []byte) (x, y float64, unit uint16) {
+	t.Helper()
+	var order binary.ByteOrder = binary.LittleEndian
+	if string(data[0:2]) == "MM" {
+		order = binary.BigEndian
+	}
+	ifd := int(order.Uint32(data[4:8]))
+	count := int(order.Uint16(data[ifd : ifd+2]))
+	rational := func(entry []byte) float64 {
+		off := int(order.Uint32(entry[8:12]))
+		return float64(order.Uint32(data[off:off+4])) / float64(order.Uint32(data[off+4:off+8]))
+	}
+	for i := range count {
+		entry := data[ifd+2+i*12 : ifd+2+(i+1)*12]
+		switch order.Uint16(entry[0:2]) {
+		case 282:
+			x = rational(entry)
+		case 283:
+			y = rational(entry)
+		case 296:
+			unit = order.Uint16(entry[8:10])
+		}
+	}
+	if x == 0 || y == 0 {
+		t.Fatal("no resolution tags")
+	}
+	return x, y, unit
+}
+
+func TestToPrintWritesResolution(t *testing.T) {
+	data := testPNG(t, 400, 300)
+	for _, dpi := range []int{MinPrintDPI, 300, MaxPrintDPI} {
+		opts := PrintOptions{WidthInches: 0.5, HeightInches: 0.25, DPI: dpi}
+		wantW, wantH := PrintPixelSize(opts.WidthInches, opts.HeightInches, dpi)
+
+		opts.Format = "png"
+		result, err := ToPrint(data, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, y, unit := pngDensity(t, result.Data)
+		if ppm := uint32(math.Round(float64(dpi) / metersPerInch)); x != ppm || y != ppm || unit != 1 {
+			t.Errorf("png at %d dpi: pHYs %dx%d unit %d, want %d per meter", dpi, x, y, unit, ppm)
+		}
+		if result.MIMEType != "image/png" || result.Width != wantW || result.Height != wantH {
+			t.Errorf("png at %d dpi: %s %dx%d, want %dx%d", dpi, result.MIMEType, result.Width, result.Height, wantW, wantH)
+		}
+
+		opts.Format = "jpeg"
+		result, err = ToPrint(data, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jx, jy, units := jpegDensity(t, result.Data)
+		if int(jx) != dpi || int(jy) != dpi || units != 1 {
+			t.Errorf("jpg at %d dpi: JFIF density %dx%d units %d", dpi, jx, jy, units)
+		}
+		if bytes.Count(result.Data, []byte("JFIF\x00")) != 1 || result.MIMEType != "image/jpeg" {
+			t.Errorf("jpg at %d dpi: %d JFIF segments, %s", dpi, bytes.Count(result.Data, []byte("JFIF\x00")), result.MIMEType)
+		}
+
+		opts.Format = "tif"
+		result, err = ToPrint(data, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rx, ry, runit := tiffResolution(t, result.Data)
+		if rx != float64(dpi) || ry != float64(dpi) || runit != 2 {
+			t.Errorf("tiff at %d dpi: resolution %gx%g unit %d", dpi, rx, ry, runit)
+		}
+		if result.MIMEType != "image/tiff" {
+			t.Errorf("tiff at %d dpi: %s", dpi, result.MIMEType)
+		}
+		info, err := Probe(result.Data)
+		if err != nil || info.Width != wantW || info.Height != wantH {
+			t.Errorf("tiff at %d dpi: probed %+v, %v", dpi, info, err)
+		}
+	}
+}
+
+func TestToPrintRejectsDPIOutOfRange(t *testing.T) {
+	data := testPNG(t, 40, 40)
+	for _, dpi := range []int{MinPrintDPI - 1, MaxPrintDPI + 1} {
+		if _, err := ToPrint(data, PrintOptions{WidthInches: 1, HeightInches: 1, DPI: dpi, Format: "png"}); err == nil {
+			t.Errorf("%d dpi accepted", dpi)
+		}
+	}
+}
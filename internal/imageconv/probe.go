@@ -0,0 +1,69 @@
+package imageconv
+
+import (
+	"bytes"
+	"errors"
+	"image"
+
+	"github.com/kolesa-team/go-webp/decoder"
+	"github.com/kolesa-team/go-webp/webp"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// Info describes an encoded image without decoding its pixels.
+type Info struct {
+	Format   string
+	MIMEType string
+	Width    int
+	Height   int
+}
+
+var mimeTypes = map[string]string{
+	"png":  "image/png",
+	"jpg":  "image/jpeg",
+	"webp": "image/webp",
+	"ico":  "image/x-icon",
+	"tiff": "image/tiff",
+	"bmp":  "image/bmp",
+	"gif":  "image/gif",
+}
+
+// MIMEType returns the media type of a format name as Probe reports it, or
+// "" for an unknown format.
+func MIMEType(format string) string {
+	return mimeTypes[format]
+}
+
+// Probe detects the format of an encoded image and reads its dimensions from
+// the header. For ICO files it reports the largest entry.
+func Probe(data []byte) (Info, error) {
+	format := DetectFormat(data)
+	if format == "" {
+		return Info{}, errors.New("unrecognized image format")
+	}
+	info := Info{Format: format, MIMEType: mimeTypes[format]}
+	var cfg image.Config
+	var err error
+	switch format {
+	case "webp":
+		cfg, err = webp.DecodeConfig(bytes.NewReader(data), &decoder.Options{})
+	case "ico":
+		var img image.Image
+		img, err = decodeICO(data)
+		if err == nil {
+			cfg.Width, cfg.Height = img.Bounds().Dx(), img.Bounds().Dy()
+		}
+	case "tiff":
+		cfg, err = tiff.DecodeConfig(bytes.NewReader(data))
+	case "bmp":
+		cfg, err = bmp.DecodeConfig(bytes.NewReader(data))
+	default:
+		cfg, _, err = image.DecodeConfig(bytes.NewReader(data))
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	info.Width, info.Height = cfg.Width, cfg.Height
+	return info, nil
+}
@@ -0,0 +1,139 @@
+package webapp
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Archiving hides a record from lists without touching its history. Purging
+// deletes it for good and is only allowed once the record is archived; the
+// schema's ON DELETE CASCADE keys remove dependent rows. Image files are left
+// on disk for the garbage collector.
+
+func (s *Store) ArchiveBrand(slug string) error {
+	return s.setArchived(`UPDATE brands SET archived_at = ? WHERE slug = ?;`, true, Slugify(slug))
+}
+
+func (s *Store) RestoreBrand(slug string) error {
+	return s.setArchived(`UPDATE brands SET archived_at = ? WHERE slug = ?;`, false, Slugify(slug))
+}
+
+func (s *Store) ArchiveProject(slug string) error {
+	return s.setArchived(`UPDATE projects SET archived_at = ? WHERE slug = ?;`, true, Slugify(slug))
+}
+
+func (s *Store) RestoreProject(slug string) error {
+	return s.setArchived(`UPDATE projects SET archived_at = ? WHERE slug = ?;`, false, Slugify(slug))
+}
+
+const archiveWorkItemSQL = `
+	UPDATE work_items SET archived_at = ?
+	WHERE id IN (
+		SELECT w.id
+		FROM work_items w
+		JOIN projects p ON p.id = w.project_id
+		WHERE p.slug = ? AND w.slug = ?
+	);`
+
+func (s *Store) ArchiveWorkItem(projectSlug string, itemSlug string) error {
+	return s.setArchived(archiveWorkItemSQL, true, Slugify(projectSlug), Slugify(itemSlug))
+}
+
+func (s *Store) RestoreWorkItem(projectSlug string, itemSlug string) error {
+	return s.setArchived(archiveWorkItemSQL, false, Slugify(projectSlug), Slugify(itemSlug))
+}
+
+func (s *Store) setArchived(query string, archived bool, args ...any) error {
+	var at sql.NullString
+	if archived {
+		at = sql.NullString{String: nowText(), Valid: true}
+	}
+	res, err := s.db.Exec(query, append([]any{at}, args...)...)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+// PurgeBrand deletes an archived brand with its versions and assets. Brands
+// still used as a project default or work item override are refused.
+func (s *Store) PurgeBrand(slug string) error {
+	slug = Slugify(slug)
+	err := s.withTx(func(tx *sql.Tx) error {
+		var id int64
+		var archived sql.NullString
+		err := tx.QueryRow(`SELECT id, archived_at FROM brands WHERE slug = ?;`, slug).Scan(&id, &archived)
+		if err != nil {
+			return notFound(err)
+		}
+		if !archived.Valid {
+			return fmt.Errorf("archive brand %q before purging it", slug)
+		}
+		var projects, items int
+		if err := tx.QueryRow(`
+			SELECT
+				(SELECT COUNT(*) FROM projects WHERE default_brand_id = ?),
+				(SELECT COUNT(*) FROM work_items WHERE brand_id = ?);
+		`, id, id).Scan(&projects, &items); err != nil {
+			return err
+		}
+		if projects > 0 || items > 0 {
+			return fmt.Errorf("brand %q is still used by %d project(s) and %d work item(s)", slug, projects, items)
+		}
+		_, err = tx.Exec(`DELETE FROM brands WHERE id = ?;`, id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(s.Root, brandAssetDir(slug)))
+}
+
+// PurgeProject deletes an archived project with all of its work items, jobs,
+// runs and image rows.
+func (s *Store) PurgeProject(slug string) error {
+	slug = Slugify(slug)
+	return s.withTx(func(tx *sql.Tx) error {
+		var id int64
+		var archived sql.NullString
+		err := tx.QueryRow(`SELECT id, archived_at FROM projects WHERE slug = ?;`, slug).Scan(&id, &archived)
+		if err != nil {
+			return notFound(err)
+		}
+		if !archived.Valid {
+			return fmt.Errorf("archive project %q before purging it", slug)
+		}
+		_, err = tx.Exec(`DELETE FROM projects WHERE id = ?;`, id)
+		return err
+	})
+}
+
+// PurgeWorkItem deletes an archived work item with its jobs, runs, prompt
+// revisions and image rows.
+func (s *Store) PurgeWorkItem(projectSlug string, itemSlug string) error {
+	projectSlug = Slugify(projectSlug)
+	itemSlug = Slugify(itemSlug)
+	return s.withTx(func(tx *sql.Tx) error {
+		var id int64
+		var archived sql.NullString
+		err := tx.QueryRow(`
+			SELECT w.id, w.archived_at
+			FROM work_items w
+			JOIN projects p ON p.id = w.project_id
+			WHERE p.slug = ? AND w.slug = ?;
+		`, projectSlug, itemSlug).Scan(&id, &archived)
+		if err != nil {
+			return notFound(err)
+		}
+		if !archived.Valid {
+			return fmt.Errorf("archive work item %q before purging it", itemSlug)
+		}
+		_, err = tx.Exec(`DELETE FROM work_items WHERE id = ?;`, id)
+		return err
+	})
+}
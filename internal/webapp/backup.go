@@ -0,0 +1,439 @@
+package webapp
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Backups are gzip-compressed tarballs whose first entry is manifest.json.
+// The manifest lists every file of the snapshot with its checksum and the
+// archive holding its bytes, so an incremental archive only carries files
+// that changed since its base and restore follows the chain.
+const (
+	backupFormatVersion = 1
+	backupManifestName  = "manifest.json"
+	backupDBName        = "imagegen.db"
+	backupArchiveGlob   = "imagegen-backup-*.tar.gz"
+)
+
+type BackupManifest struct {
+	FormatVersion int          `json:"format_version"`
+	Name          string       `json:"name"`
+	CreatedAt     string       `json:"created_at"`
+	SchemaVersion int          `json:"schema_version"`
+	Kind          string       `json:"kind"`
+	Base          string       `json:"base,omitempty"`
+	Files         []BackupFile `json:"files"`
+}
+
+type BackupFile struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+	Archive string `json:"archive"`
+}
+
+// Backup writes a snapshot of the database, images and brand assets into
+// outDir and returns the archive path. The database is copied with VACUUM
+// INTO, so the server may keep running. With incremental set, files unchanged
+// since the newest archive in outDir are referenced rather than copied.
+func (s *Store) Backup(outDir string, incremental bool) (string, BackupManifest, error) {
+	if outDir == "" {
+		outDir = filepath.Join(s.Root, "backups")
+	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return "", BackupManifest{}, err
+	}
+
+	var base *BackupManifest
+	var baseName string
+	if incremental {
+		latest, err := latestBackup(outDir)
+		if err != nil {
+			return "", BackupManifest{}, err
+		}
+		if latest == "" {
+			return "", BackupManifest{}, fmt.Errorf("no earlier backup in %s to build an incremental backup on", outDir)
+		}
+		m, err := readBackupManifest(latest)
+		if err != nil {
+			return "", BackupManifest{}, err
+		}
+		base, baseName = &m, filepath.Base(latest)
+	}
+
+	snapshot := filepath.Join(outDir, fmt.Sprintf(".snapshot-%d.db", time.Now().UnixNano()))
+	if _, err := s.db.Exec(`VACUUM INTO ?;`, snapshot); err != nil {
+		return "", BackupManifest{}, err
+	}
+	defer os.Remove(snapshot)
+
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return "", BackupManifest{}, err
+	}
+	kind := "full"
+	if base != nil {
+		kind = "incremental"
+	}
+	stamp := time.Now().UTC().Format("20060102T150405.000Z")
+	name := fmt.Sprintf("imagegen-backup-%s-%s.tar.gz", stamp, kind)
+	manifest := BackupManifest{
+		FormatVersion: backupFormatVersion,
+		Name:          name,
+		CreatedAt:     nowText(),
+		SchemaVersion: version,
+		Kind:          kind,
+		Base:          baseName,
+	}
+
+	// sources maps archive paths to files on disk for entries stored in this
+	// archive.
+	sources := map[string]string{}
+	add := func(rel string, abs string) error {
+		sum, size, err := hashFile(abs)
+		if err != nil {
+			return err
+		}
+		entry := BackupFile{Path: rel, Size: size, SHA256: sum, Archive: name}
+		if base != nil {
+			for _, prev := range base.Files {
+				if prev.Path == rel && prev.SHA256 == sum {
+					entry.Archive = prev.Archive
+					break
+				}
+			}
+		}
+		if entry.Archive == name {
+			sources[rel] = abs
+		}
+		manifest.Files = append(manifest.Files, entry)
+		return nil
+	}
+	if err := add(backupDBName, snapshot); err != nil {
+		return "", BackupManifest{}, err
+	}
+	for _, dir := range []string{"images", "brand-assets"} {
+		root := filepath.Join(s.Root, dir)
+		err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if errors.Is(err, fs.ErrNotExist) && p == root {
+				return fs.SkipAll
+			}
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(s.Root, p)
+			if err != nil {
+				return err
+			}
+			return add(filepath.ToSlash(rel), p)
+		})
+		if err != nil {
+			return "", BackupManifest{}, err
+		}
+	}
+
+	archivePath := filepath.Join(outDir, name)
+	if err := writeBackupArchive(archivePath, manifest, sources); err != nil {
+		_ = os.Remove(archivePath)
+		return "", BackupManifest{}, err
+	}
+	return archivePath, manifest, nil
+}
+
+func writeBackupArchive(path string, manifest BackupManifest, sources map[string]string) error {
+	tmp := path + ".partial"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	raw, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: backupManifestName, Mode: 0o644, Size: int64(len(raw)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(raw); err != nil {
+		return err
+	}
+	for _, entry := range manifest.Files {
+		abs, ok := sources[entry.Path]
+		if !ok {
+			continue
+		}
+		if err := copyIntoTar(tw, entry, abs); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func copyIntoTar(tw *tar.Writer, entry BackupFile, abs string) error {
+	src, err := os.Open(abs)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := tw.WriteHeader(&tar.Header{Name: entry.Path, Mode: 0o644, Size: entry.Size, ModTime: time.Now()}); err != nil {
+		return err
+	}
+	// The size was fixed when hashing; a file that changed since would
+	// corrupt the tar stream, so copy exactly that many bytes.
+	_, err = io.CopyN(tw, src, entry.Size)
+	return err
+}
+
+// VerifyBackup checks every file of the snapshot against the manifest
+// checksums, following incremental archives back to their full base.
+func VerifyBackup(archivePath string) (BackupManifest, error) {
+	manifest, err := readBackupManifest(archivePath)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+	return manifest, extractBackup(archivePath, manifest, "")
+}
+
+// RestoreBackup verifies an archive and unpacks it into dataRoot. A non-empty
+// data root is only replaced with force; its previous contents are moved to a
+// sibling directory, and its backups/ folder is carried over. The server must
+// not be running.
+func RestoreBackup(archivePath string, dataRoot string, force bool) (BackupManifest, string, error) {
+	manifest, err := readBackupManifest(archivePath)
+	if err != nil {
+		return BackupManifest{}, "", err
+	}
+	if latest := migrations[len(migrations)-1].Version; manifest.SchemaVersion > latest {
+		return manifest, "", fmt.Errorf("backup has schema version %d; this build supports up to %d", manifest.SchemaVersion, latest)
+	}
+	entries, err := os.ReadDir(dataRoot)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return manifest, "", err
+	}
+	if len(entries) > 0 && !force {
+		return manifest, "", fmt.Errorf("data root %s is not empty; pass -force to replace it", dataRoot)
+	}
+
+	stamp := time.Now().UTC().Format("20060102T150405")
+	staging := dataRoot + ".restore-" + stamp
+	if err := os.MkdirAll(staging, 0o755); err != nil {
+		return manifest, "", err
+	}
+	if err := extractBackup(archivePath, manifest, staging); err != nil {
+		_ = os.RemoveAll(staging)
+		return manifest, "", err
+	}
+
+	previous := ""
+	if len(entries) > 0 {
+		previous = dataRoot + ".pre-restore-" + stamp
+		if err := os.Rename(dataRoot, previous); err != nil {
+			_ = os.RemoveAll(staging)
+			return manifest, "", err
+		}
+	} else if err := os.Remove(dataRoot); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return manifest, "", err
+	}
+	if err := os.Rename(staging, dataRoot); err != nil {
+		return manifest, previous, err
+	}
+	if previous != "" {
+		oldBackups := filepath.Join(previous, "backups")
+		if _, err := os.Stat(oldBackups); err == nil {
+			if err := os.Rename(oldBackups, filepath.Join(dataRoot, "backups")); err != nil {
+				return manifest, previous, err
+			}
+		}
+	}
+	return manifest, previous, nil
+}
+
+// extractBackup reads every manifest entry from the archive that holds it
+// and checks its checksum. With dest empty nothing is written.
+func extractBackup(archivePath string, manifest BackupManifest, dest string) error {
+	dir := filepath.Dir(archivePath)
+	byArchive := map[string]map[string]BackupFile{}
+	for _, entry := range manifest.Files {
+		if !filepath.IsLocal(filepath.FromSlash(entry.Path)) {
+			return fmt.Errorf("unsafe path %q in manifest", entry.Path)
+		}
+		// Archives are looked up next to this one, so the name must not
+		// reach into another directory.
+		if ok, _ := filepath.Match(backupArchiveGlob, entry.Archive); !ok || filepath.Base(entry.Archive) != entry.Archive {
+			return fmt.Errorf("unsafe archive name %q in manifest", entry.Archive)
+		}
+		if byArchive[entry.Archive] == nil {
+			byArchive[entry.Archive] = map[string]BackupFile{}
+		}
+		byArchive[entry.Archive][entry.Path] = entry
+	}
+	names := make([]string, 0, len(byArchive))
+	for name := range byArchive {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		wanted := byArchive[name]
+		// The archive's own entries are read from the given path even if
+		// the file has been renamed since.
+		path := filepath.Join(dir, name)
+		if name == manifest.Name {
+			path = archivePath
+		}
+		if err := readBackupEntries(path, func(hdr *tar.Header, r io.Reader) error {
+			entry, ok := wanted[hdr.Name]
+			if !ok {
+				return nil
+			}
+			delete(wanted, hdr.Name)
+			return restoreEntry(entry, r, dest)
+		}); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if len(wanted) > 0 {
+			missing := make([]string, 0, len(wanted))
+			for p := range wanted {
+				missing = append(missing, p)
+			}
+			sort.Strings(missing)
+			return fmt.Errorf("%s: missing %d file(s), first %s", name, len(missing), missing[0])
+		}
+	}
+	return nil
+}
+
+func restoreEntry(entry BackupFile, r io.Reader, dest string) error {
+	h := sha256.New()
+	var w io.Writer = h
+	var out *os.File
+	if dest != "" {
+		target := filepath.Join(dest, filepath.FromSlash(entry.Path))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		f, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		out = f
+		defer out.Close()
+		w = io.MultiWriter(h, f)
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return err
+	}
+	if n != entry.Size || hex.EncodeToString(h.Sum(nil)) != entry.SHA256 {
+		return fmt.Errorf("checksum mismatch for %s", entry.Path)
+	}
+	if out != nil {
+		return out.Close()
+	}
+	return nil
+}
+
+func readBackupManifest(archivePath string) (BackupManifest, error) {
+	var manifest BackupManifest
+	found := false
+	errStop := errors.New("stop")
+	err := readBackupEntries(archivePath, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Name != backupManifestName {
+			return fmt.Errorf("first entry is %q, expected %s", hdr.Name, backupManifestName)
+		}
+		if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+			return fmt.Errorf("parse manifest: %w", err)
+		}
+		found = true
+		return errStop
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return BackupManifest{}, fmt.Errorf("%s: %w", filepath.Base(archivePath), err)
+	}
+	if !found {
+		return BackupManifest{}, fmt.Errorf("%s: no manifest", filepath.Base(archivePath))
+	}
+	if manifest.FormatVersion != backupFormatVersion {
+		return BackupManifest{}, fmt.Errorf("%s: unsupported backup format %d", filepath.Base(archivePath), manifest.FormatVersion)
+	}
+	return manifest, nil
+}
+
+func readBackupEntries(archivePath string, fn func(*tar.Header, io.Reader) error) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func latestBackup(dir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, backupArchiveGlob))
+	if err != nil || len(matches) == 0 {
+		return "", err
+	}
+	sort.Strings(matches)
+	return matches[len(matches)-1], nil
+}
+
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
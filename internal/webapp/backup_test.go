@@ -0,0 +1,231 @@
+package webapp
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeDataFile writes content to rel under root, creating directories.
+func writeDataFile(t *testing.T, root string, rel string, content string) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// archiveOf returns the archive each manifest entry is stored in.
+func archiveOf(manifest BackupManifest) map[string]string {
+	archives := map[string]string{}
+	for _, f := range manifest.Files {
+		archives[f.Path] = f.Archive
+	}
+	return archives
+}
+
+// rewriteManifest replaces the manifest of a backup archive with the result
+// of edit, keeping every other entry.
+func rewriteManifest(t *testing.T, archivePath string, edit func(*BackupManifest)) {
+	t.Helper()
+	manifest, err := readBackupManifest(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(&manifest)
+	raw, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	if err := tw.WriteHeader(&tar.Header{Name: backupManifestName, Mode: 0o644, Size: int64(len(raw))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+	err = readBackupEntries(archivePath, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Name == backupManifestName {
+			return nil
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, r)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archivePath, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupIncrementalChainAndRestore(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.CreateBrand("Acme", "Bold."); err != nil {
+		t.Fatal(err)
+	}
+	writeDataFile(t, store.Root, "images/launch/hero/1/a.png", "first a")
+	writeDataFile(t, store.Root, "brand-assets/acme/logo.png", "logo")
+	outDir := t.TempDir()
+
+	if _, _, err := store.Backup(outDir, true); err == nil {
+		t.Fatal("incremental backup without a base succeeded")
+	}
+	fullPath, full, err := store.Backup(outDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Kind != "full" || full.Base != "" || filepath.Base(fullPath) != full.Name {
+		t.Fatalf("full manifest = %+v", full)
+	}
+	for path, archive := range archiveOf(full) {
+		if archive != full.Name {
+			t.Errorf("%s stored in %s, want %s", path, archive, full.Name)
+		}
+	}
+
+	writeDataFile(t, store.Root, "images/launch/hero/1/a.png", "second a")
+	_, first, err := store.Backup(outDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeDataFile(t, store.Root, "images/launch/hero/2/c.png", "c")
+	if _, err := store.CreateProject("Launch", ""); err != nil {
+		t.Fatal(err)
+	}
+	lastPath, last, err := store.Backup(outDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Base != full.Name || last.Base != first.Name || last.Kind != "incremental" {
+		t.Fatalf("chain = %s <- %s <- %s", full.Name, first.Base, last.Base)
+	}
+	archives := archiveOf(last)
+	for path, want := range map[string]string{
+		"images/launch/hero/1/a.png": first.Name,
+		"brand-assets/acme/logo.png": full.Name,
+		"images/launch/hero/2/c.png": last.Name,
+		backupDBName:                 last.Name,
+	} {
+		if archives[path] != want {
+			t.Errorf("%s stored in %q, want %q", path, archives[path], want)
+		}
+	}
+
+	if _, err := VerifyBackup(lastPath); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(t.TempDir(), "data")
+	if _, _, err := RestoreBackup(lastPath, root, false); err != nil {
+		t.Fatal(err)
+	}
+	for rel, want := range map[string]string{
+		"images/launch/hero/1/a.png": "second a",
+		"brand-assets/acme/logo.png": "logo",
+		"images/launch/hero/2/c.png": "c",
+	} {
+		got, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(rel)))
+		if err != nil || string(got) != want {
+			t.Errorf("restored %s = %q, %v; want %q", rel, got, err, want)
+		}
+	}
+	restored, err := NewStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if _, err := restored.GetProject("launch"); err != nil {
+		t.Fatalf("restored database: %v", err)
+	}
+
+	if _, _, err := RestoreBackup(fullPath, root, false); err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Fatalf("restore into a non-empty root error = %v", err)
+	}
+	_, previous, err := RestoreBackup(fullPath, root, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(root, "images/launch/hero/1/a.png")); string(got) != "first a" {
+		t.Fatalf("forced restore a.png = %q", got)
+	}
+	if got, _ := os.ReadFile(filepath.Join(previous, "images/launch/hero/2/c.png")); string(got) != "c" {
+		t.Fatalf("previous data root not kept: %q", got)
+	}
+
+	// Without its base the chain cannot be verified.
+	if err := os.Remove(fullPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyBackup(lastPath); err == nil {
+		t.Fatal("verified an incremental backup whose base is gone")
+	}
+}
+
+func TestRestoreRejectsTamperedManifest(t *testing.T) {
+	store := newTestStore(t)
+	writeDataFile(t, store.Root, "images/launch/hero/1/a.png", "a")
+	// A file outside the backup directory whose name matches the archive pattern.
+	outside := t.TempDir()
+	writeDataFile(t, outside, "imagegen-backup-evil.tar.gz", "")
+
+	for _, tc := range []struct {
+		name string
+		edit func(*BackupManifest)
+		want string
+	}{
+		{"path outside root", func(m *BackupManifest) { m.Files[1].Path = "../escape.png" }, "unsafe path"},
+		{"absolute path", func(m *BackupManifest) { m.Files[1].Path = "/tmp/escape.png" }, "unsafe path"},
+		{"archive in parent dir", func(m *BackupManifest) { m.Files[1].Archive = "../" + m.Name }, "unsafe archive name"},
+		{"absolute archive", func(m *BackupManifest) {
+			m.Files[1].Archive = filepath.Join(outside, "imagegen-backup-evil.tar.gz")
+		}, "unsafe archive name"},
+		{"foreign archive name", func(m *BackupManifest) { m.Files[1].Archive = "imagegen.db" }, "unsafe archive name"},
+		{"checksum", func(m *BackupManifest) { m.Files[1].SHA256 = strings.Repeat("0", 64) }, "checksum mismatch"},
+		{"size", func(m *BackupManifest) { m.Files[1].Size++ }, "checksum mismatch"},
+		{"extra file", func(m *BackupManifest) {
+			m.Files = append(m.Files, BackupFile{Path: "images/x.png", Size: 1, SHA256: m.Files[1].SHA256, Archive: m.Name})
+		}, "missing 1 file"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			archivePath, _, err := store.Backup(t.TempDir(), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rewriteManifest(t, archivePath, tc.edit)
+			if _, err := VerifyBackup(archivePath); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("verify error = %v, want %q", err, tc.want)
+			}
+			parent := t.TempDir()
+			root := filepath.Join(parent, "data")
+			if _, _, err := RestoreBackup(archivePath, root, false); err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("restore error = %v, want %q", err, tc.want)
+			}
+			if entries, _ := os.ReadDir(parent); len(entries) != 0 {
+				t.Fatalf("failed restore left %d entries behind", len(entries))
+			}
+			if _, err := os.Stat(filepath.Join(parent, "escape.png")); !os.IsNotExist(err) {
+				t.Fatal("tampered path was written")
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package webapp
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"imagegen/internal/imageconv"
+)
+
+const (
+	BrandAssetLogo      = "logo"
+	BrandAssetReference = "reference"
+)
+
+var BrandAssetKinds = []string{BrandAssetLogo, BrandAssetReference}
+
+// imageInputModels are the models that accept reference images next to the
+// prompt.
+var imageInputModels = []string{"openai", "google"}
+
+// maxBrandAssetBytes bounds a single upload.
+const maxBrandAssetBytes = 20 << 20
+
+// brandAssetFormats are the image formats brand assets are stored in.
+var brandAssetFormats = []string{"png", "jpg", "gif", "webp"}
+
+// convertedAssetFormats are accepted on upload and stored as PNG, since the
+// image providers do not take them as references.
+var convertedAssetFormats = []string{"tiff", "bmp"}
+
+// probeImage reads the format and dimensions of an uploaded asset.
+func probeImage(data []byte) (imageconv.Info, error) {
+	info, err := imageconv.Probe(data)
+	if err != nil || !slices.Contains(brandAssetFormats, info.Format) && !slices.Contains(convertedAssetFormats, info.Format) {
+		return imageconv.Info{}, errors.New("not a PNG, JPEG, GIF, WEBP, TIFF or BMP image")
+	}
+	return info, nil
+}
+
+func brandAssetDir(brandSlug string) string {
+	return filepath.Join("brand-assets", brandSlug)
+}
+
+// AddBrandAsset stores an uploaded image under brand-assets/{brand}/ and
+// records it; TIFF and BMP uploads are converted to PNG first. Uploading the
+// same file to a brand twice is refused.
+func (s *Store) AddBrandAsset(brandSlug string, kind string, filename string, data []byte) (BrandAsset, error) {
+	brandSlug = Slugify(brandSlug)
+	if !slices.Contains(BrandAssetKinds, kind) {
+		return BrandAsset{}, fmt.Errorf("unknown asset kind %q", kind)
+	}
+	if len(data) > maxBrandAssetBytes {
+		return BrandAsset{}, fmt.Errorf("assets are limited to %s", formatBytes(maxBrandAssetBytes))
+	}
+	info, err := probeImage(data)
+	if err != nil {
+		return BrandAsset{}, err
+	}
+	if slices.Contains(convertedAssetFormats, info.Format) {
+		if data, err = imageconv.ToPNG(data); err != nil {
+			return BrandAsset{}, fmt.Errorf("convert %s to PNG: %w", strings.ToUpper(info.Format), err)
+		}
+		if info, err = imageconv.Probe(data); err != nil {
+			return BrandAsset{}, err
+		}
+		filename = strings.TrimSuffix(filename, filepath.Ext(filename)) + ".png"
+	}
+	sum := sha256.Sum256(data)
+	asset := BrandAsset{
+		BrandSlug: brandSlug,
+		Kind:      kind,
+		Filename:  filepath.Base(strings.TrimSpace(filename)),
+		MIMEType:  info.MIMEType,
+		Width:     info.Width,
+		Height:    info.Height,
+		SizeBytes: int64(len(data)),
+		SHA256:    hex.EncodeToString(sum[:]),
+	}
+	if asset.Filename == "." || asset.Filename == string(filepath.Separator) {
+		asset.Filename = "asset"
+	}
+	stem := Slugify(strings.TrimSuffix(asset.Filename, filepath.Ext(asset.Filename)))
+	asset.RelPath = filepath.Join(brandAssetDir(brandSlug), asset.SHA256[:12]+"-"+cmp.Or(stem, "asset")+"."+info.Format)
+	err = s.withTx(func(tx *sql.Tx) error {
+		if err := tx.QueryRow(`SELECT id FROM brands WHERE slug = ?;`, brandSlug).Scan(&asset.BrandID); err != nil {
+			return notFound(err)
+		}
+		var existing string
+		err := tx.QueryRow(`SELECT filename FROM brand_assets WHERE brand_id = ? AND sha256 = ?;`, asset.BrandID, asset.SHA256).Scan(&existing)
+		if err == nil {
+			return fmt.Errorf("this image is already attached as %s", existing)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		now := nowText()
+		asset.CreatedAt = parseTime(now)
+		if err := tx.QueryRow(`
+			INSERT INTO brand_assets (brand_id, kind, filename, rel_path, mime_type, width, height, size_bytes, sha256, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id;
+		`, asset.BrandID, asset.Kind, asset.Filename, asset.RelPath, asset.MIMEType, asset.Width, asset.Height,
+			asset.SizeBytes, asset.SHA256, now).Scan(&asset.ID); err != nil {
+			return err
+		}
+		return writeFileAtomic(filepath.Join(s.Root, asset.RelPath), data)
+	})
+	if err != nil {
+		return BrandAsset{}, err
+	}
+	return asset, nil
+}
+
+const brandAssetSelectSQL = `
+	SELECT a.id, a.brand_id, b.slug, a.kind, a.filename, a.rel_path, a.mime_type, a.width, a.height,
+	       a.size_bytes, a.sha256, a.created_at
+	FROM brand_assets a
+	JOIN brands b ON b.id = a.brand_id`
+
+func scanBrandAsset(sc rowScanner) (BrandAsset, error) {
+	var a BrandAsset
+	var created string
+	err := sc.Scan(&a.ID, &a.BrandID, &a.BrandSlug, &a.Kind, &a.Filename, &a.RelPath, &a.MIMEType,
+		&a.Width, &a.Height, &a.SizeBytes, &a.SHA256, &created)
+	a.CreatedAt = parseTime(created)
+	return a, err
+}
+
+// ListBrandAssets returns a brand's assets, logos first.
+func (s *Store) ListBrandAssets(brandSlug string) ([]BrandAsset, error) {
+	rows, err := s.db.Query(brandAssetSelectSQL+`
+		WHERE b.slug = ?
+		ORDER BY a.kind = 'logo' DESC, a.id ASC;
+	`, Slugify(brandSlug))
+	if err != nil {
+		return nil, err
+	}
+	return collectRows(rows, scanBrandAsset)
+}
+
+// BrandAssetPath returns an asset of the brand and the file it is stored in.
+func (s *Store) BrandAssetPath(brandSlug string, assetID int64) (BrandAsset, string, error) {
+	asset, err := scanBrandAsset(s.db.QueryRow(brandAssetSelectSQL+`
+		WHERE b.slug = ? AND a.id = ?;
+	`, Slugify(brandSlug), assetID))
+	if err != nil {
+		return BrandAsset{}, "", notFound(err)
+	}
+	return asset, filepath.Join(s.Root, asset.RelPath), nil
+}
+
+func (s *Store) DeleteBrandAsset(brandSlug string, assetID int64) error {
+	var relPath string
+	err := s.db.QueryRow(`
+		DELETE FROM brand_assets
+		WHERE id = ? AND brand_id = (SELECT id FROM brands WHERE slug = ?)
+		RETURNING rel_path;
+	`, assetID, Slugify(brandSlug)).Scan(&relPath)
+	if err != nil {
+		return notFound(err)
+	}
+	if err := os.Remove(filepath.Join(s.Root, relPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// checkReferenceAssets verifies that a job's reference images belong to the
+// work item's brand and that every model it runs accepts image inputs.
+func (s *Store) checkReferenceAssets(brandSlug string, payload GenerateJobPayload) error {
+	if len(payload.ReferenceAssetIDs) == 0 {
+		return nil
+	}
+	if brandSlug == "" {
+		return errors.New("reference images need a brand on the work item or project")
+	}
+	models := []string{payload.Model}
+	if payload.Model == "both" {
+		models = ConstraintModels
+	}
+	for _, model := range models {
+		if !slices.Contains(imageInputModels, model) {
+			return fmt.Errorf("model %s does not accept reference images", model)
+		}
+	}
+	for _, id := range payload.ReferenceAssetIDs {
+		if _, _, err := s.BrandAssetPath(brandSlug, id); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("brand %s has no asset #%d", brandSlug, id)
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package webapp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+
+	"imagegen/internal/imageconv"
+)
+
+func TestAddBrandAssetConvertsTIFFAndBMPToPNG(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.CreateBrand("Acme", "Bold."); err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 12, 8))
+	img.Set(3, 4, color.RGBA{200, 10, 10, 255})
+	var tiffData, bmpData bytes.Buffer
+	if err := tiff.Encode(&tiffData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := bmp.Encode(&bmpData, img); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"Logo Scan.tiff": tiffData.Bytes(), "mark.bmp": bmpData.Bytes()} {
+		asset, err := store.AddBrandAsset("acme", BrandAssetLogo, name, data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if asset.MIMEType != "image/png" || asset.Width != 12 || asset.Height != 8 || filepath.Ext(asset.Filename) != ".png" {
+			t.Errorf("%s: asset = %+v", name, asset)
+		}
+		stored, err := os.ReadFile(filepath.Join(store.Root, asset.RelPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if imageconv.DetectFormat(stored) != "png" || filepath.Ext(asset.RelPath) != ".png" || int64(len(stored)) != asset.SizeBytes {
+			t.Errorf("%s: stored %s as %s", name, asset.RelPath, imageconv.DetectFormat(stored))
+		}
+	}
+	if _, err := store.AddBrandAsset("acme", BrandAssetLogo, "notes.txt", []byte("hello")); err == nil {
+		t.Error("accepted a text file")
+	}
+}
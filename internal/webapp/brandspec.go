@@ -0,0 +1,180 @@
+package webapp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BrandSpec is the structured part of a brand, read from YAML frontmatter at
+// the top of its Markdown:
+//
+//	---
+//	description: Friendly kitchen helper
+//	palette:
+//	  - primary: "#E4572E"
+//	  - "#FFFFFF"
+//	fonts:
+//	  - heading: Fraunces
+//	  - Inter
+//	voice: [warm, playful]
+//	style: [flat, rounded]
+//	forbidden: [photorealism, gradients]
+//	aspect_ratios: ["1:1"]
+//	references: [logo.svg]
+//	---
+type BrandSpec struct {
+	Description  string       `yaml:"description"`
+	Palette      []BrandEntry `yaml:"palette"`
+	Fonts        []BrandEntry `yaml:"fonts"`
+	Voice        []string     `yaml:"voice"`
+	Style        []string     `yaml:"style"`
+	Forbidden    []string     `yaml:"forbidden"`
+	AspectRatios []string     `yaml:"aspect_ratios"`
+	References   []string     `yaml:"references"`
+}
+
+// BrandEntry is a palette color or font, optionally named by its role:
+// either a plain value or a single "role: value" pair.
+type BrandEntry struct {
+	Role  string
+	Value string
+}
+
+func (e *BrandEntry) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		e.Value = strings.TrimSpace(node.Value)
+		return nil
+	case yaml.MappingNode:
+		if len(node.Content) == 2 && node.Content[1].Kind == yaml.ScalarNode {
+			e.Role = strings.TrimSpace(node.Content[0].Value)
+			e.Value = strings.TrimSpace(node.Content[1].Value)
+			return nil
+		}
+	}
+	return fmt.Errorf("line %d: expected a value or a single \"role: value\" pair", node.Line)
+}
+
+func (e BrandEntry) String() string {
+	if e.Role == "" {
+		return e.Value
+	}
+	return e.Role + ": " + e.Value
+}
+
+var hexColorPattern = regexp.MustCompile(`^#([0-9A-F]{3}|[0-9A-F]{6})$`)
+
+func (b BrandSpec) IsZero() bool {
+	return b.Description == "" && len(b.Palette) == 0 && len(b.Fonts) == 0 && len(b.Voice) == 0 &&
+		len(b.Style) == 0 && len(b.Forbidden) == 0 && len(b.AspectRatios) == 0 && len(b.References) == 0
+}
+
+// ParseBrand splits brand Markdown into its frontmatter spec and the
+// guideline text after it. Content without frontmatter is all body.
+func ParseBrand(content string) (BrandSpec, string, error) {
+	var spec BrandSpec
+	content = strings.TrimSpace(strings.ReplaceAll(content, "\r\n", "\n"))
+	lines := strings.Split(content, "\n")
+	if strings.TrimSpace(lines[0]) != "---" {
+		return spec, content, nil
+	}
+	end := slices.IndexFunc(lines[1:], func(line string) bool { return strings.TrimSpace(line) == "---" })
+	if end < 0 {
+		return spec, content, errors.New("brand frontmatter has no closing --- line")
+	}
+	front := strings.Join(lines[1:end+1], "\n")
+	body := strings.Join(lines[end+2:], "\n")
+	dec := yaml.NewDecoder(strings.NewReader(front))
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			return BrandSpec{}, content, fmt.Errorf("brand frontmatter: %s",
+				strings.ReplaceAll(strings.Join(typeErr.Errors, "; "), " in type webapp.BrandSpec", ""))
+		}
+		return BrandSpec{}, content, fmt.Errorf("brand frontmatter: %w", err)
+	}
+	spec.Description = strings.TrimSpace(spec.Description)
+	for i := range spec.Palette {
+		spec.Palette[i].Value = strings.ToUpper(spec.Palette[i].Value)
+	}
+	spec.Voice = normalizeElements(spec.Voice)
+	spec.Style = normalizeElements(spec.Style)
+	spec.Forbidden = normalizeElements(spec.Forbidden)
+	spec.AspectRatios = normalizeElements(spec.AspectRatios)
+	spec.References = normalizeElements(spec.References)
+	if err := spec.Validate(); err != nil {
+		return BrandSpec{}, content, err
+	}
+	return spec, strings.TrimSpace(body), nil
+}
+
+func (b BrandSpec) Validate() error {
+	for _, c := range b.Palette {
+		if !hexColorPattern.MatchString(c.Value) {
+			return fmt.Errorf("palette color %q is not a #RGB or #RRGGBB hex value", c.Value)
+		}
+	}
+	for _, f := range b.Fonts {
+		if f.Value == "" || strings.ContainsAny(f.Value, `'";{}<>`) {
+			return fmt.Errorf("font name %q must be non-empty without quotes or markup", f.Value)
+		}
+	}
+	for _, ratio := range b.AspectRatios {
+		if !slices.Contains(ConstraintAspectRatios, ratio) {
+			return fmt.Errorf("unknown aspect ratio %q", ratio)
+		}
+	}
+	return nil
+}
+
+// Guidelines renders the brand for the generator: the structured fields as
+// Markdown sections followed by the free-form body.
+func (b BrandSpec) Guidelines(body string) string {
+	var buf bytes.Buffer
+	section := func(title string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		fmt.Fprintf(&buf, "## %s\n\n", title)
+		for _, v := range values {
+			fmt.Fprintf(&buf, "- %s\n", v)
+		}
+		buf.WriteString("\n")
+	}
+	entries := func(list []BrandEntry) []string {
+		out := make([]string, len(list))
+		for i, e := range list {
+			out[i] = e.String()
+		}
+		return out
+	}
+	if b.Description != "" {
+		buf.WriteString(b.Description + "\n\n")
+	}
+	section("Color palette", entries(b.Palette))
+	section("Typography", entries(b.Fonts))
+	section("Voice", b.Voice)
+	section("Visual style", b.Style)
+	section("Never include", b.Forbidden)
+	buf.WriteString(body)
+	return strings.TrimSpace(buf.String())
+}
+
+// WithBrand adds a brand's rules to the constraints: its forbidden elements
+// are avoided and its first default aspect ratio applies when the work item
+// does not set one.
+func (c Constraints) WithBrand(b BrandSpec) Constraints {
+	if c.AspectRatio == "" && len(b.AspectRatios) > 0 {
+		c.AspectRatio = b.AspectRatios[0]
+	}
+	c.MustAvoid = normalizeElements(append(slices.Clone(c.MustAvoid), b.Forbidden...))
+	return c
+}
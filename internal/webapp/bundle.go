@@ -0,0 +1,881 @@
+package webapp
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Project bundles are gzip-compressed tarballs whose first entry is
+// bundle.json. The manifest carries the project, its work items with prompt
+// history, finished jobs and runs, and every brand they refer to with its
+// versions. Image files follow under images/ and final copies under final/.
+// Database IDs never leave the store: references inside a bundle use slugs
+// and version numbers, and import assigns fresh IDs.
+const (
+	bundleFormatVersion = 1
+	bundleManifestName  = "bundle.json"
+)
+
+type ProjectBundle struct {
+	FormatVersion int              `json:"format_version"`
+	ExportedAt    string           `json:"exported_at"`
+	Project       BundleProject    `json:"project"`
+	Brands        []BundleBrand    `json:"brands"`
+	WorkItems     []BundleWorkItem `json:"work_items"`
+}
+
+type BundleProject struct {
+	Name         string `json:"name"`
+	Slug         string `json:"slug"`
+	DefaultBrand string `json:"default_brand,omitempty"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+type BundleBrand struct {
+	Name      string               `json:"name"`
+	Slug      string               `json:"slug"`
+	Content   string               `json:"content"`
+	CreatedAt string               `json:"created_at"`
+	UpdatedAt string               `json:"updated_at"`
+	Versions  []BundleBrandVersion `json:"versions"`
+}
+
+type BundleBrandVersion struct {
+	Version   int    `json:"version"`
+	Content   string `json:"content"`
+	Author    string `json:"author,omitempty"`
+	Note      string `json:"note,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+type BundleWorkItem struct {
+	Name        string                 `json:"name"`
+	Slug        string                 `json:"slug"`
+	Type        string                 `json:"type"`
+	Status      string                 `json:"status,omitempty"`
+	Prompt      string                 `json:"prompt"`
+	Constraints Constraints            `json:"constraints,omitzero"`
+	Brand       string                 `json:"brand,omitempty"`
+	CreatedAt   string                 `json:"created_at"`
+	UpdatedAt   string                 `json:"updated_at"`
+	ArchivedAt  string                 `json:"archived_at,omitempty"`
+	Tags        []string               `json:"tags,omitempty"`
+	Revisions   []BundlePromptRevision `json:"revisions"`
+	Jobs        []BundleJob            `json:"jobs"`
+	Comparisons []BundleComparison     `json:"comparisons,omitempty"`
+	Final       *BundleFinal           `json:"final,omitempty"`
+	StatusLog   []BundleStatusChange   `json:"status_log,omitempty"`
+}
+
+type BundleStatusChange struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Note      string `json:"note,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// BundleFinal is a work item's final deliverable. Path is the final copy in
+// the bundle and Image the path of the candidate it came from, empty when
+// that candidate is gone.
+type BundleFinal struct {
+	Filename    string `json:"filename"`
+	Path        string `json:"path"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	Image       string `json:"image,omitempty"`
+	FinalizedBy string `json:"finalized_by,omitempty"`
+	FinalizedAt string `json:"finalized_at"`
+	imageID     int64
+}
+
+// BundleComparison is a pairwise pick; Winner and Loser are image paths in
+// the bundle.
+type BundleComparison struct {
+	Winner    string `json:"winner"`
+	Loser     string `json:"loser"`
+	CreatedAt string `json:"created_at"`
+	winnerID  int64
+	loserID   int64
+}
+
+type BundlePromptRevision struct {
+	Revision  int    `json:"revision"`
+	Prompt    string `json:"prompt"`
+	Note      string `json:"note,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+type BundleJob struct {
+	Status     string          `json:"status"`
+	Payload    json.RawMessage `json:"payload"`
+	Error      string          `json:"error,omitempty"`
+	CreatedAt  string          `json:"created_at"`
+	StartedAt  string          `json:"started_at,omitempty"`
+	FinishedAt string          `json:"finished_at,omitempty"`
+	Run        *BundleRun      `json:"run,omitempty"`
+}
+
+type BundleRun struct {
+	PromptSnapshot string          `json:"prompt_snapshot"`
+	Settings       json.RawMessage `json:"settings"`
+	Status         string          `json:"status"`
+	Error          string          `json:"error,omitempty"`
+	CreatedAt      string          `json:"created_at"`
+	FinishedAt     string          `json:"finished_at,omitempty"`
+	Brand          string          `json:"brand,omitempty"`
+	BrandVersion   int             `json:"brand_version,omitempty"`
+	PromptRevision int             `json:"prompt_revision,omitempty"`
+	Images         []BundleImage   `json:"images"`
+}
+
+type BundleImage struct {
+	Filename  string   `json:"filename"`
+	Format    string   `json:"format"`
+	Model     string   `json:"model,omitempty"`
+	Path      string   `json:"path"`
+	Size      int64    `json:"size"`
+	SHA256    string   `json:"sha256"`
+	Width     int      `json:"width,omitempty"`
+	Height    int      `json:"height,omitempty"`
+	MIMEType  string   `json:"mime_type,omitempty"`
+	CreatedAt string   `json:"created_at"`
+	Tags      []string `json:"tags,omitempty"`
+	Rating    int      `json:"rating,omitempty"`
+	Note      string   `json:"note,omitempty"`
+	RatedAt   string   `json:"rated_at,omitempty"`
+	Elo       float64  `json:"elo,omitempty"`
+	id        int64
+}
+
+// ExportProject writes a bundle of the project to w. Queued and running jobs
+// are left out, as are images whose file is already gone from disk.
+func (s *Store) ExportProject(slug string, w io.Writer) (ProjectBundle, error) {
+	bundle := ProjectBundle{FormatVersion: bundleFormatVersion, ExportedAt: nowText()}
+	var projectID int64
+	err := s.db.QueryRow(`
+		SELECT p.id, p.name, p.slug, COALESCE(b.slug, ''), p.created_at, p.updated_at
+		FROM projects p
+		LEFT JOIN brands b ON b.id = p.default_brand_id
+		WHERE p.slug = ?;
+	`, Slugify(slug)).Scan(&projectID, &bundle.Project.Name, &bundle.Project.Slug, &bundle.Project.DefaultBrand,
+		&bundle.Project.CreatedAt, &bundle.Project.UpdatedAt)
+	if err != nil {
+		return bundle, notFound(err)
+	}
+
+	brandSlugs := []string{}
+	addBrand := func(slug string) {
+		for _, b := range brandSlugs {
+			if b == slug {
+				return
+			}
+		}
+		if slug != "" {
+			brandSlugs = append(brandSlugs, slug)
+		}
+	}
+	addBrand(bundle.Project.DefaultBrand)
+
+	// sources maps bundle paths to image files on disk.
+	sources := map[string]string{}
+	items, err := s.exportWorkItems(projectID)
+	if err != nil {
+		return bundle, err
+	}
+	for i := range items {
+		item := &items[i]
+		addBrand(item.Brand)
+		// paths maps exported image IDs to their bundle paths.
+		paths := map[int64]string{}
+		for j := range item.Jobs {
+			run := item.Jobs[j].Run
+			if run == nil {
+				continue
+			}
+			addBrand(run.Brand)
+			kept := run.Images[:0]
+			for _, img := range run.Images {
+				abs := filepath.Join(s.Root, img.Path)
+				sum, size, err := hashFile(abs)
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				if err != nil {
+					return bundle, err
+				}
+				img.Path = path.Join("images", item.Slug, fmt.Sprintf("job-%d", j+1), img.Filename)
+				img.Size, img.SHA256 = size, sum
+				sources[img.Path] = abs
+				paths[img.id] = img.Path
+				kept = append(kept, img)
+			}
+			run.Images = kept
+		}
+		comparisons := item.Comparisons[:0]
+		for _, c := range item.Comparisons {
+			c.Winner, c.Loser = paths[c.winnerID], paths[c.loserID]
+			if c.Winner != "" && c.Loser != "" {
+				comparisons = append(comparisons, c)
+			}
+		}
+		item.Comparisons = comparisons
+		if final := item.Final; final != nil {
+			abs := filepath.Join(s.Root, final.Path)
+			sum, size, err := hashFile(abs)
+			if errors.Is(err, os.ErrNotExist) {
+				item.Final = nil
+				continue
+			}
+			if err != nil {
+				return bundle, err
+			}
+			final.Path = path.Join("final", item.Slug, filepath.Base(final.Path))
+			final.Size, final.SHA256, final.Image = size, sum, paths[final.imageID]
+			sources[final.Path] = abs
+		}
+	}
+	bundle.WorkItems = items
+
+	for _, slug := range brandSlugs {
+		brand, err := s.exportBrand(slug)
+		if err != nil {
+			return bundle, err
+		}
+		bundle.Brands = append(bundle.Brands, brand)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	raw, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return bundle, err
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: bundleManifestName, Mode: 0o644, Size: int64(len(raw)), ModTime: time.Now()}); err != nil {
+		return bundle, err
+	}
+	if _, err := tw.Write(raw); err != nil {
+		return bundle, err
+	}
+	for _, item := range bundle.WorkItems {
+		for _, job := range item.Jobs {
+			if job.Run == nil {
+				continue
+			}
+			for _, img := range job.Run.Images {
+				entry := BackupFile{Path: img.Path, Size: img.Size, SHA256: img.SHA256}
+				if err := copyIntoTar(tw, entry, sources[img.Path]); err != nil {
+					return bundle, err
+				}
+			}
+		}
+		if final := item.Final; final != nil {
+			entry := BackupFile{Path: final.Path, Size: final.Size, SHA256: final.SHA256}
+			if err := copyIntoTar(tw, entry, sources[final.Path]); err != nil {
+				return bundle, err
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return bundle, err
+	}
+	return bundle, gz.Close()
+}
+
+func (s *Store) exportWorkItems(projectID int64) ([]BundleWorkItem, error) {
+	rows, err := s.db.Query(`
+		SELECT w.id, w.name, w.slug, w.type, w.status, w.prompt, w.constraints_json, COALESCE(b.slug, ''),
+		       w.created_at, w.updated_at, COALESCE(w.archived_at, ''),`+workItemTagsSQL+`
+		FROM work_items w
+		LEFT JOIN brands b ON b.id = w.brand_id
+		WHERE w.project_id = ?
+		ORDER BY w.id ASC;
+	`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	ids := []int64{}
+	items, err := collectRows(rows, func(sc rowScanner) (BundleWorkItem, error) {
+		var id int64
+		var item BundleWorkItem
+		var tags, constraints string
+		err := sc.Scan(&id, &item.Name, &item.Slug, &item.Type, &item.Status, &item.Prompt, &constraints, &item.Brand,
+			&item.CreatedAt, &item.UpdatedAt, &item.ArchivedAt, &tags)
+		if tags != "" {
+			item.Tags = splitTags(tags)
+		}
+		if err == nil {
+			err = json.Unmarshal([]byte(constraints), &item.Constraints)
+		}
+		ids = append(ids, id)
+		return item, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		rows, err := s.db.Query(`
+			SELECT revision, prompt, note, created_at
+			FROM prompt_revisions
+			WHERE work_item_id = ?
+			ORDER BY revision ASC;
+		`, id)
+		if err != nil {
+			return nil, err
+		}
+		items[i].Revisions, err = collectRows(rows, func(sc rowScanner) (BundlePromptRevision, error) {
+			var rev BundlePromptRevision
+			err := sc.Scan(&rev.Revision, &rev.Prompt, &rev.Note, &rev.CreatedAt)
+			return rev, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if items[i].Jobs, err = s.exportJobs(id); err != nil {
+			return nil, err
+		}
+		rows, err = s.db.Query(`
+			SELECT winner_image_id, loser_image_id, created_at
+			FROM comparisons
+			WHERE work_item_id = ?
+			ORDER BY id ASC;
+		`, id)
+		if err != nil {
+			return nil, err
+		}
+		items[i].Comparisons, err = collectRows(rows, func(sc rowScanner) (BundleComparison, error) {
+			var c BundleComparison
+			err := sc.Scan(&c.winnerID, &c.loserID, &c.CreatedAt)
+			return c, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		var final BundleFinal
+		err = s.db.QueryRow(`
+			SELECT COALESCE(image_id, 0), filename, rel_path, finalized_by, finalized_at
+			FROM work_item_finals
+			WHERE work_item_id = ?;
+		`, id).Scan(&final.imageID, &final.Filename, &final.Path, &final.FinalizedBy, &final.FinalizedAt)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if err == nil {
+			items[i].Final = &final
+		}
+		rows, err = s.db.Query(`
+			SELECT from_status, to_status, note, created_at
+			FROM work_item_status_changes
+			WHERE work_item_id = ?
+			ORDER BY id ASC;
+		`, id)
+		if err != nil {
+			return nil, err
+		}
+		items[i].StatusLog, err = collectRows(rows, func(sc rowScanner) (BundleStatusChange, error) {
+			var c BundleStatusChange
+			err := sc.Scan(&c.From, &c.To, &c.Note, &c.CreatedAt)
+			return c, err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+func (s *Store) exportJobs(workItemID int64) ([]BundleJob, error) {
+	rows, err := s.db.Query(`
+		SELECT j.status, j.payload_json, COALESCE(j.error_message, ''), j.created_at,
+		       COALESCE(j.started_at, ''), COALESCE(j.finished_at, ''),
+		       r.id, COALESCE(r.prompt_snapshot, ''), COALESCE(r.settings_json, ''), COALESCE(r.status, ''),
+		       COALESCE(r.error_message, ''), COALESCE(r.created_at, ''), COALESCE(r.finished_at, ''),
+		       COALESCE(b.slug, ''), COALESCE(bv.version, 0), COALESCE(pr.revision, 0)
+		FROM jobs j
+		LEFT JOIN runs r ON r.job_id = j.id
+		LEFT JOIN brand_versions bv ON bv.id = r.brand_version_id
+		LEFT JOIN brands b ON b.id = bv.brand_id
+		LEFT JOIN prompt_revisions pr ON pr.id = r.prompt_revision_id
+		WHERE j.work_item_id = ? AND j.status IN ('succeeded', 'failed')
+		ORDER BY j.id ASC;
+	`, workItemID)
+	if err != nil {
+		return nil, err
+	}
+	runIDs := []int64{}
+	jobs, err := collectRows(rows, func(sc rowScanner) (BundleJob, error) {
+		var job BundleJob
+		var run BundleRun
+		var runID sql.NullInt64
+		var payload, settings string
+		err := sc.Scan(&job.Status, &payload, &job.Error, &job.CreatedAt, &job.StartedAt, &job.FinishedAt,
+			&runID, &run.PromptSnapshot, &settings, &run.Status, &run.Error, &run.CreatedAt, &run.FinishedAt,
+			&run.Brand, &run.BrandVersion, &run.PromptRevision)
+		job.Payload = json.RawMessage(payload)
+		if runID.Valid {
+			run.Settings = json.RawMessage(settings)
+			job.Run = &run
+		}
+		runIDs = append(runIDs, runID.Int64)
+		return job, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, runID := range runIDs {
+		if jobs[i].Run == nil {
+			continue
+		}
+		rows, err := s.db.Query(`
+			SELECT ri.id, ri.filename, ri.format, ri.model, ri.rel_path, ri.created_at, ri.width, ri.height, ri.mime_type,
+			       COALESCE(ri.rating, 0), ri.rating_note, COALESCE(ri.rated_at, ''), ri.elo,`+imageTagsSQL+`
+			FROM run_images ri
+			WHERE ri.run_id = ?
+			ORDER BY ri.id ASC;
+		`, runID)
+		if err != nil {
+			return nil, err
+		}
+		jobs[i].Run.Images, err = collectRows(rows, func(sc rowScanner) (BundleImage, error) {
+			var img BundleImage
+			var tags string
+			err := sc.Scan(&img.id, &img.Filename, &img.Format, &img.Model, &img.Path, &img.CreatedAt, &img.Width, &img.Height, &img.MIMEType,
+				&img.Rating, &img.Note, &img.RatedAt, &img.Elo, &tags)
+			if tags != "" {
+				img.Tags = splitTags(tags)
+			}
+			return img, err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return jobs, nil
+}
+
+func (s *Store) exportBrand(slug string) (BundleBrand, error) {
+	var brand BundleBrand
+	var id int64
+	err := s.db.QueryRow(`
+		SELECT id, name, slug, content, created_at, updated_at FROM brands WHERE slug = ?;
+	`, slug).Scan(&id, &brand.Name, &brand.Slug, &brand.Content, &brand.CreatedAt, &brand.UpdatedAt)
+	if err != nil {
+		return brand, notFound(err)
+	}
+	rows, err := s.db.Query(`
+		SELECT version, content, author, note, created_at
+		FROM brand_versions
+		WHERE brand_id = ?
+		ORDER BY version ASC;
+	`, id)
+	if err != nil {
+		return brand, err
+	}
+	brand.Versions, err = collectRows(rows, func(sc rowScanner) (BundleBrandVersion, error) {
+		var v BundleBrandVersion
+		err := sc.Scan(&v.Version, &v.Content, &v.Author, &v.Note, &v.CreatedAt)
+		return v, err
+	})
+	return brand, err
+}
+
+// bundleFilename is the default file name for an exported project.
+func bundleFilename(slug string) string {
+	return slug + ".imagegen-project.tar.gz"
+}
+
+func (b ProjectBundle) counts() (jobs int, images int) {
+	for _, item := range b.WorkItems {
+		jobs += len(item.Jobs)
+		for _, job := range item.Jobs {
+			if job.Run != nil {
+				images += len(job.Run.Images)
+			}
+		}
+	}
+	return jobs, images
+}
+
+// ImportResult reports where an imported bundle ended up. Renamed maps the
+// slugs from the bundle that were taken to the slugs used instead.
+type ImportResult struct {
+	Project      Project
+	Bundle       ProjectBundle
+	ReusedBrands []string
+	Renamed      map[string]string
+}
+
+// ImportProject recreates a bundle as a new project. The project keeps its
+// slug unless as is set; a slug that is taken gets a numeric suffix. A brand
+// whose slug exists with the same current content is reused, otherwise it is
+// created with its full history under a free slug. Image files are verified
+// against their checksums before anything is written to the database.
+func (s *Store) ImportProject(bundlePath string, as string) (ImportResult, error) {
+	result := ImportResult{Renamed: map[string]string{}}
+	bundle, err := readProjectBundle(bundlePath)
+	if err != nil {
+		return result, err
+	}
+	result.Bundle = bundle
+
+	staging, err := os.MkdirTemp(s.Root, ".import-")
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(staging)
+	wanted := map[string]BackupFile{}
+	for _, item := range bundle.WorkItems {
+		for _, job := range item.Jobs {
+			if job.Run == nil {
+				continue
+			}
+			for _, img := range job.Run.Images {
+				if !safeBundlePath(img.Path) {
+					return result, fmt.Errorf("unsafe path %q in bundle", img.Path)
+				}
+				wanted[img.Path] = BackupFile{Path: img.Path, Size: img.Size, SHA256: img.SHA256}
+			}
+		}
+		if final := item.Final; final != nil {
+			if !safeBundlePath(final.Path) {
+				return result, fmt.Errorf("unsafe path %q in bundle", final.Path)
+			}
+			wanted[final.Path] = BackupFile{Path: final.Path, Size: final.Size, SHA256: final.SHA256}
+		}
+	}
+	err = readBackupEntries(bundlePath, func(hdr *tar.Header, r io.Reader) error {
+		entry, ok := wanted[hdr.Name]
+		if !ok {
+			return nil
+		}
+		delete(wanted, hdr.Name)
+		return restoreEntry(entry, r, staging)
+	})
+	if err != nil {
+		return result, err
+	}
+	if len(wanted) > 0 {
+		return result, fmt.Errorf("bundle is missing %d image file(s)", len(wanted))
+	}
+
+	projectSlug := Slugify(bundle.Project.Slug)
+	if as != "" {
+		projectSlug = Slugify(as)
+	}
+	if projectSlug == "" {
+		return result, errors.New("bundle has no project slug")
+	}
+
+	// moves maps staged files to their final place once the rows exist.
+	moves := map[string]string{}
+	err = s.withTx(func(tx *sql.Tx) error {
+		brandIDs := map[string]int64{}
+		versionIDs := map[string]int64{}
+		for _, brand := range bundle.Brands {
+			id, versions, slug, reused, err := importBrand(tx, brand)
+			if err != nil {
+				return err
+			}
+			brandIDs[brand.Slug] = id
+			for v, vid := range versions {
+				versionIDs[fmt.Sprintf("%s@%d", brand.Slug, v)] = vid
+			}
+			if reused {
+				result.ReusedBrands = append(result.ReusedBrands, slug)
+			} else if slug != brand.Slug {
+				result.Renamed["brand "+brand.Slug] = slug
+			}
+		}
+
+		slug, err := freeSlug(tx, `SELECT COUNT(*) FROM projects WHERE slug = ?;`, projectSlug)
+		if err != nil {
+			return err
+		}
+		if slug != bundle.Project.Slug {
+			result.Renamed["project "+bundle.Project.Slug] = slug
+		}
+		projectSlug = slug
+		var projectID int64
+		if err := tx.QueryRow(`
+			INSERT INTO projects (name, slug, default_brand_id, created_at, updated_at)
+			VALUES (?, ?, NULLIF(?, 0), ?, ?)
+			RETURNING id;
+		`, bundle.Project.Name, projectSlug, brandIDs[bundle.Project.DefaultBrand],
+			bundle.Project.CreatedAt, bundle.Project.UpdatedAt).Scan(&projectID); err != nil {
+			return err
+		}
+
+		for _, item := range bundle.WorkItems {
+			status := item.Status
+			if !slices.Contains(WorkItemStatuses, status) {
+				status = StatusDraft
+			}
+			constraints := item.Constraints.Normalize()
+			if err := constraints.Validate(); err != nil {
+				return fmt.Errorf("work item %s: %w", item.Slug, err)
+			}
+			constraintsJSON, err := json.Marshal(constraints)
+			if err != nil {
+				return err
+			}
+			var itemID int64
+			if err := tx.QueryRow(`
+				INSERT INTO work_items (project_id, name, slug, type, status, prompt, constraints_json, brand_id, created_at, updated_at, archived_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, NULLIF(?, 0), ?, ?, NULLIF(?, ''))
+				RETURNING id;
+			`, projectID, item.Name, Slugify(item.Slug), item.Type, status, item.Prompt, string(constraintsJSON), brandIDs[item.Brand],
+				item.CreatedAt, item.UpdatedAt, item.ArchivedAt).Scan(&itemID); err != nil {
+				return err
+			}
+			for _, c := range item.StatusLog {
+				if _, err := tx.Exec(`
+					INSERT INTO work_item_status_changes (work_item_id, from_status, to_status, note, created_at)
+					VALUES (?, ?, ?, ?, ?);
+				`, itemID, c.From, c.To, c.Note, c.CreatedAt); err != nil {
+					return err
+				}
+			}
+			for _, tag := range item.Tags {
+				if err := addTag(tx, "work_item_tags", "work_item_id", itemID, tag); err != nil {
+					return err
+				}
+			}
+			revisionIDs := map[int]int64{}
+			imageIDs := map[string]int64{}
+			for _, rev := range item.Revisions {
+				var id int64
+				if err := tx.QueryRow(`
+					INSERT INTO prompt_revisions (work_item_id, revision, prompt, note, created_at)
+					VALUES (?, ?, ?, ?, ?)
+					RETURNING id;
+				`, itemID, rev.Revision, rev.Prompt, rev.Note, rev.CreatedAt).Scan(&id); err != nil {
+					return err
+				}
+				revisionIDs[rev.Revision] = id
+			}
+			for _, job := range item.Jobs {
+				var jobID int64
+				if err := tx.QueryRow(`
+					INSERT INTO jobs (work_item_id, status, payload_json, error_message, created_at, started_at, finished_at)
+					VALUES (?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), NULLIF(?, ''))
+					RETURNING id;
+				`, itemID, job.Status, string(job.Payload), job.Error, job.CreatedAt, job.StartedAt, job.FinishedAt).Scan(&jobID); err != nil {
+					return err
+				}
+				run := job.Run
+				if run == nil {
+					continue
+				}
+				var payload GenerateJobPayload
+				_ = json.Unmarshal(job.Payload, &payload)
+				var runID int64
+				if err := tx.QueryRow(`
+					INSERT INTO runs (job_id, work_item_id, prompt_snapshot, settings_json, status, error_message,
+					                  created_at, finished_at, brand_version_id, prompt_revision_id)
+					VALUES (?, ?, ?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), NULLIF(?, 0), NULLIF(?, 0))
+					RETURNING id;
+				`, jobID, itemID, run.PromptSnapshot, string(run.Settings), run.Status, run.Error, run.CreatedAt, run.FinishedAt,
+					versionIDs[fmt.Sprintf("%s@%d", run.Brand, run.BrandVersion)], revisionIDs[run.PromptRevision]).Scan(&runID); err != nil {
+					return err
+				}
+				if _, err := tx.Exec(`UPDATE jobs SET run_id = ? WHERE id = ?;`, runID, jobID); err != nil {
+					return err
+				}
+				dir := s.WorkItemImagesDir(projectSlug, item.Slug, runID)
+				for _, img := range run.Images {
+					target := filepath.Join(dir, filepath.Base(img.Filename))
+					rel, err := s.RelPath(target)
+					if err != nil {
+						return err
+					}
+					staged := filepath.Join(staging, filepath.FromSlash(img.Path))
+					meta, err := readImageMeta(staged)
+					if err != nil {
+						return err
+					}
+					// Bundles from before the model was recorded leave it out.
+					model := img.Model
+					if model == "" {
+						model = imageModel(payload.Model, img.Filename)
+					}
+					var imageID int64
+					if err := tx.QueryRow(`
+						INSERT INTO run_images (run_id, filename, rel_path, format, model, created_at, rating, rating_note, rated_at, elo,
+						                        width, height, size_bytes, sha256, mime_type)
+						VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, 0), ?, NULLIF(?, ''), COALESCE(NULLIF(?, 0), 1500), ?, ?, ?, ?, ?)
+						RETURNING id;
+					`, runID, img.Filename, rel, img.Format, model, img.CreatedAt, img.Rating, img.Note, img.RatedAt, img.Elo,
+						meta.Width, meta.Height, meta.SizeBytes, meta.SHA256, meta.MIMEType).Scan(&imageID); err != nil {
+						return err
+					}
+					imageIDs[img.Path] = imageID
+					for _, tag := range img.Tags {
+						if err := addTag(tx, "image_tags", "image_id", imageID, tag); err != nil {
+							return err
+						}
+					}
+					moves[staged] = target
+				}
+			}
+			for _, c := range item.Comparisons {
+				winnerID, loserID := imageIDs[c.Winner], imageIDs[c.Loser]
+				if winnerID == 0 || loserID == 0 {
+					continue
+				}
+				if _, err := tx.Exec(`
+					INSERT INTO comparisons (work_item_id, winner_image_id, loser_image_id, created_at)
+					VALUES (?, ?, ?, ?);
+				`, itemID, winnerID, loserID, c.CreatedAt); err != nil {
+					return err
+				}
+			}
+			if final := item.Final; final != nil {
+				rel := finalRelPath(projectSlug, Slugify(item.Slug), filepath.Ext(final.Path))
+				if _, err := tx.Exec(`
+					INSERT INTO work_item_finals (work_item_id, image_id, filename, rel_path, finalized_by, finalized_at)
+					VALUES (?, NULLIF(?, 0), ?, ?, ?, ?);
+				`, itemID, imageIDs[final.Image], final.Filename, rel, final.FinalizedBy, final.FinalizedAt); err != nil {
+					return err
+				}
+				moves[filepath.Join(staging, filepath.FromSlash(final.Path))] = filepath.Join(s.Root, rel)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	for from, to := range moves {
+		if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+			return result, err
+		}
+		if err := os.Rename(from, to); err != nil {
+			return result, err
+		}
+	}
+	result.Project, err = s.GetProject(projectSlug)
+	return result, err
+}
+
+// importBrand reuses a brand with the same slug and current content or
+// inserts the bundled brand with its versions under a free slug. It returns
+// the brand ID and the IDs of the bundled version numbers it could map.
+func importBrand(tx *sql.Tx, brand BundleBrand) (int64, map[int]int64, string, bool, error) {
+	versions := map[int]int64{}
+	var id int64
+	var content string
+	err := tx.QueryRow(`SELECT id, content FROM brands WHERE slug = ?;`, brand.Slug).Scan(&id, &content)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, nil, "", false, err
+	}
+	if err == nil && content == brand.Content {
+		// Map bundled versions onto existing ones with identical content.
+		rows, err := tx.Query(`SELECT id, content FROM brand_versions WHERE brand_id = ? ORDER BY version ASC;`, id)
+		if err != nil {
+			return 0, nil, "", false, err
+		}
+		byContent := map[string]int64{}
+		for rows.Next() {
+			var vid int64
+			var c string
+			if err := rows.Scan(&vid, &c); err != nil {
+				rows.Close()
+				return 0, nil, "", false, err
+			}
+			byContent[c] = vid
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, nil, "", false, err
+		}
+		for _, v := range brand.Versions {
+			if vid, ok := byContent[v.Content]; ok {
+				versions[v.Version] = vid
+			}
+		}
+		return id, versions, brand.Slug, true, nil
+	}
+
+	slug, err := freeSlug(tx, `SELECT COUNT(*) FROM brands WHERE slug = ?;`, Slugify(brand.Slug))
+	if err != nil {
+		return 0, nil, "", false, err
+	}
+	if err := tx.QueryRow(`
+		INSERT INTO brands (name, slug, content, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id;
+	`, brand.Name, slug, brand.Content, brand.CreatedAt, brand.UpdatedAt).Scan(&id); err != nil {
+		return 0, nil, "", false, err
+	}
+	for _, v := range brand.Versions {
+		var vid int64
+		if err := tx.QueryRow(`
+			INSERT INTO brand_versions (brand_id, version, content, author, note, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			RETURNING id;
+		`, id, v.Version, v.Content, v.Author, v.Note, v.CreatedAt).Scan(&vid); err != nil {
+			return 0, nil, "", false, err
+		}
+		versions[v.Version] = vid
+	}
+	return id, versions, slug, false, nil
+}
+
+// freeSlug returns slug, or slug-2, slug-3 and so on, whichever the count
+// query reports as unused first.
+func freeSlug(tx *sql.Tx, countQuery string, slug string) (string, error) {
+	candidate := slug
+	for n := 2; ; n++ {
+		var count int
+		if err := tx.QueryRow(countQuery, candidate).Scan(&count); err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s-%d", slug, n)
+	}
+}
+
+func readProjectBundle(bundlePath string) (ProjectBundle, error) {
+	var bundle ProjectBundle
+	found := false
+	errStop := errors.New("stop")
+	err := readBackupEntries(bundlePath, func(hdr *tar.Header, r io.Reader) error {
+		if hdr.Name != bundleManifestName {
+			return fmt.Errorf("first entry is %q, expected %s", hdr.Name, bundleManifestName)
+		}
+		if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+			return fmt.Errorf("parse bundle manifest: %w", err)
+		}
+		found = true
+		return errStop
+	})
+	if err != nil && !errors.Is(err, errStop) {
+		return ProjectBundle{}, fmt.Errorf("%s: %w", filepath.Base(bundlePath), err)
+	}
+	if !found {
+		return ProjectBundle{}, fmt.Errorf("%s: not a project bundle", filepath.Base(bundlePath))
+	}
+	if bundle.FormatVersion != bundleFormatVersion {
+		return ProjectBundle{}, fmt.Errorf("%s: unsupported bundle format %d", filepath.Base(bundlePath), bundle.FormatVersion)
+	}
+	return bundle, nil
+}
+
+func safeBundlePath(p string) bool {
+	return filepath.IsLocal(filepath.FromSlash(p))
+}
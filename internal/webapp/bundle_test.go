@@ -0,0 +1,219 @@
+package webapp
+
+import (
+	"bytes"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// addFinishedJob runs a generate job for the work item to completion,
+// writing one image file with the given content, and returns the image ID.
+func addFinishedJob(t *testing.T, store *Store, projectSlug string, itemSlug string, content string) int64 {
+	t.Helper()
+	payload := GenerateJobPayload{Model: "openai", OutputFormat: "png", ImageSize: "1K"}
+	if _, err := store.CreateGenerateJob(projectSlug, itemSlug, payload); err != nil {
+		t.Fatal(err)
+	}
+	job, err := store.ClaimNextQueuedJob()
+	if err != nil || job == nil {
+		t.Fatalf("claim job: %v", err)
+	}
+	runID, err := store.CreateRun(job, job.Prompt, "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := store.WorkItemImagesDir(projectSlug, itemSlug, runID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	abs := filepath.Join(dir, "openai-1.png")
+	if err := os.WriteFile(abs, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rel, err := store.RelPath(abs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := readImageMeta(abs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddRunImage(runID, "openai-1.png", rel, "png", "openai", meta); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MarkRunSucceeded(runID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MarkJobSucceeded(job.JobID); err != nil {
+		t.Fatal(err)
+	}
+	var imageID int64
+	if err := store.db.QueryRow(`SELECT id FROM run_images WHERE run_id = ?;`, runID).Scan(&imageID); err != nil {
+		t.Fatal(err)
+	}
+	return imageID
+}
+
+// exportBundle writes a bundle of the project to a temporary file.
+func exportBundle(t *testing.T, store *Store, projectSlug string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := store.ExportProject(projectSlug, &buf); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), bundleFilename(projectSlug))
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// projectFiles returns the content of every image and final file recorded
+// for the project, keyed by the path below the project's directory.
+func projectFiles(t *testing.T, store *Store, projectSlug string) map[string]string {
+	t.Helper()
+	rows, err := store.db.Query(`
+		SELECT ri.rel_path FROM run_images ri
+		JOIN runs r ON r.id = ri.run_id
+		JOIN work_items w ON w.id = r.work_item_id
+		JOIN projects p ON p.id = w.project_id
+		WHERE p.slug = ?
+		UNION ALL
+		SELECT f.rel_path FROM work_item_finals f
+		JOIN work_items w ON w.id = f.work_item_id
+		JOIN projects p ON p.id = w.project_id
+		WHERE p.slug = ?;
+	`, projectSlug, projectSlug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rels, err := collectRows(rows, func(sc rowScanner) (string, error) {
+		var rel string
+		err := sc.Scan(&rel)
+		return rel, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	prefix := filepath.Join("images", projectSlug)
+	for _, rel := range rels {
+		data, err := os.ReadFile(filepath.Join(store.Root, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sub, err := filepath.Rel(prefix, rel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Run IDs differ between stores; keep the work item and file name.
+		files[filepath.Join(filepath.Dir(filepath.Dir(sub)), filepath.Base(sub))] = string(data)
+	}
+	return files
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.CreateBrand("Acme", "Bold."); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.UpdateBrand("acme", "Bolder.", "ana"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateProject("Launch", "acme"); err != nil {
+		t.Fatal(err)
+	}
+	item, err := store.CreateWorkItem("launch", "Hero", "", "a red kite", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.UpdateWorkItemPrompt("launch", "hero", "a blue kite", "bluer"); err != nil {
+		t.Fatal(err)
+	}
+	imageID := addFinishedJob(t, store, "launch", "hero", "kite pixels")
+	if _, err := store.FinalizeImage(item.ID, imageID, "ana"); err != nil {
+		t.Fatal(err)
+	}
+	bundlePath := exportBundle(t, store, "launch")
+	want := projectFiles(t, store, "launch")
+	if len(want) != 2 {
+		t.Fatalf("source files = %v, want an image and a final", want)
+	}
+
+	// Importing next to the original renames the project and reuses the
+	// unchanged brand.
+	result, err := store.ImportProject(bundlePath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Project.Slug != "launch-2" || result.Renamed["project launch"] != "launch-2" {
+		t.Fatalf("project = %q, renamed %v", result.Project.Slug, result.Renamed)
+	}
+	if !slices.Equal(result.ReusedBrands, []string{"acme"}) || result.Project.DefaultBrandSlug != "acme" {
+		t.Fatalf("reused brands = %v, default brand %q", result.ReusedBrands, result.Project.DefaultBrandSlug)
+	}
+	if versions, _ := store.ListBrandVersions("acme"); len(versions) != 2 {
+		t.Fatalf("reused brand has %d versions, want 2", len(versions))
+	}
+	imported, err := store.GetWorkItem("launch-2", "hero")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Prompt != "a blue kite" || imported.PromptRevision != 2 || imported.Final == nil {
+		t.Fatalf("imported work item = %+v", imported)
+	}
+	if got := projectFiles(t, store, "launch-2"); !maps.Equal(got, want) {
+		t.Fatalf("imported files = %v, want %v", got, want)
+	}
+
+	// Once the brand has changed it is imported under a new slug with its
+	// bundled history, and the explicit project slug is still made unique.
+	if _, err := store.UpdateBrand("acme", "Quiet.", "ana"); err != nil {
+		t.Fatal(err)
+	}
+	result, err = store.ImportProject(bundlePath, "Launch 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Project.Slug != "launch-2-2" || result.Renamed["brand acme"] != "acme-2" || len(result.ReusedBrands) != 0 {
+		t.Fatalf("project = %q, renamed %v, reused %v", result.Project.Slug, result.Renamed, result.ReusedBrands)
+	}
+	brand, err := store.GetBrand("acme-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brand.Content != "Bolder." || brand.Version != 2 || result.Project.DefaultBrandSlug != "acme-2" {
+		t.Fatalf("imported brand = %+v, default brand %q", brand, result.Project.DefaultBrandSlug)
+	}
+
+	// A store without any of it takes the slugs from the bundle.
+	other := newTestStore(t)
+	result, err = other.ImportProject(bundlePath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Project.Slug != "launch" || len(result.Renamed) != 0 || len(result.ReusedBrands) != 0 {
+		t.Fatalf("fresh import = %q, renamed %v, reused %v", result.Project.Slug, result.Renamed, result.ReusedBrands)
+	}
+	if got := projectFiles(t, other, "launch"); !maps.Equal(got, want) {
+		t.Fatalf("imported files = %v, want %v", got, want)
+	}
+}
+
+func TestSafeBundlePath(t *testing.T) {
+	for p, want := range map[string]bool{
+		"images/hero/job-1/a.png": true,
+		"final/hero/a..b.png":     true,
+		"":                        false,
+		"../a.png":                false,
+		"images/../../a.png":      false,
+		"/etc/passwd":             false,
+	} {
+		if got := safeBundlePath(p); got != want {
+			t.Errorf("safeBundlePath(%q) = %v, want %v", p, got, want)
+		}
+	}
+}
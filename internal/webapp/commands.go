@@ -0,0 +1,362 @@
+package webapp
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// commands maps subcommand names to their implementations. All of them work
+// on imagegen.db, so they need the sqlite storage backend.
+var commands = map[string]func(dataRoot string, args []string, out io.Writer) error{
+	"migrate":         runMigrateCommand,
+	"gc":              runGCCommand,
+	"fsck":            runFsckCommand,
+	"backup":          runBackupCommand,
+	"restore":         runRestoreCommand,
+	"export":          runExportCommand,
+	"import":          runImportCommand,
+	"backfill-images": runBackfillImagesCommand,
+}
+
+// RunCommand executes an imagegen-web maintenance subcommand against the
+// data root. args[0] is the subcommand name.
+func RunCommand(dataRoot string, storage string, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("missing command")
+	}
+	run, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	switch storage {
+	case "", StorageSQLite:
+		return run(dataRoot, args[1:], out)
+	case StorageFiles:
+		return fmt.Errorf("%s requires -storage sqlite", args[0])
+	default:
+		return fmt.Errorf("unknown storage %q (use %s or %s)", storage, StorageSQLite, StorageFiles)
+	}
+}
+
+func runMigrateCommand(dataRoot string, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate status|up|down")
+	}
+	store, err := OpenStore(dataRoot)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	switch args[0] {
+	case "status":
+		states, err := store.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, st := range states {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = "applied " + st.AppliedAt.Local().Format(time.DateTime)
+			}
+			fmt.Fprintf(out, "%04d  %-32s  %s\n", st.Version, st.Name, applied)
+		}
+		return nil
+	case "up":
+		fs := flag.NewFlagSet("migrate up", flag.ContinueOnError)
+		fs.SetOutput(out)
+		target := fs.Int("to", 0, "apply migrations up to this version (0 = all)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		done, err := store.MigrateUp(*target)
+		printVersions(out, "applied", done)
+		return err
+	case "down":
+		fs := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		fs.SetOutput(out)
+		steps := fs.Int("steps", 1, "number of migrations to roll back")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		done, err := store.MigrateDown(*steps)
+		printVersions(out, "rolled back", done)
+		return err
+	default:
+		return fmt.Errorf("unknown migrate action %q (use status, up or down)", args[0])
+	}
+}
+
+func runGCCommand(dataRoot string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	fs.SetOutput(out)
+	apply := fs.Bool("delete", false, "delete what was found instead of only reporting it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	store, err := NewStore(dataRoot)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := store.CollectGarbage(*apply)
+	for _, f := range report.OrphanFiles {
+		fmt.Fprintf(out, "orphan file   %s (%s)\n", f.RelPath, formatBytes(f.Bytes))
+	}
+	for _, m := range report.MissingFiles {
+		fmt.Fprintf(out, "missing file  image %d: %s\n", m.ImageID, m.RelPath)
+	}
+	for _, dir := range report.EmptyDirs {
+		fmt.Fprintf(out, "empty dir     %s\n", dir)
+	}
+	verb := "would reclaim"
+	if *apply {
+		verb = "reclaimed"
+	}
+	fmt.Fprintf(out, "%d orphan files, %d missing files, %d empty dirs; %s %s\n",
+		len(report.OrphanFiles), len(report.MissingFiles), len(report.EmptyDirs), verb, formatBytes(report.ReclaimableBytes))
+	if len(report.MissingFiles) > 0 {
+		fmt.Fprintln(out, "records of missing files are kept; use fsck -repair -delete-missing if the files are gone for good")
+	}
+	return err
+}
+
+func runFsckCommand(dataRoot string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	fs.SetOutput(out)
+	repair := fs.Bool("repair", false, "apply the safe repairs instead of only reporting")
+	deleteMissing := fs.Bool("delete-missing", false, "with -repair, also delete records of missing run images and brand assets (drops their ratings, tags and finals)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *deleteMissing && !*repair {
+		return errors.New("-delete-missing needs -repair")
+	}
+	store, err := NewStore(dataRoot)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := store.Fsck(FsckOptions{Repair: *repair, DeleteMissing: *deleteMissing})
+	for _, line := range report.Integrity {
+		fmt.Fprintf(out, "integrity     %s\n", line)
+	}
+	for _, fk := range report.ForeignKeys {
+		fmt.Fprintf(out, "foreign key   %s row %d references missing %s\n", fk.Table, fk.RowID, fk.Parent)
+	}
+	for _, job := range report.StuckJobs {
+		fmt.Fprintf(out, "stuck job     #%d running since %s\n", job.JobID, job.StartedAt.Local().Format(time.DateTime))
+	}
+	for _, job := range report.DanglingRuns {
+		fmt.Fprintf(out, "dangling run  job #%d points at missing run #%d\n", job.JobID, job.RunID)
+	}
+	for _, run := range report.EmptyRuns {
+		fmt.Fprintf(out, "empty run     #%d (job #%d), %d files on disk in %s\n", run.RunID, run.JobID, len(run.Recoverable), run.Dir)
+	}
+	for _, f := range report.Files {
+		fmt.Fprintf(out, "%-13s %s #%d: %s", f.Problem, f.Table, f.ID, f.RelPath)
+		if f.Detail != "" {
+			fmt.Fprintf(out, " (%s)", f.Detail)
+		}
+		fmt.Fprintln(out)
+	}
+	for _, repair := range report.Repairs {
+		fmt.Fprintf(out, "repaired      %s\n", repair)
+	}
+	switch {
+	case err != nil:
+		return err
+	case report.Clean():
+		fmt.Fprintln(out, "no problems found")
+	case !*repair && report.Repairable():
+		fmt.Fprintln(out, "run with -repair to apply the safe repairs")
+	}
+	if n := report.MissingRecords(); n > 0 && !*deleteMissing {
+		fmt.Fprintf(out, "%d records point at missing files; if the files are gone for good, run with -repair -delete-missing\n", n)
+	}
+	return nil
+}
+
+func runBackupCommand(dataRoot string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.SetOutput(out)
+	outDir := fs.String("out", "", "directory for the archive (default <data-dir>/backups)")
+	incremental := fs.Bool("incremental", false, "only store files changed since the newest archive in the output directory")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	store, err := NewStore(dataRoot)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	path, manifest, err := store.Backup(*outDir, *incremental)
+	if err != nil {
+		return err
+	}
+	stored := 0
+	for _, f := range manifest.Files {
+		if f.Archive == filepath.Base(path) {
+			stored++
+		}
+	}
+	fmt.Fprintf(out, "wrote %s (%s, schema v%d, %d of %d files stored)\n", path, manifest.Kind, manifest.SchemaVersion, stored, len(manifest.Files))
+	return nil
+}
+
+func runRestoreCommand(dataRoot string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.SetOutput(out)
+	force := fs.Bool("force", false, "replace a non-empty data directory (its contents are moved aside)")
+	verifyOnly := fs.Bool("verify", false, "only verify the archive checksums")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: restore [-force] [-verify] ARCHIVE")
+	}
+	archive := fs.Arg(0)
+
+	if *verifyOnly {
+		manifest, err := VerifyBackup(archive)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s: ok (%s, schema v%d, %d files)\n", archive, manifest.Kind, manifest.SchemaVersion, len(manifest.Files))
+		return nil
+	}
+	manifest, previous, err := RestoreBackup(archive, dataRoot, *force)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "restored %d files from %s into %s\n", len(manifest.Files), archive, dataRoot)
+	if previous != "" {
+		fmt.Fprintf(out, "previous data moved to %s\n", previous)
+	}
+	return nil
+}
+
+func runExportCommand(dataRoot string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(out)
+	outPath := fs.String("out", "", "bundle file to write (default <project>.imagegen-project.tar.gz)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: export [-out FILE] PROJECT")
+	}
+	store, err := NewStore(dataRoot)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	slug := Slugify(fs.Arg(0))
+	if _, err := store.GetProject(slug); err != nil {
+		return fmt.Errorf("project %q not found", slug)
+	}
+	path := *outPath
+	if path == "" {
+		path = bundleFilename(slug)
+	}
+	f, err := os.Create(path + ".partial")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	bundle, err := store.ExportProject(slug, f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	jobs, images := bundle.counts()
+	fmt.Fprintf(out, "wrote %s (%d work items, %d jobs, %d images, %d brands)\n", path, len(bundle.WorkItems), jobs, images, len(bundle.Brands))
+	return nil
+}
+
+func runImportCommand(dataRoot string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(out)
+	as := fs.String("as", "", "slug for the imported project (default the slug in the bundle)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: import [-as SLUG] BUNDLE")
+	}
+	store, err := NewStore(dataRoot)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	result, err := store.ImportProject(fs.Arg(0), *as)
+	if err != nil {
+		return err
+	}
+	for _, from := range sortedKeys(result.Renamed) {
+		fmt.Fprintf(out, "renamed %s -> %s\n", from, result.Renamed[from])
+	}
+	for _, slug := range result.ReusedBrands {
+		fmt.Fprintf(out, "reused brand %s\n", slug)
+	}
+	jobs, images := result.Bundle.counts()
+	fmt.Fprintf(out, "imported project %s (%d work items, %d jobs, %d images)\n", result.Project.Slug, len(result.Bundle.WorkItems), jobs, images)
+	return nil
+}
+
+func runBackfillImagesCommand(dataRoot string, args []string, out io.Writer) error {
+	if len(args) > 0 {
+		return errors.New("usage: backfill-images")
+	}
+	store, err := NewStore(dataRoot)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	report, err := store.BackfillImageMeta()
+	for _, m := range report.MissingFiles {
+		fmt.Fprintf(out, "missing file  image %d: %s\n", m.ImageID, m.RelPath)
+	}
+	fmt.Fprintf(out, "updated %d images, %d missing files\n", report.Updated, len(report.MissingFiles))
+	return err
+}
+
+func printVersions(out io.Writer, verb string, versions []int) {
+	if len(versions) == 0 {
+		fmt.Fprintf(out, "nothing %s\n", verb)
+		return
+	}
+	parts := make([]string, 0, len(versions))
+	for _, v := range versions {
+		parts = append(parts, fmt.Sprintf("%04d", v))
+	}
+	fmt.Fprintf(out, "%s: %s\n", verb, strings.Join(parts, ", "))
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,139 @@
+package webapp
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"os"
+)
+
+// eloK is the most a single comparison moves a score; maxCompare caps how
+// many of the best ranked images NextComparison considers.
+const (
+	eloK       = 32
+	maxCompare = 150
+)
+
+// Leaderboard ranks a work item's images by Elo, best first.
+func (s *Store) Leaderboard(workItemID int64) ([]RankedImage, error) {
+	rows, err := s.db.Query(`
+		SELECT `+imageColumnsSQL+`, ri.elo,
+		       (SELECT COUNT(*) FROM comparisons c WHERE c.winner_image_id = ri.id),
+		       (SELECT COUNT(*) FROM comparisons c WHERE c.loser_image_id = ri.id)
+		FROM run_images ri
+		JOIN runs r ON r.id = ri.run_id
+		WHERE r.work_item_id = ?
+		ORDER BY ri.elo DESC, ri.id DESC;
+	`, workItemID)
+	if err != nil {
+		return nil, err
+	}
+	return collectRows(rows, func(sc rowScanner) (RankedImage, error) {
+		var ranked RankedImage
+		img, err := scanImageWith(sc, &ranked.Elo, &ranked.Wins, &ranked.Losses)
+		ranked.WorkItemImage = img
+		return ranked, err
+	})
+}
+
+// NextComparison picks the most informative pair of a work item's images:
+// pairs seen least often first, then images with few comparisons, then
+// images whose scores are close. skip names a pair to pass over. ok is false
+// when there are fewer than two images.
+func (s *Store) NextComparison(workItemID int64, skip ...int64) (pair ComparisonPair, ok bool, err error) {
+	images, err := s.Leaderboard(workItemID)
+	if err != nil || len(images) < 2 {
+		return pair, false, err
+	}
+	if len(images) > maxCompare {
+		images = images[:maxCompare]
+	}
+	rows, err := s.db.Query(`
+		SELECT min(winner_image_id, loser_image_id), max(winner_image_id, loser_image_id), COUNT(*)
+		FROM comparisons
+		WHERE work_item_id = ?
+		GROUP BY 1, 2;
+	`, workItemID)
+	if err != nil {
+		return pair, false, err
+	}
+	seen := map[[2]int64]int{}
+	if _, err := collectRows(rows, func(sc rowScanner) (struct{}, error) {
+		var a, b int64
+		var n int
+		err := sc.Scan(&a, &b, &n)
+		seen[[2]int64{a, b}] = n
+		return struct{}{}, err
+	}); err != nil {
+		return pair, false, err
+	}
+	skipKey := [2]int64{}
+	if len(skip) == 2 {
+		skipKey = [2]int64{min(skip[0], skip[1]), max(skip[0], skip[1])}
+	}
+	best := math.Inf(1)
+	for i := range images {
+		for j := i + 1; j < len(images); j++ {
+			a, b := images[i], images[j]
+			key := [2]int64{min(a.ID, b.ID), max(a.ID, b.ID)}
+			if key == skipKey && len(images) > 2 {
+				continue
+			}
+			played := a.Wins + a.Losses + b.Wins + b.Losses
+			cost := float64(seen[key])*100 + float64(played)*5 + math.Abs(a.Elo-b.Elo)/20
+			if cost < best {
+				best = cost
+				pair = ComparisonPair{Left: a, Right: b}
+			}
+		}
+	}
+	return pair, true, nil
+}
+
+// RecordComparison stores a pick between two images of a work item and
+// moves both Elo scores.
+func (s *Store) RecordComparison(workItemID int64, winnerID int64, loserID int64) (Comparison, error) {
+	if winnerID == loserID {
+		return Comparison{}, errors.New("pick two different images")
+	}
+	c := Comparison{WorkItemID: workItemID, WinnerID: winnerID, LoserID: loserID}
+	err := s.withTx(func(tx *sql.Tx) error {
+		elo := map[int64]float64{}
+		rows, err := tx.Query(`
+			SELECT ri.id, ri.elo
+			FROM run_images ri
+			JOIN runs r ON r.id = ri.run_id
+			WHERE r.work_item_id = ? AND ri.id IN (?, ?);
+		`, workItemID, winnerID, loserID)
+		if err != nil {
+			return err
+		}
+		if _, err := collectRows(rows, func(sc rowScanner) (struct{}, error) {
+			var id int64
+			var score float64
+			err := sc.Scan(&id, &score)
+			elo[id] = score
+			return struct{}{}, err
+		}); err != nil {
+			return err
+		}
+		if len(elo) != 2 {
+			return os.ErrNotExist
+		}
+		expected := 1 / (1 + math.Pow(10, (elo[loserID]-elo[winnerID])/400))
+		delta := eloK * (1 - expected)
+		for id, change := range map[int64]float64{winnerID: delta, loserID: -delta} {
+			if _, err := tx.Exec(`UPDATE run_images SET elo = elo + ? WHERE id = ?;`, change, id); err != nil {
+				return err
+			}
+		}
+		now := nowText()
+		c.CreatedAt = parseTime(now)
+		return tx.QueryRow(`
+			INSERT INTO comparisons (work_item_id, winner_image_id, loser_image_id, created_at)
+			VALUES (?, ?, ?, ?)
+			RETURNING id;
+		`, workItemID, winnerID, loserID, now).Scan(&c.ID)
+	})
+	return c, err
+}
@@ -0,0 +1,136 @@
+package webapp
+
+import (
+	"errors"
+	"math"
+	"os"
+	"testing"
+)
+
+// eloOf returns the Elo score of each of the work item's images.
+func eloOf(t *testing.T, store *Store, workItemID int64) map[int64]float64 {
+	t.Helper()
+	board, err := store.Leaderboard(workItemID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scores := map[int64]float64{}
+	for _, img := range board {
+		scores[img.ID] = img.Elo
+	}
+	return scores
+}
+
+func TestRecordComparisonMovesElo(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.CreateProject("Launch", ""); err != nil {
+		t.Fatal(err)
+	}
+	item, err := store.CreateWorkItem("launch", "Hero", "", "a kite", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := addFinishedJob(t, store, "launch", "hero", "a")
+	b := addFinishedJob(t, store, "launch", "hero", "b")
+
+	// Equal scores expect a draw, so the winner takes half of eloK.
+	if _, err := store.RecordComparison(item.ID, a, b); err != nil {
+		t.Fatal(err)
+	}
+	scores := eloOf(t, store, item.ID)
+	if scores[a] != 1500+eloK/2 || scores[b] != 1500-eloK/2 {
+		t.Fatalf("scores after an even pick = %v", scores)
+	}
+
+	// An upset moves the scores by more than half of eloK; the favourite
+	// expected to win with 1/(1+10^(-32/400)).
+	if _, err := store.RecordComparison(item.ID, b, a); err != nil {
+		t.Fatal(err)
+	}
+	want := eloK * (1 - 1/(1+math.Pow(10, 32.0/400)))
+	scores = eloOf(t, store, item.ID)
+	if math.Abs(scores[b]-(1484+want)) > 1e-9 || math.Abs(scores[a]-(1516-want)) > 1e-9 {
+		t.Fatalf("scores after an upset = %v, want a change of %.3f", scores, want)
+	}
+	if want < eloK/2 || math.Abs(scores[a]+scores[b]-3000) > 1e-9 {
+		t.Fatalf("upset delta %.3f, total %v", want, scores[a]+scores[b])
+	}
+
+	board, err := store.Leaderboard(item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range board {
+		if img.Wins != 1 || img.Losses != 1 {
+			t.Errorf("image %d: %d wins, %d losses", img.ID, img.Wins, img.Losses)
+		}
+	}
+
+	if _, err := store.RecordComparison(item.ID, a, a); err == nil {
+		t.Fatal("compared an image with itself")
+	}
+	other, err := store.CreateWorkItem("launch", "Banner", "", "a flag", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.RecordComparison(other.ID, a, b); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("comparison under another work item error = %v", err)
+	}
+	if after := eloOf(t, store, item.ID); after[a] != scores[a] || after[b] != scores[b] {
+		t.Fatalf("rejected comparisons changed scores: %v", after)
+	}
+}
+
+func TestNextComparisonSkipsPair(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.CreateProject("Launch", ""); err != nil {
+		t.Fatal(err)
+	}
+	item, err := store.CreateWorkItem("launch", "Hero", "", "a kite", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := store.NextComparison(item.ID); ok || err != nil {
+		t.Fatalf("pair without images: ok %v, %v", ok, err)
+	}
+	a := addFinishedJob(t, store, "launch", "hero", "a")
+	b := addFinishedJob(t, store, "launch", "hero", "b")
+
+	samePair := func(pair ComparisonPair, x, y int64) bool {
+		l, r := pair.Left.ID, pair.Right.ID
+		return (l == x && r == y) || (l == y && r == x)
+	}
+	// With only two images the skipped pair is the only one left.
+	pair, ok, err := store.NextComparison(item.ID, a, b)
+	if err != nil || !ok || !samePair(pair, a, b) {
+		t.Fatalf("two images, skipping them = %d/%d, ok %v, %v", pair.Left.ID, pair.Right.ID, ok, err)
+	}
+
+	c := addFinishedJob(t, store, "launch", "hero", "c")
+	first, _, err := store.NextComparison(item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, r := first.Left.ID, first.Right.ID
+	for _, skip := range [][]int64{{l, r}, {r, l}} {
+		next, ok, err := store.NextComparison(item.ID, skip...)
+		if err != nil || !ok {
+			t.Fatalf("skip %v: ok %v, %v", skip, ok, err)
+		}
+		if samePair(next, l, r) {
+			t.Fatalf("skip %v returned the skipped pair", skip)
+		}
+	}
+
+	// A pair already compared is offered after the ones never seen.
+	if _, err := store.RecordComparison(item.ID, a, b); err != nil {
+		t.Fatal(err)
+	}
+	next, _, err := store.NextComparison(item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if samePair(next, a, b) || (next.Left.ID != c && next.Right.ID != c) {
+		t.Fatalf("after comparing %d and %d, next pair = %d/%d", a, b, next.Left.ID, next.Right.ID)
+	}
+}
@@ -0,0 +1,207 @@
+package webapp
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// The values a generate job can ask for. Constraints narrow them per work
+// item.
+var (
+	ConstraintModels       = []string{"openai", "google"}
+	ConstraintFormats      = []string{"png", "jpg", "webp", "ico"}
+	ConstraintSizes        = []string{"1K", "2K", "4K"}
+	ConstraintAspectRatios = []string{"1:1", "2:3", "3:2", "3:4", "4:3", "4:5", "5:4", "9:16", "16:9", "21:9"}
+)
+
+// transparentFormats are the output formats that keep an alpha channel.
+var transparentFormats = []string{"png", "webp", "ico"}
+
+// Constraints are the generation rules of a work item. An empty list allows
+// every value; AspectRatio, when set, is required of every job.
+type Constraints struct {
+	AspectRatio string   `json:"aspect_ratio,omitempty"`
+	Models      []string `json:"models,omitempty"`
+	Formats     []string `json:"formats,omitempty"`
+	Sizes       []string `json:"sizes,omitempty"`
+	MustInclude []string `json:"must_include,omitempty"`
+	MustAvoid   []string `json:"must_avoid,omitempty"`
+	Transparent bool     `json:"transparent,omitempty"`
+}
+
+func (c Constraints) AllowsModel(model string) bool {
+	return len(c.Models) == 0 || slices.Contains(c.Models, model)
+}
+
+func (c Constraints) AllowsFormat(format string) bool {
+	if c.Transparent && !slices.Contains(transparentFormats, format) {
+		return false
+	}
+	return len(c.Formats) == 0 || slices.Contains(c.Formats, format)
+}
+
+func (c Constraints) AllowsSize(size string) bool {
+	return len(c.Sizes) == 0 || slices.Contains(c.Sizes, size)
+}
+
+func (c Constraints) IsZero() bool {
+	return c.AspectRatio == "" && len(c.Models) == 0 && len(c.Formats) == 0 && len(c.Sizes) == 0 &&
+		len(c.MustInclude) == 0 && len(c.MustAvoid) == 0 && !c.Transparent
+}
+
+// Normalize trims and dedupes the lists, drops blank entries, and clears a
+// list that names every known value, since that is the same as no limit.
+func (c Constraints) Normalize() Constraints {
+	c.AspectRatio = strings.TrimSpace(c.AspectRatio)
+	c.Models = normalizeChoices(c.Models, ConstraintModels, strings.ToLower)
+	c.Formats = normalizeChoices(c.Formats, ConstraintFormats, strings.ToLower)
+	c.Sizes = normalizeChoices(c.Sizes, ConstraintSizes, strings.ToUpper)
+	c.MustInclude = normalizeElements(c.MustInclude)
+	c.MustAvoid = normalizeElements(c.MustAvoid)
+	return c
+}
+
+// Validate reports constraints that name unknown values or leave no format
+// a job could use.
+func (c Constraints) Validate() error {
+	if c.AspectRatio != "" && !slices.Contains(ConstraintAspectRatios, c.AspectRatio) {
+		return fmt.Errorf("unknown aspect ratio %q", c.AspectRatio)
+	}
+	for _, check := range []struct {
+		kind   string
+		values []string
+		known  []string
+	}{
+		{"model", c.Models, ConstraintModels},
+		{"output format", c.Formats, ConstraintFormats},
+		{"image size", c.Sizes, ConstraintSizes},
+	} {
+		for _, v := range check.values {
+			if !slices.Contains(check.known, v) {
+				return fmt.Errorf("unknown %s %q", check.kind, v)
+			}
+		}
+	}
+	if !slices.ContainsFunc(ConstraintFormats, c.AllowsFormat) {
+		return errors.New("a transparent background needs png, webp or ico among the output formats")
+	}
+	for _, include := range c.MustInclude {
+		for _, avoid := range c.MustAvoid {
+			if strings.EqualFold(include, avoid) {
+				return fmt.Errorf("%q is both required and to be avoided", include)
+			}
+		}
+	}
+	return nil
+}
+
+// apply fills the blank fields of a job payload from the constraints and
+// rejects a payload that breaks them.
+func (c Constraints) apply(payload *GenerateJobPayload) error {
+	if payload.Model == "" {
+		payload.Model = "both"
+		if len(c.Models) == 1 {
+			payload.Model = c.Models[0]
+		}
+	}
+	if payload.OutputFormat == "" {
+		payload.OutputFormat = "png"
+		if i := slices.IndexFunc(ConstraintFormats, c.AllowsFormat); i >= 0 {
+			payload.OutputFormat = ConstraintFormats[i]
+		}
+	}
+	if payload.ImageSize == "" {
+		payload.ImageSize = "1K"
+		if i := slices.IndexFunc(ConstraintSizes, c.AllowsSize); i >= 0 {
+			payload.ImageSize = ConstraintSizes[i]
+		}
+	}
+	if payload.AspectRatio == "" {
+		payload.AspectRatio = c.AspectRatio
+	}
+
+	models := []string{payload.Model}
+	if payload.Model == "both" {
+		models = ConstraintModels
+	}
+	for _, model := range models {
+		if !c.AllowsModel(model) {
+			return fmt.Errorf("this work item only allows %s", strings.Join(c.Models, ", "))
+		}
+	}
+	if !c.AllowsFormat(payload.OutputFormat) {
+		if c.Transparent && !slices.Contains(transparentFormats, payload.OutputFormat) {
+			return fmt.Errorf("this work item needs a transparent background, which %s cannot hold", payload.OutputFormat)
+		}
+		return fmt.Errorf("this work item only allows %s output", strings.Join(c.Formats, ", "))
+	}
+	if !c.AllowsSize(payload.ImageSize) {
+		return fmt.Errorf("this work item only allows %s images", strings.Join(c.Sizes, ", "))
+	}
+	if c.AspectRatio != "" && payload.AspectRatio != c.AspectRatio {
+		return fmt.Errorf("this work item requires a %s aspect ratio", c.AspectRatio)
+	}
+	return nil
+}
+
+// AssemblePrompt builds the prompt a run sends: the work item prompt, then
+// its constraints, then the job's adjustment. Every run renders constraints
+// the same way so snapshots stay comparable.
+func AssemblePrompt(prompt string, c Constraints, adjustment string) string {
+	parts := []string{strings.TrimSpace(prompt)}
+	lines := []string{}
+	if c.AspectRatio != "" {
+		lines = append(lines, "- Aspect ratio: "+c.AspectRatio)
+	}
+	if c.Transparent {
+		lines = append(lines, "- Transparent background, no backdrop or border.")
+	}
+	if len(c.MustInclude) > 0 {
+		lines = append(lines, "- Must include: "+strings.Join(c.MustInclude, "; "))
+	}
+	if len(c.MustAvoid) > 0 {
+		lines = append(lines, "- Must avoid: "+strings.Join(c.MustAvoid, "; "))
+	}
+	if len(lines) > 0 {
+		parts = append(parts, "Constraints:\n"+strings.Join(lines, "\n"))
+	}
+	if adjustment = strings.TrimSpace(adjustment); adjustment != "" {
+		parts = append(parts, "Adjustments:\n"+adjustment)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func normalizeChoices(values []string, known []string, fold func(string) string) []string {
+	out := []string{}
+	for _, v := range values {
+		v = fold(strings.TrimSpace(v))
+		if v != "" && !slices.Contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	for _, k := range known {
+		if !slices.Contains(out, k) {
+			return out
+		}
+	}
+	return nil
+}
+
+func normalizeElements(values []string) []string {
+	out := []string{}
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v != "" && !slices.ContainsFunc(out, func(o string) bool { return strings.EqualFold(o, v) }) {
+			out = append(out, v)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
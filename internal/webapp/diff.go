@@ -0,0 +1,83 @@
+package webapp
+
+import "strings"
+
+// DiffRow is one row of a side-by-side line diff. Kind is "same", "del",
+// "add" or "change"; line numbers are 0 on the side that has no line.
+type DiffRow struct {
+	Kind    string
+	LeftNo  int
+	Left    string
+	RightNo int
+	Right   string
+}
+
+// DiffLines compares two texts line by line using a longest common
+// subsequence and pairs adjacent deletions and insertions into "change" rows.
+func DiffLines(before string, after string) []DiffRow {
+	a := splitLines(before)
+	b := splitLines(after)
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	rows := []DiffRow{}
+	var dels, adds []DiffRow
+	flush := func() {
+		n := max(len(dels), len(adds))
+		for k := 0; k < n; k++ {
+			row := DiffRow{Kind: "change"}
+			if k < len(dels) {
+				row.LeftNo, row.Left = dels[k].LeftNo, dels[k].Left
+			} else {
+				row.Kind = "add"
+			}
+			if k < len(adds) {
+				row.RightNo, row.Right = adds[k].RightNo, adds[k].Right
+			} else {
+				row.Kind = "del"
+			}
+			rows = append(rows, row)
+		}
+		dels, adds = dels[:0], adds[:0]
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			flush()
+			rows = append(rows, DiffRow{Kind: "same", LeftNo: i + 1, Left: a[i], RightNo: j + 1, Right: b[j]})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			adds = append(adds, DiffRow{RightNo: j + 1, Right: b[j]})
+			j++
+		default:
+			dels = append(dels, DiffRow{LeftNo: i + 1, Left: a[i]})
+			i++
+		}
+	}
+	flush()
+	return rows
+}
+
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,1074 @@
+package webapp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FileStore keeps brands, projects and work items as plain files that can be
+// committed to git, following the layout in docs/workflows:
+//
+//	brands/<slug>.md                                  brand content
+//	brands/<slug>.json                                brand metadata
+//	projects/<slug>/project.json
+//	projects/<slug>/work-items/<slug>/work-item.json
+//	projects/<slug>/work-items/<slug>/images/run-<id>/
+//	jobs/<id>.json
+//	runs/<id>.json                                    run + image metadata
+//	state/sequences.json                              id counters
+//
+// Every write goes through a temp file and rename so readers never observe a
+// partially written document.
+type FileStore struct {
+	Root string
+	mu   sync.Mutex
+}
+
+type fileBrandMeta struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Slug      string `json:"slug"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type fileProject struct {
+	ID               int64  `json:"id"`
+	Name             string `json:"name"`
+	Slug             string `json:"slug"`
+	DefaultBrandSlug string `json:"default_brand_slug,omitempty"`
+	CreatedAt        string `json:"created_at"`
+	UpdatedAt        string `json:"updated_at"`
+}
+
+type fileWorkItem struct {
+	ID            int64       `json:"id"`
+	Name          string      `json:"name"`
+	Slug          string      `json:"slug"`
+	Type          string      `json:"type"`
+	Prompt        string      `json:"prompt"`
+	BrandOverride string      `json:"brand_override,omitempty"`
+	Constraints   Constraints `json:"constraints,omitzero"`
+	CreatedAt     string      `json:"created_at"`
+	UpdatedAt     string      `json:"updated_at"`
+}
+
+type fileJob struct {
+	ID           int64           `json:"id"`
+	WorkItemID   int64           `json:"work_item_id"`
+	ProjectSlug  string          `json:"project_slug"`
+	WorkItemSlug string          `json:"work_item_slug"`
+	Status       string          `json:"status"`
+	Payload      json.RawMessage `json:"payload"`
+	ErrorMessage string          `json:"error_message,omitempty"`
+	CreatedAt    string          `json:"created_at"`
+	StartedAt    string          `json:"started_at,omitempty"`
+	FinishedAt   string          `json:"finished_at,omitempty"`
+	RunID        int64           `json:"run_id,omitempty"`
+}
+
+type fileRun struct {
+	ID             int64          `json:"id"`
+	JobID          int64          `json:"job_id"`
+	WorkItemID     int64          `json:"work_item_id"`
+	ProjectSlug    string         `json:"project_slug"`
+	WorkItemSlug   string         `json:"work_item_slug"`
+	PromptSnapshot string         `json:"prompt_snapshot"`
+	Settings       string         `json:"settings_json"`
+	Status         string         `json:"status"`
+	ErrorMessage   string         `json:"error_message,omitempty"`
+	CreatedAt      string         `json:"created_at"`
+	FinishedAt     string         `json:"finished_at,omitempty"`
+	Images         []fileRunImage `json:"images"`
+}
+
+type fileRunImage struct {
+	ID        int64  `json:"id"`
+	Filename  string `json:"filename"`
+	RelPath   string `json:"rel_path"`
+	Format    string `json:"format"`
+	Model     string `json:"model,omitempty"`
+	CreatedAt string `json:"created_at"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	SizeBytes int64  `json:"size_bytes,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+	MIMEType  string `json:"mime_type,omitempty"`
+}
+
+func NewFileStore(root string) (*FileStore, error) {
+	for _, dir := range []string{"brands", "projects", "jobs", "runs", "state"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &FileStore{Root: root}, nil
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
+
+func (s *FileStore) CreateBrand(name string, content string) (Brand, error) {
+	slug := Slugify(name)
+	if slug == "" {
+		return Brand{}, errors.New("brand name is required")
+	}
+	if _, _, err := ParseBrand(content); err != nil {
+		return Brand{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := os.Stat(s.brandMetaPath(slug)); err == nil {
+		return Brand{}, fmt.Errorf("brand %q already exists", slug)
+	}
+	id, err := s.nextID("brands")
+	if err != nil {
+		return Brand{}, err
+	}
+	now := nowText()
+	meta := fileBrandMeta{ID: id, Name: strings.TrimSpace(name), Slug: slug, CreatedAt: now, UpdatedAt: now}
+	if err := writeFileAtomic(s.brandContentPath(slug), []byte(strings.TrimSpace(content)+"\n")); err != nil {
+		return Brand{}, err
+	}
+	if err := writeJSONAtomic(s.brandMetaPath(slug), meta); err != nil {
+		return Brand{}, err
+	}
+	return s.getBrand(slug)
+}
+
+func (s *FileStore) UpdateBrand(slug string, content string, author string) (Brand, error) {
+	slug = Slugify(slug)
+	if slug == "" {
+		return Brand{}, errors.New("brand slug is required")
+	}
+	if _, _, err := ParseBrand(content); err != nil {
+		return Brand{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	meta := fileBrandMeta{}
+	if err := readJSONFile(s.brandMetaPath(slug), &meta); err != nil {
+		return Brand{}, err
+	}
+	meta.UpdatedAt = nowText()
+	if err := writeFileAtomic(s.brandContentPath(slug), []byte(strings.TrimSpace(content)+"\n")); err != nil {
+		return Brand{}, err
+	}
+	if err := writeJSONAtomic(s.brandMetaPath(slug), meta); err != nil {
+		return Brand{}, err
+	}
+	return s.getBrand(slug)
+}
+
+func (s *FileStore) GetBrand(slug string) (Brand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getBrand(slug)
+}
+
+func (s *FileStore) ListBrands() ([]Brand, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(filepath.Join(s.Root, "brands"))
+	if err != nil {
+		return nil, err
+	}
+	brands := []Brand{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		brand, err := s.getBrand(strings.TrimSuffix(e.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		brands = append(brands, brand)
+	}
+	sort.Slice(brands, func(i, j int) bool { return brands[i].Slug < brands[j].Slug })
+	return brands, nil
+}
+
+func (s *FileStore) CreateProject(name string, defaultBrandSlug string) (Project, error) {
+	slug := Slugify(name)
+	if slug == "" {
+		return Project{}, errors.New("project name is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	brandSlug := Slugify(defaultBrandSlug)
+	if brandSlug != "" {
+		if _, err := os.Stat(s.brandMetaPath(brandSlug)); err != nil {
+			return Project{}, fmt.Errorf("brand %q not found", brandSlug)
+		}
+	}
+	if _, err := os.Stat(s.projectPath(slug)); err == nil {
+		return Project{}, fmt.Errorf("project %q already exists", slug)
+	}
+	id, err := s.nextID("projects")
+	if err != nil {
+		return Project{}, err
+	}
+	now := nowText()
+	p := fileProject{ID: id, Name: strings.TrimSpace(name), Slug: slug, DefaultBrandSlug: brandSlug, CreatedAt: now, UpdatedAt: now}
+	if err := writeJSONAtomic(s.projectPath(slug), p); err != nil {
+		return Project{}, err
+	}
+	return s.getProject(slug)
+}
+
+func (s *FileStore) GetProject(slug string) (Project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getProject(slug)
+}
+
+func (s *FileStore) ListProjects() ([]Project, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(filepath.Join(s.Root, "projects"))
+	if err != nil {
+		return nil, err
+	}
+	projects := []Project{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		p, err := s.getProject(e.Name())
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].Slug < projects[j].Slug })
+	return projects, nil
+}
+
+func (s *FileStore) CreateWorkItem(projectSlug string, name string, itemType string, prompt string, brandOverrideSlug string) (WorkItem, error) {
+	projectSlug = Slugify(projectSlug)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := os.Stat(s.projectPath(projectSlug)); err != nil {
+		return WorkItem{}, fmt.Errorf("project %q not found", projectSlug)
+	}
+	slug := Slugify(name)
+	if slug == "" {
+		return WorkItem{}, errors.New("work item name is required")
+	}
+	p := strings.TrimSpace(prompt)
+	if p == "" {
+		return WorkItem{}, errors.New("prompt is required")
+	}
+	t := strings.TrimSpace(itemType)
+	if t == "" {
+		t = "generic"
+	}
+	brandSlug := Slugify(brandOverrideSlug)
+	if brandSlug != "" {
+		if _, err := os.Stat(s.brandMetaPath(brandSlug)); err != nil {
+			return WorkItem{}, fmt.Errorf("brand %q not found", brandSlug)
+		}
+	}
+	if _, err := os.Stat(s.workItemPath(projectSlug, slug)); err == nil {
+		return WorkItem{}, fmt.Errorf("work item %q already exists", slug)
+	}
+	id, err := s.nextID("work_items")
+	if err != nil {
+		return WorkItem{}, err
+	}
+	now := nowText()
+	item := fileWorkItem{ID: id, Name: strings.TrimSpace(name), Slug: slug, Type: t, Prompt: p, BrandOverride: brandSlug, CreatedAt: now, UpdatedAt: now}
+	if err := writeJSONAtomic(s.workItemPath(projectSlug, slug), item); err != nil {
+		return WorkItem{}, err
+	}
+	return s.getWorkItem(projectSlug, slug)
+}
+
+func (s *FileStore) GetWorkItem(projectSlug string, itemSlug string) (WorkItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getWorkItem(projectSlug, itemSlug)
+}
+
+func (s *FileStore) UpdateWorkItemConstraints(projectSlug string, itemSlug string, constraints Constraints) (WorkItem, error) {
+	projectSlug = Slugify(projectSlug)
+	itemSlug = Slugify(itemSlug)
+	constraints = constraints.Normalize()
+	if err := constraints.Validate(); err != nil {
+		return WorkItem{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := fileWorkItem{}
+	if err := readJSONFile(s.workItemPath(projectSlug, itemSlug), &item); err != nil {
+		return WorkItem{}, err
+	}
+	item.Constraints = constraints
+	item.UpdatedAt = nowText()
+	if err := writeJSONAtomic(s.workItemPath(projectSlug, itemSlug), item); err != nil {
+		return WorkItem{}, err
+	}
+	return s.getWorkItem(projectSlug, itemSlug)
+}
+
+func (s *FileStore) ListWorkItems(projectSlug string) ([]WorkItem, error) {
+	projectSlug = Slugify(projectSlug)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := os.ReadDir(filepath.Join(s.Root, "projects", projectSlug, "work-items"))
+	if errors.Is(err, os.ErrNotExist) {
+		return []WorkItem{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	items := []WorkItem{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		item, err := s.getWorkItem(projectSlug, e.Name())
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Slug < items[j].Slug })
+	return items, nil
+}
+
+func (s *FileStore) UpdateWorkItemPrompt(projectSlug string, itemSlug string, prompt string, note string) (WorkItem, error) {
+	projectSlug = Slugify(projectSlug)
+	itemSlug = Slugify(itemSlug)
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
+		return WorkItem{}, errors.New("prompt is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item := fileWorkItem{}
+	if err := readJSONFile(s.workItemPath(projectSlug, itemSlug), &item); err != nil {
+		return WorkItem{}, err
+	}
+	item.Prompt = prompt
+	item.UpdatedAt = nowText()
+	if err := writeJSONAtomic(s.workItemPath(projectSlug, itemSlug), item); err != nil {
+		return WorkItem{}, err
+	}
+	return s.getWorkItem(projectSlug, itemSlug)
+}
+
+func (s *FileStore) CreateGenerateJob(projectSlug string, itemSlug string, payload GenerateJobPayload) (Job, error) {
+	projectSlug = Slugify(projectSlug)
+	itemSlug = Slugify(itemSlug)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, err := s.getWorkItem(projectSlug, itemSlug)
+	if err != nil {
+		return Job{}, err
+	}
+	if payload.Count < 1 {
+		payload.Count = 1
+	}
+	if err := item.Constraints.apply(&payload); err != nil {
+		return Job{}, err
+	}
+	if len(payload.ReferenceAssetIDs) > 0 {
+		return Job{}, errors.New("reference images require -storage sqlite")
+	}
+	if payload.MaxBytes < 0 {
+		return Job{}, errors.New("max bytes must be >= 0")
+	}
+	if payload.MaxBytes > 0 && payload.OutputFormat != "jpg" && payload.OutputFormat != "webp" {
+		return Job{}, fmt.Errorf("max bytes requires jpg or webp output, got %q", payload.OutputFormat)
+	}
+	raw, _ := json.Marshal(payload)
+	id, err := s.nextID("jobs")
+	if err != nil {
+		return Job{}, err
+	}
+	job := fileJob{
+		ID:           id,
+		WorkItemID:   item.ID,
+		ProjectSlug:  projectSlug,
+		WorkItemSlug: itemSlug,
+		Status:       "queued",
+		Payload:      raw,
+		CreatedAt:    nowText(),
+	}
+	if err := writeJSONAtomic(s.jobPath(id), job); err != nil {
+		return Job{}, err
+	}
+	return s.getJob(id)
+}
+
+func (s *FileStore) ListJobs(filter JobFilter) (JobPage, error) {
+	cur, err := decodeCursor(filter.Cursor)
+	if err != nil {
+		return JobPage{}, err
+	}
+	limit := pageLimit(filter.Limit, 50)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	all, err := s.readJobs()
+	if err != nil {
+		return JobPage{}, err
+	}
+	match := fileListingMatcher(filter, cur)
+	kept := []fileJob{}
+	for _, j := range all {
+		if match(j, j.CreatedAt, j.ID) {
+			kept = append(kept, j)
+		}
+	}
+	sortListing(kept, cur, func(j fileJob) (string, int64) { return j.CreatedAt, j.ID })
+	names := map[string]string{}
+	jobs := []Job{}
+	for _, j := range kept[:min(len(kept), limit+1)] {
+		jobs = append(jobs, s.toJob(j, names))
+	}
+	page := JobPage{}
+	page.Jobs, page.NextCursor, page.PrevCursor = finishPage(jobs, limit, cur, func(j Job) pageCursor {
+		return cursorAt(j.CreatedAt, j.ID)
+	})
+	return page, nil
+}
+
+func (s *FileStore) GetJob(jobID int64) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getJob(jobID)
+}
+
+func (s *FileStore) ClaimNextQueuedJob() (*JobExecutionContext, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs, err := s.readJobs()
+	if err != nil {
+		return nil, err
+	}
+	queued := []*fileJob{}
+	for i := range jobs {
+		if jobs[i].Status == "queued" {
+			queued = append(queued, &jobs[i])
+		}
+	}
+	sort.Slice(queued, func(i, j int) bool {
+		if queued[i].CreatedAt != queued[j].CreatedAt {
+			return queued[i].CreatedAt < queued[j].CreatedAt
+		}
+		return queued[i].ID < queued[j].ID
+	})
+
+	for _, next := range queued {
+		project := fileProject{}
+		item := fileWorkItem{}
+		err := readJSONFile(s.projectPath(next.ProjectSlug), &project)
+		if err == nil {
+			err = readJSONFile(s.workItemPath(next.ProjectSlug, next.WorkItemSlug), &item)
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			// The project or work item was removed from the files; fail the
+			// job instead of retrying it on every tick.
+			if err := s.failQueuedJob(next, fmt.Sprintf("work item %s/%s no longer exists", next.ProjectSlug, next.WorkItemSlug)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		brandSlug := item.BrandOverride
+		if brandSlug == "" {
+			brandSlug = project.DefaultBrandSlug
+		}
+		brandContent := ""
+		if brandSlug != "" {
+			data, err := os.ReadFile(s.brandContentPath(brandSlug))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			brandContent = strings.TrimSpace(string(data))
+		}
+
+		payload := GenerateJobPayload{}
+		if err := json.Unmarshal(next.Payload, &payload); err != nil {
+			if err := s.failQueuedJob(next, fmt.Sprintf("invalid job payload: %v", err)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		next.Status = "running"
+		next.StartedAt = nowText()
+		if err := writeJSONAtomic(s.jobPath(next.ID), next); err != nil {
+			return nil, err
+		}
+		return &JobExecutionContext{
+			JobID:        next.ID,
+			WorkItemID:   item.ID,
+			ProjectSlug:  project.Slug,
+			ProjectName:  project.Name,
+			WorkItemSlug: item.Slug,
+			WorkItemName: item.Name,
+			Prompt:       item.Prompt,
+			BrandSlug:    brandSlug,
+			BrandContent: brandContent,
+			Constraints:  item.Constraints,
+			Payload:      payload,
+		}, nil
+	}
+	return nil, nil
+}
+
+// failQueuedJob marks a job that cannot be started failed. The caller holds
+// s.mu.
+func (s *FileStore) failQueuedJob(job *fileJob, message string) error {
+	job.Status = "failed"
+	job.ErrorMessage = message
+	job.FinishedAt = nowText()
+	return writeJSONAtomic(s.jobPath(job.ID), job)
+}
+
+func (s *FileStore) MarkJobSucceeded(jobID int64) error {
+	return s.updateJob(jobID, func(j *fileJob) {
+		j.Status = "succeeded"
+		j.FinishedAt = nowText()
+	})
+}
+
+func (s *FileStore) MarkJobFailed(jobID int64, message string) error {
+	return s.updateJob(jobID, func(j *fileJob) {
+		j.Status = "failed"
+		j.ErrorMessage = strings.TrimSpace(message)
+		j.FinishedAt = nowText()
+	})
+}
+
+func (s *FileStore) CreateRun(ctx *JobExecutionContext, promptSnapshot string, settingsJSON string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobID := ctx.JobID
+	job := fileJob{}
+	if err := readJSONFile(s.jobPath(jobID), &job); err != nil {
+		return 0, err
+	}
+	id, err := s.nextID("runs")
+	if err != nil {
+		return 0, err
+	}
+	run := fileRun{
+		ID:             id,
+		JobID:          jobID,
+		WorkItemID:     ctx.WorkItemID,
+		ProjectSlug:    job.ProjectSlug,
+		WorkItemSlug:   job.WorkItemSlug,
+		PromptSnapshot: promptSnapshot,
+		Settings:       settingsJSON,
+		Status:         "running",
+		CreatedAt:      nowText(),
+		Images:         []fileRunImage{},
+	}
+	if err := writeJSONAtomic(s.runPath(id), run); err != nil {
+		return 0, err
+	}
+	job.RunID = id
+	if err := writeJSONAtomic(s.jobPath(jobID), job); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *FileStore) UpdateRunSettings(runID int64, settingsJSON string) error {
+	return s.updateRun(runID, func(r *fileRun) error {
+		r.Settings = settingsJSON
+		return nil
+	})
+}
+
+func (s *FileStore) MarkRunSucceeded(runID int64) error {
+	return s.updateRun(runID, func(r *fileRun) error {
+		r.Status = "succeeded"
+		r.FinishedAt = nowText()
+		return nil
+	})
+}
+
+func (s *FileStore) MarkRunFailed(runID int64, message string) error {
+	return s.updateRun(runID, func(r *fileRun) error {
+		r.Status = "failed"
+		r.ErrorMessage = strings.TrimSpace(message)
+		r.FinishedAt = nowText()
+		return nil
+	})
+}
+
+func (s *FileStore) AddRunImage(runID int64, filename string, relPath string, format string, model string, meta ImageMeta) error {
+	return s.updateRun(runID, func(r *fileRun) error {
+		id, err := s.nextID("run_images")
+		if err != nil {
+			return err
+		}
+		r.Images = append(r.Images, fileRunImage{
+			ID:        id,
+			Filename:  filename,
+			RelPath:   relPath,
+			Format:    format,
+			Model:     model,
+			CreatedAt: nowText(),
+			Width:     meta.Width,
+			Height:    meta.Height,
+			SizeBytes: meta.SizeBytes,
+			SHA256:    meta.SHA256,
+			MIMEType:  meta.MIMEType,
+		})
+		return nil
+	})
+}
+
+// ListImages ignores Sort: the files backend keeps no ratings, so every image
+// ranks the same and a MinRating filter matches nothing.
+func (s *FileStore) ListImages(filter ImageFilter) (ImagePage, error) {
+	cur, err := decodeCursor(filter.Cursor)
+	if err != nil {
+		return ImagePage{}, err
+	}
+	if filter.MinRating > 0 {
+		return ImagePage{Images: []WorkItemImage{}}, nil
+	}
+	limit := pageLimit(filter.Limit, 40)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs, err := s.readRuns()
+	if err != nil {
+		return ImagePage{}, err
+	}
+	jobs, err := s.readJobs()
+	if err != nil {
+		return ImagePage{}, err
+	}
+	jobByID := map[int64]fileJob{}
+	for _, j := range jobs {
+		jobByID[j.ID] = j
+	}
+	// The model filter applies to each image rather than to the job, which
+	// may have asked for both models.
+	jobFilter := filter.JobFilter
+	jobFilter.Model = ""
+	match := fileListingMatcher(jobFilter, cur)
+	type runImage struct {
+		runID int64
+		img   fileRunImage
+	}
+	kept := []runImage{}
+	for _, run := range runs {
+		job, ok := jobByID[run.JobID]
+		if !ok {
+			continue
+		}
+		for _, img := range run.Images {
+			if (filter.Model == "" || img.Model == filter.Model) && match(job, img.CreatedAt, img.ID) {
+				kept = append(kept, runImage{run.ID, img})
+			}
+		}
+	}
+	sortListing(kept, cur, func(ri runImage) (string, int64) { return ri.img.CreatedAt, ri.img.ID })
+	images := []WorkItemImage{}
+	for _, ri := range kept[:min(len(kept), limit+1)] {
+		images = append(images, ri.img.toImage(ri.runID))
+	}
+	page := ImagePage{}
+	page.Images, page.NextCursor, page.PrevCursor = finishPage(images, limit, cur, func(img WorkItemImage) pageCursor {
+		return cursorAt(img.CreatedAt, img.ID)
+	})
+	return page, nil
+}
+
+func (s *FileStore) ListJobImages(jobID int64) ([]WorkItemImage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs, err := s.readRuns()
+	if err != nil {
+		return nil, err
+	}
+	images := []WorkItemImage{}
+	for _, run := range runs {
+		if run.JobID != jobID {
+			continue
+		}
+		for _, img := range run.Images {
+			images = append(images, img.toImage(run.ID))
+		}
+	}
+	sort.SliceStable(images, func(i, j int) bool { return images[i].CreatedAt.Before(images[j].CreatedAt) })
+	return images, nil
+}
+
+func (s *FileStore) ImagePathByID(imageID int64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs, err := s.readRuns()
+	if err != nil {
+		return "", err
+	}
+	for _, run := range runs {
+		for _, img := range run.Images {
+			if img.ID == imageID {
+				return filepath.Join(s.Root, img.RelPath), nil
+			}
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+func (s *FileStore) WorkItemImagesDir(projectSlug string, itemSlug string, runID int64) string {
+	return filepath.Join(s.Root, "projects", Slugify(projectSlug), "work-items", Slugify(itemSlug), "images", fmt.Sprintf("run-%d", runID))
+}
+
+func (s *FileStore) RelPath(abs string) (string, error) {
+	return filepath.Rel(s.Root, abs)
+}
+
+func (s *FileStore) brandContentPath(slug string) string {
+	return filepath.Join(s.Root, "brands", slug+".md")
+}
+
+func (s *FileStore) brandMetaPath(slug string) string {
+	return filepath.Join(s.Root, "brands", slug+".json")
+}
+
+func (s *FileStore) projectPath(slug string) string {
+	return filepath.Join(s.Root, "projects", slug, "project.json")
+}
+
+func (s *FileStore) workItemPath(projectSlug string, itemSlug string) string {
+	return filepath.Join(s.Root, "projects", projectSlug, "work-items", itemSlug, "work-item.json")
+}
+
+func (s *FileStore) jobPath(id int64) string {
+	return filepath.Join(s.Root, "jobs", strconv.FormatInt(id, 10)+".json")
+}
+
+func (s *FileStore) runPath(id int64) string {
+	return filepath.Join(s.Root, "runs", strconv.FormatInt(id, 10)+".json")
+}
+
+func (s *FileStore) getBrand(slug string) (Brand, error) {
+	slug = Slugify(slug)
+	meta := fileBrandMeta{}
+	if err := readJSONFile(s.brandMetaPath(slug), &meta); err != nil {
+		return Brand{}, err
+	}
+	content, err := os.ReadFile(s.brandContentPath(slug))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Brand{}, err
+	}
+	brand := Brand{
+		ID:        meta.ID,
+		Name:      meta.Name,
+		Slug:      meta.Slug,
+		Content:   strings.TrimSpace(string(content)),
+		CreatedAt: parseTime(meta.CreatedAt),
+		UpdatedAt: parseTime(meta.UpdatedAt),
+	}
+	brand.Spec, brand.Body, _ = ParseBrand(brand.Content)
+	return brand, nil
+}
+
+func (s *FileStore) getProject(slug string) (Project, error) {
+	slug = Slugify(slug)
+	p := fileProject{}
+	if err := readJSONFile(s.projectPath(slug), &p); err != nil {
+		return Project{}, err
+	}
+	count := 0
+	entries, err := os.ReadDir(filepath.Join(s.Root, "projects", slug, "work-items"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Project{}, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			count++
+		}
+	}
+	return Project{
+		ID:               p.ID,
+		Name:             p.Name,
+		Slug:             p.Slug,
+		DefaultBrandSlug: p.DefaultBrandSlug,
+		CreatedAt:        parseTime(p.CreatedAt),
+		UpdatedAt:        parseTime(p.UpdatedAt),
+		WorkItemCount:    count,
+	}, nil
+}
+
+func (s *FileStore) getWorkItem(projectSlug string, itemSlug string) (WorkItem, error) {
+	projectSlug = Slugify(projectSlug)
+	itemSlug = Slugify(itemSlug)
+	p := fileProject{}
+	if err := readJSONFile(s.projectPath(projectSlug), &p); err != nil {
+		return WorkItem{}, err
+	}
+	item := fileWorkItem{}
+	if err := readJSONFile(s.workItemPath(projectSlug, itemSlug), &item); err != nil {
+		return WorkItem{}, err
+	}
+	return WorkItem{
+		ID:            item.ID,
+		Name:          item.Name,
+		Slug:          item.Slug,
+		Type:          item.Type,
+		Prompt:        item.Prompt,
+		ProjectID:     p.ID,
+		ProjectSlug:   p.Slug,
+		BrandOverride: item.BrandOverride,
+		Status:        StatusDraft,
+		Constraints:   item.Constraints,
+		CreatedAt:     parseTime(item.CreatedAt),
+		UpdatedAt:     parseTime(item.UpdatedAt),
+	}, nil
+}
+
+func (s *FileStore) getJob(id int64) (Job, error) {
+	j := fileJob{}
+	if err := readJSONFile(s.jobPath(id), &j); err != nil {
+		return Job{}, err
+	}
+	return s.toJob(j, map[string]string{}), nil
+}
+
+// fileListingMatcher mirrors listingConds for the files backend: it reports
+// whether a row with the given sort key, produced by job j, passes the filter
+// and lies beyond the cursor. The files backend has no tags, so a tag filter
+// matches nothing.
+func fileListingMatcher(f JobFilter, cur *pageCursor) func(j fileJob, createdAt string, id int64) bool {
+	projectSlug, itemSlug := Slugify(f.ProjectSlug), Slugify(f.WorkItemSlug)
+	fromText, toText := filterTime(f.From), filterTime(f.To)
+	return func(j fileJob, createdAt string, id int64) bool {
+		if (f.Status != "" && j.Status != f.Status) ||
+			(projectSlug != "" && j.ProjectSlug != projectSlug) ||
+			(itemSlug != "" && j.WorkItemSlug != itemSlug) ||
+			(fromText != "" && createdAt < fromText) ||
+			(toText != "" && createdAt >= toText) ||
+			f.Tag != "" {
+			return false
+		}
+		if f.Model != "" {
+			payload := GenerateJobPayload{}
+			if json.Unmarshal(j.Payload, &payload) != nil || payload.Model != f.Model {
+				return false
+			}
+		}
+		if cur == nil {
+			return true
+		}
+		if cur.Newer {
+			return createdAt > cur.CreatedAt || (createdAt == cur.CreatedAt && id > cur.ID)
+		}
+		return createdAt < cur.CreatedAt || (createdAt == cur.CreatedAt && id < cur.ID)
+	}
+}
+
+// sortListing orders rows newest first, or oldest first when paging towards
+// newer rows, matching the SQL listings.
+func sortListing[T any](rows []T, cur *pageCursor, key func(T) (string, int64)) {
+	asc := cur != nil && cur.Newer
+	sort.SliceStable(rows, func(i, j int) bool {
+		ai, idi := key(rows[i])
+		aj, idj := key(rows[j])
+		if ai == aj {
+			return (idi < idj) == asc
+		}
+		return (ai < aj) == asc
+	})
+}
+
+// toJob resolves project and work item names, memoizing lookups in names.
+func (s *FileStore) toJob(j fileJob, names map[string]string) Job {
+	projectName, ok := names[j.ProjectSlug]
+	if !ok {
+		p := fileProject{}
+		_ = readJSONFile(s.projectPath(j.ProjectSlug), &p)
+		projectName = p.Name
+		names[j.ProjectSlug] = projectName
+	}
+	itemKey := j.ProjectSlug + "/" + j.WorkItemSlug
+	itemName, ok := names[itemKey]
+	if !ok {
+		item := fileWorkItem{}
+		_ = readJSONFile(s.workItemPath(j.ProjectSlug, j.WorkItemSlug), &item)
+		itemName = item.Name
+		names[itemKey] = itemName
+	}
+	payload := bytes.Buffer{}
+	if err := json.Compact(&payload, j.Payload); err != nil {
+		payload.Write(j.Payload)
+	}
+	job := Job{
+		ID:           j.ID,
+		Status:       j.Status,
+		ProjectSlug:  j.ProjectSlug,
+		ProjectName:  projectName,
+		WorkItemSlug: j.WorkItemSlug,
+		WorkItemName: itemName,
+		PayloadJSON:  payload.String(),
+		ErrorMessage: j.ErrorMessage,
+		CreatedAt:    parseTime(j.CreatedAt),
+	}
+	if j.StartedAt != "" {
+		t := parseTime(j.StartedAt)
+		job.StartedAt = &t
+	}
+	if j.FinishedAt != "" {
+		t := parseTime(j.FinishedAt)
+		job.FinishedAt = &t
+	}
+	if j.RunID > 0 {
+		runID := j.RunID
+		job.RunID = &runID
+	}
+	return job
+}
+
+func (s *FileStore) readJobs() ([]fileJob, error) {
+	return readJSONDir[fileJob](filepath.Join(s.Root, "jobs"))
+}
+
+func (s *FileStore) readRuns() ([]fileRun, error) {
+	return readJSONDir[fileRun](filepath.Join(s.Root, "runs"))
+}
+
+func (s *FileStore) updateJob(id int64, fn func(*fileJob)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := fileJob{}
+	if err := readJSONFile(s.jobPath(id), &j); err != nil {
+		return err
+	}
+	fn(&j)
+	return writeJSONAtomic(s.jobPath(id), j)
+}
+
+func (s *FileStore) updateRun(id int64, fn func(*fileRun) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := fileRun{}
+	if err := readJSONFile(s.runPath(id), &r); err != nil {
+		return err
+	}
+	if err := fn(&r); err != nil {
+		return err
+	}
+	return writeJSONAtomic(s.runPath(id), r)
+}
+
+// nextID must be called with s.mu held.
+func (s *FileStore) nextID(kind string) (int64, error) {
+	path := filepath.Join(s.Root, "state", "sequences.json")
+	seq := map[string]int64{}
+	if err := readJSONFile(path, &seq); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	seq[kind]++
+	if err := writeJSONAtomic(path, seq); err != nil {
+		return 0, err
+	}
+	return seq[kind], nil
+}
+
+func (img fileRunImage) toImage(runID int64) WorkItemImage {
+	return WorkItemImage{
+		ID:        img.ID,
+		RunID:     runID,
+		Name:      img.Filename,
+		URL:       fmt.Sprintf("/images/%d", img.ID),
+		CreatedAt: parseTime(img.CreatedAt),
+		ImageMeta: ImageMeta{
+			Width:     img.Width,
+			Height:    img.Height,
+			SizeBytes: img.SizeBytes,
+			SHA256:    img.SHA256,
+			MIMEType:  img.MIMEType,
+		},
+	}
+}
+
+func readJSONFile(path string, target any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	return nil
+}
+
+func readJSONDir[T any](dir string) ([]T, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	items := []T{}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		var item T
+		if err := readJSONFile(filepath.Join(dir, e.Name()), &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func writeJSONAtomic(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
@@ -0,0 +1,113 @@
+package webapp
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// finalRelPath is where a work item's final copy lives. The name only
+// depends on the work item, so links to it keep working when the pick
+// changes; a different file extension is the one exception.
+func finalRelPath(projectSlug string, itemSlug string, ext string) string {
+	return filepath.Join("images", projectSlug, itemSlug, "final", itemSlug+"-final"+strings.ToLower(ext))
+}
+
+// FinalizeImage copies one of a work item's images to its final/ directory
+// and records it as the work item's deliverable, replacing any earlier pick.
+func (s *Store) FinalizeImage(workItemID int64, imageID int64, finalizedBy string) (Final, error) {
+	final := Final{WorkItemID: workItemID, ImageID: imageID, FinalizedBy: strings.TrimSpace(finalizedBy)}
+	var previous string
+	err := s.withTx(func(tx *sql.Tx) error {
+		var source, projectSlug, itemSlug string
+		err := tx.QueryRow(`
+			SELECT ri.filename, ri.rel_path, p.slug, w.slug
+			FROM run_images ri
+			JOIN runs r ON r.id = ri.run_id
+			JOIN work_items w ON w.id = r.work_item_id
+			JOIN projects p ON p.id = w.project_id
+			WHERE ri.id = ? AND w.id = ?;
+		`, imageID, workItemID).Scan(&final.Filename, &source, &projectSlug, &itemSlug)
+		if err != nil {
+			return notFound(err)
+		}
+		err = tx.QueryRow(`SELECT rel_path FROM work_item_finals WHERE work_item_id = ?;`, workItemID).Scan(&previous)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		final.RelPath = finalRelPath(projectSlug, itemSlug, filepath.Ext(final.Filename))
+		now := nowText()
+		final.FinalizedAt = parseTime(now)
+		if _, err := tx.Exec(`
+			INSERT INTO work_item_finals (work_item_id, image_id, filename, rel_path, finalized_by, finalized_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(work_item_id) DO UPDATE SET
+				image_id = excluded.image_id,
+				filename = excluded.filename,
+				rel_path = excluded.rel_path,
+				finalized_by = excluded.finalized_by,
+				finalized_at = excluded.finalized_at;
+		`, workItemID, imageID, final.Filename, final.RelPath, final.FinalizedBy, now); err != nil {
+			return err
+		}
+		if err := autoStatus(tx, workItemID, StatusApproved, "finalized "+final.Filename,
+			StatusDraft, StatusGenerating, StatusInReview); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filepath.Join(s.Root, source))
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(filepath.Join(s.Root, final.RelPath), data)
+	})
+	if err != nil {
+		return Final{}, err
+	}
+	if previous != "" && previous != final.RelPath {
+		if err := os.Remove(filepath.Join(s.Root, previous)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return final, err
+		}
+	}
+	return final, nil
+}
+
+// Unfinalize clears a work item's final and deletes its copy; an approved
+// work item goes back to review. It returns os.ErrNotExist when nothing was
+// finalized.
+func (s *Store) Unfinalize(workItemID int64) error {
+	var relPath string
+	err := s.withTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`
+			DELETE FROM work_item_finals WHERE work_item_id = ? RETURNING rel_path;
+		`, workItemID).Scan(&relPath)
+		if err != nil {
+			return notFound(err)
+		}
+		return autoStatus(tx, workItemID, StatusInReview, "final cleared", StatusApproved)
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(s.Root, relPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// FinalPath returns the file of a work item's current final.
+func (s *Store) FinalPath(projectSlug string, itemSlug string) (string, error) {
+	var relPath string
+	err := s.db.QueryRow(`
+		SELECT f.rel_path
+		FROM work_item_finals f
+		JOIN work_items w ON w.id = f.work_item_id
+		JOIN projects p ON p.id = w.project_id
+		WHERE p.slug = ? AND w.slug = ?;
+	`, Slugify(projectSlug), Slugify(itemSlug)).Scan(&relPath)
+	if err != nil {
+		return "", notFound(err)
+	}
+	return filepath.Join(s.Root, relPath), nil
+}
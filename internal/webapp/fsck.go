@@ -0,0 +1,424 @@
+package webapp
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stuckJobAfter is how long a job may stay running before Fsck treats it as
+// abandoned by a worker that died. It is well past generateTimeout.
+const stuckJobAfter = 4 * generateTimeout
+
+const (
+	FsckMissing      = "missing"
+	FsckUnreadable   = "unreadable"
+	FsckHashMismatch = "hash mismatch"
+)
+
+// FsckReport lists what Fsck found in the database and the files it points
+// at. Paths are relative to the data root.
+type FsckReport struct {
+	Integrity    []string
+	ForeignKeys  []FsckForeignKey
+	StuckJobs    []FsckJob
+	DanglingRuns []FsckJob
+	EmptyRuns    []FsckRun
+	Files        []FsckFile
+	// Repairs describes what Fsck changed when applying.
+	Repairs []string
+	Applied bool
+}
+
+// FsckForeignKey is a row whose parent row is gone.
+type FsckForeignKey struct {
+	Table  string
+	RowID  int64
+	Parent string
+}
+
+type FsckJob struct {
+	JobID     int64
+	RunID     int64
+	StartedAt time.Time
+}
+
+// FsckRun is a succeeded run without image records. Recoverable lists image
+// files still in the run's directory.
+type FsckRun struct {
+	RunID       int64
+	JobID       int64
+	Dir         string
+	Recoverable []string
+	jobModel    string
+}
+
+// FsckFile is a file referenced by a run image, final copy or brand asset
+// that is missing, cannot be read, or no longer matches its recorded hash.
+type FsckFile struct {
+	Table   string
+	ID      int64
+	RelPath string
+	Problem string
+	Detail  string
+}
+
+// FsckOptions selects what Fsck changes; the zero value only reports.
+type FsckOptions struct {
+	Repair bool
+	// DeleteMissing also deletes the records of run images and brand assets
+	// whose file is missing, together with their ratings, tags, comparisons
+	// and finals. It is not a safe repair: the files may only be on a volume
+	// that is not mounted.
+	DeleteMissing bool
+}
+
+func (r FsckReport) Clean() bool {
+	return len(r.Integrity) == 0 && len(r.ForeignKeys) == 0 && len(r.StuckJobs) == 0 &&
+		len(r.DanglingRuns) == 0 && len(r.EmptyRuns) == 0 && len(r.Files) == 0
+}
+
+// Repairable reports whether the safe repairs would change anything.
+// Database corruption, unreadable files and hash mismatches are only
+// reported, and records of missing files are kept unless DeleteMissing is set.
+func (r FsckReport) Repairable() bool {
+	if len(r.StuckJobs) > 0 || len(r.DanglingRuns) > 0 || len(r.EmptyRuns) > 0 {
+		return true
+	}
+	for _, f := range r.Files {
+		if f.Problem == FsckMissing && f.Table == "work_item_finals" {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingRecords counts the run image and brand asset records whose file is
+// missing, which only DeleteMissing removes.
+func (r FsckReport) MissingRecords() int {
+	n := 0
+	for _, f := range r.Files {
+		if f.Problem == FsckMissing && f.Table != "work_item_finals" {
+			n++
+		}
+	}
+	return n
+}
+
+// Fsck checks the database and the image and brand asset files it
+// references. With opts.Repair set it makes the safe repairs, all database
+// changes in one transaction:
+//   - stuck jobs and their runs are marked failed;
+//   - job run_id values pointing at no run are cleared;
+//   - image files left in an empty run's directory are recorded again, and
+//     runs with none are marked failed;
+//   - missing final copies are copied again from their source image.
+//
+// With opts.DeleteMissing set as well, records of missing run images and
+// brand assets are deleted.
+func (s *Store) Fsck(opts FsckOptions) (FsckReport, error) {
+	report := FsckReport{Applied: opts.Repair}
+	if err := s.fsckDatabase(&report); err != nil {
+		return report, err
+	}
+	if err := s.fsckJobs(&report); err != nil {
+		return report, err
+	}
+	if err := s.fsckFiles(&report); err != nil {
+		return report, err
+	}
+	if !opts.Repair {
+		return report, nil
+	}
+	return report, s.repair(&report, opts.DeleteMissing)
+}
+
+func (s *Store) fsckDatabase(report *FsckReport) error {
+	rows, err := s.db.Query(`PRAGMA integrity_check;`)
+	if err != nil {
+		return err
+	}
+	lines, err := collectRows(rows, func(sc rowScanner) (string, error) {
+		var line string
+		err := sc.Scan(&line)
+		return line, err
+	})
+	if err != nil {
+		return err
+	}
+	if len(lines) != 1 || lines[0] != "ok" {
+		report.Integrity = lines
+	}
+
+	rows, err = s.db.Query(`PRAGMA foreign_key_check;`)
+	if err != nil {
+		return err
+	}
+	report.ForeignKeys, err = collectRows(rows, func(sc rowScanner) (FsckForeignKey, error) {
+		var fk FsckForeignKey
+		var rowID sql.NullInt64
+		var index int
+		err := sc.Scan(&fk.Table, &rowID, &fk.Parent, &index)
+		fk.RowID = rowID.Int64
+		return fk, err
+	})
+	return err
+}
+
+func (s *Store) fsckJobs(report *FsckReport) error {
+	cutoff := time.Now().Add(-stuckJobAfter)
+	rows, err := s.db.Query(`
+		SELECT j.id, COALESCE(r.id, 0), COALESCE(j.started_at, '')
+		FROM jobs j
+		LEFT JOIN runs r ON r.job_id = j.id
+		WHERE j.status = 'running'
+		ORDER BY j.id;
+	`)
+	if err != nil {
+		return err
+	}
+	running, err := collectRows(rows, func(sc rowScanner) (FsckJob, error) {
+		var job FsckJob
+		var started string
+		err := sc.Scan(&job.JobID, &job.RunID, &started)
+		job.StartedAt = parseTime(started)
+		return job, err
+	})
+	if err != nil {
+		return err
+	}
+	for _, job := range running {
+		if job.StartedAt.Before(cutoff) {
+			report.StuckJobs = append(report.StuckJobs, job)
+		}
+	}
+
+	rows, err = s.db.Query(`
+		SELECT j.id, j.run_id
+		FROM jobs j
+		WHERE j.run_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM runs r WHERE r.id = j.run_id)
+		ORDER BY j.id;
+	`)
+	if err != nil {
+		return err
+	}
+	report.DanglingRuns, err = collectRows(rows, func(sc rowScanner) (FsckJob, error) {
+		var job FsckJob
+		err := sc.Scan(&job.JobID, &job.RunID)
+		return job, err
+	})
+	if err != nil {
+		return err
+	}
+
+	report.EmptyRuns, err = s.emptyRuns(s.db)
+	return err
+}
+
+// emptyRuns finds succeeded runs without image records.
+func (s *Store) emptyRuns(db dbtx) ([]FsckRun, error) {
+	rows, err := db.Query(`
+		SELECT r.id, r.job_id, p.slug, w.slug, COALESCE(json_extract(j.payload_json, '$.model'), ''), r.settings_json
+		FROM runs r
+		JOIN jobs j ON j.id = r.job_id
+		JOIN work_items w ON w.id = r.work_item_id
+		JOIN projects p ON p.id = w.project_id
+		WHERE r.status = 'succeeded' AND NOT EXISTS (SELECT 1 FROM run_images ri WHERE ri.run_id = r.id)
+		ORDER BY r.id;
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return collectRows(rows, func(sc rowScanner) (FsckRun, error) {
+		var run FsckRun
+		var projectSlug, itemSlug, settingsJSON string
+		if err := sc.Scan(&run.RunID, &run.JobID, &projectSlug, &itemSlug, &run.jobModel, &settingsJSON); err != nil {
+			return run, err
+		}
+		// Originals of fitted images are not candidates.
+		originals := map[string]bool{}
+		for _, name := range fitOriginals(settingsJSON) {
+			originals[name] = true
+		}
+		dir := s.WorkItemImagesDir(projectSlug, itemSlug, run.RunID)
+		run.Dir, _ = s.RelPath(dir)
+		entries, err := os.ReadDir(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return run, err
+		}
+		for _, e := range entries {
+			if !e.IsDir() && !originals[e.Name()] && isImageExt(strings.ToLower(filepath.Ext(e.Name()))) {
+				run.Recoverable = append(run.Recoverable, e.Name())
+			}
+		}
+		return run, nil
+	})
+}
+
+func (s *Store) fsckFiles(report *FsckReport) error {
+	type ref struct {
+		table, relPath, sha256 string
+		id                     int64
+	}
+	rows, err := s.db.Query(`
+		SELECT 'run_images', id, rel_path, sha256 FROM run_images
+		UNION ALL
+		SELECT 'work_item_finals', work_item_id, rel_path, '' FROM work_item_finals
+		UNION ALL
+		SELECT 'brand_assets', id, rel_path, sha256 FROM brand_assets
+		ORDER BY 1, 2;
+	`)
+	if err != nil {
+		return err
+	}
+	refs, err := collectRows(rows, func(sc rowScanner) (ref, error) {
+		var r ref
+		err := sc.Scan(&r.table, &r.id, &r.relPath, &r.sha256)
+		return r, err
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range refs {
+		problem := FsckFile{Table: r.table, ID: r.id, RelPath: r.relPath}
+		data, err := os.ReadFile(filepath.Join(s.Root, r.relPath))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			problem.Problem = FsckMissing
+		case err != nil:
+			problem.Problem, problem.Detail = FsckUnreadable, err.Error()
+		case r.sha256 != "":
+			if sum := imageMetaOf(data).SHA256; sum != r.sha256 {
+				problem.Problem = FsckHashMismatch
+				problem.Detail = fmt.Sprintf("recorded %s, file is %s", shortHash(r.sha256), shortHash(sum))
+			}
+		}
+		if problem.Problem != "" {
+			report.Files = append(report.Files, problem)
+		}
+	}
+	return nil
+}
+
+func (s *Store) repair(report *FsckReport, deleteMissing bool) error {
+	// Final copies are files only, so they are copied again outside the
+	// transaction.
+	for _, f := range report.Files {
+		if f.Problem != FsckMissing || f.Table != "work_item_finals" {
+			continue
+		}
+		var source string
+		err := s.db.QueryRow(`
+			SELECT ri.rel_path FROM work_item_finals f JOIN run_images ri ON ri.id = f.image_id
+			WHERE f.work_item_id = ?;
+		`, f.ID).Scan(&source)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filepath.Join(s.Root, source))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(s.Root, f.RelPath), data); err != nil {
+			return err
+		}
+		report.Repairs = append(report.Repairs, fmt.Sprintf("copied final of work item #%d again from %s", f.ID, source))
+	}
+
+	var repairs []string
+	err := s.withTx(func(tx *sql.Tx) error {
+		for _, job := range report.StuckJobs {
+			msg := fmt.Sprintf("interrupted: still running after %s", stuckJobAfter)
+			if job.RunID != 0 {
+				if err := markRunFailed(tx, job.RunID, msg); err != nil {
+					return err
+				}
+			}
+			if err := markJobFailed(tx, job.JobID, msg); err != nil {
+				return err
+			}
+			repairs = append(repairs, fmt.Sprintf("marked stuck job #%d failed", job.JobID))
+		}
+		for _, job := range report.DanglingRuns {
+			if _, err := tx.Exec(`UPDATE jobs SET run_id = NULL WHERE id = ?;`, job.JobID); err != nil {
+				return err
+			}
+			repairs = append(repairs, fmt.Sprintf("cleared missing run #%d from job #%d", job.RunID, job.JobID))
+		}
+		if deleteMissing {
+			for _, f := range report.Files {
+				if f.Problem != FsckMissing {
+					continue
+				}
+				switch f.Table {
+				case "run_images":
+					if _, err := tx.Exec(`DELETE FROM run_images WHERE id = ?;`, f.ID); err != nil {
+						return err
+					}
+					repairs = append(repairs, fmt.Sprintf("deleted record of missing image #%d", f.ID))
+				case "brand_assets":
+					if _, err := tx.Exec(`DELETE FROM brand_assets WHERE id = ?;`, f.ID); err != nil {
+						return err
+					}
+					repairs = append(repairs, fmt.Sprintf("deleted record of missing brand asset #%d", f.ID))
+				}
+			}
+		}
+		// Deleting records of missing images can leave more runs empty, so
+		// look for them again.
+		runs, err := s.emptyRuns(tx)
+		if err != nil {
+			return err
+		}
+		for _, run := range runs {
+			if len(run.Recoverable) == 0 {
+				msg := "finished without images"
+				if err := markRunFailed(tx, run.RunID, msg); err != nil {
+					return err
+				}
+				if err := markJobFailed(tx, run.JobID, msg); err != nil {
+					return err
+				}
+				repairs = append(repairs, fmt.Sprintf("marked empty run #%d and job #%d failed", run.RunID, run.JobID))
+				continue
+			}
+			for _, name := range run.Recoverable {
+				rel := filepath.Join(run.Dir, name)
+				meta, err := readImageMeta(filepath.Join(s.Root, rel))
+				if err != nil {
+					return err
+				}
+				format := strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+				if err := addRunImage(tx, run.RunID, name, rel, format, imageModel(run.jobModel, name), meta); err != nil {
+					return err
+				}
+			}
+			repairs = append(repairs, fmt.Sprintf("recorded %d images found in run #%d", len(run.Recoverable), run.RunID))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	report.Repairs = append(report.Repairs, repairs...)
+	return nil
+}
+
+// shortHash shortens a hex digest for display. Recorded hashes come from the
+// database and may be shorter than expected.
+func shortHash(sum string) string {
+	if len(sum) < 12 {
+		return sum
+	}
+	return sum[:12]
+}
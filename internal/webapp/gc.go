@@ -0,0 +1,284 @@
+package webapp
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gcGracePeriod keeps files younger than this out of GC, so images of a run
+// that started after GC read the database are not taken for orphans.
+const gcGracePeriod = time.Hour
+
+// GCReport describes how the images directory differs from run_images.
+// Paths are relative to the data root. MissingFiles is only reported; see
+// Fsck with DeleteMissing.
+type GCReport struct {
+	OrphanFiles      []GCFile
+	MissingFiles     []GCMissingImage
+	EmptyDirs        []string
+	ReclaimableBytes int64
+	Applied          bool
+}
+
+type GCFile struct {
+	RelPath string
+	Bytes   int64
+}
+
+type GCMissingImage struct {
+	ImageID int64
+	RelPath string
+}
+
+func (r GCReport) Clean() bool {
+	return len(r.OrphanFiles) == 0 && len(r.MissingFiles) == 0 && len(r.EmptyDirs) == 0
+}
+
+// Deletable reports whether applying would remove anything.
+func (r GCReport) Deletable() bool {
+	return len(r.OrphanFiles) > 0 || len(r.EmptyDirs) > 0
+}
+
+// CollectGarbage reconciles <root>/images with the run_images table. It finds
+// files without a row, rows without a file and empty directories. Final
+// copies recorded in work_item_finals are kept, as are the originals of images
+// fitted under a max file size. With apply set it deletes the
+// orphan files and empty directories it found. Rows without a file are only
+// reported: deleting them would take their ratings, tags, comparisons and
+// final along, and the file may only be on a volume that is not mounted, so
+// that is left to Fsck with DeleteMissing. Files belonging to runs that are
+// still running, and files younger than gcGracePeriod, are never touched.
+func (s *Store) CollectGarbage(apply bool) (GCReport, error) {
+	report := GCReport{Applied: apply}
+
+	// Read everything in one transaction, running runs first: a run that
+	// finishes meanwhile is then either skipped as running or has its images
+	// among the known rows.
+	known := map[string]int64{}
+	// kept holds files that have no run_images row but are not orphans:
+	// final copies and originals of fitted images.
+	kept := map[string]bool{}
+	running := map[string]bool{}
+	err := s.withTx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT id FROM runs WHERE status = 'running';`)
+		if err != nil {
+			return err
+		}
+		ids, err := collectRows(rows, func(sc rowScanner) (int64, error) {
+			var id int64
+			err := sc.Scan(&id)
+			return id, err
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			running["run-"+strconv.FormatInt(id, 10)] = true
+		}
+
+		rows, err = tx.Query(`SELECT id, rel_path FROM run_images;`)
+		if err != nil {
+			return err
+		}
+		images, err := collectRows(rows, func(sc rowScanner) (GCMissingImage, error) {
+			var img GCMissingImage
+			err := sc.Scan(&img.ImageID, &img.RelPath)
+			return img, err
+		})
+		if err != nil {
+			return err
+		}
+		for _, img := range images {
+			known[filepath.Clean(img.RelPath)] = img.ImageID
+		}
+
+		rows, err = tx.Query(`SELECT rel_path FROM work_item_finals;`)
+		if err != nil {
+			return err
+		}
+		rels, err := collectRows(rows, func(sc rowScanner) (string, error) {
+			var rel string
+			err := sc.Scan(&rel)
+			return rel, err
+		})
+		if err != nil {
+			return err
+		}
+		for _, rel := range rels {
+			kept[filepath.Clean(rel)] = true
+		}
+
+		rows, err = tx.Query(`
+			SELECT ri.rel_path, r.settings_json
+			FROM run_images ri
+			JOIN runs r ON r.id = ri.run_id
+			WHERE json_valid(r.settings_json) AND json_array_length(r.settings_json, '$.encodes') > 0;
+		`)
+		if err != nil {
+			return err
+		}
+		fitted, err := collectRows(rows, func(sc rowScanner) ([2]string, error) {
+			var f [2]string
+			err := sc.Scan(&f[0], &f[1])
+			return f, err
+		})
+		if err != nil {
+			return err
+		}
+		for _, f := range fitted {
+			rel := filepath.Clean(f[0])
+			if original := fitOriginals(f[1])[filepath.Base(rel)]; original != "" {
+				kept[filepath.Join(filepath.Dir(rel), original)] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	youngest := time.Now().Add(-gcGracePeriod)
+	imagesDir := filepath.Join(s.Root, "images")
+	seen := map[string]bool{}
+	dirs := []string{}
+	err = filepath.WalkDir(imagesDir, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == imagesDir {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if running[d.Name()] {
+				return fs.SkipDir
+			}
+			if p == imagesDir {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if !info.ModTime().After(youngest) {
+				dirs = append(dirs, p)
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(s.Root, p)
+		if err != nil {
+			return err
+		}
+		if _, ok := known[rel]; ok {
+			seen[rel] = true
+			return nil
+		}
+		if kept[rel] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(youngest) {
+			// Possibly written by a run that started after the read.
+			return nil
+		}
+		report.OrphanFiles = append(report.OrphanFiles, GCFile{RelPath: rel, Bytes: info.Size()})
+		report.ReclaimableBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for rel, id := range known {
+		if seen[rel] {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(s.Root, rel)); errors.Is(err, os.ErrNotExist) {
+			report.MissingFiles = append(report.MissingFiles, GCMissingImage{ImageID: id, RelPath: rel})
+		}
+	}
+	sort.Slice(report.MissingFiles, func(i, j int) bool { return report.MissingFiles[i].ImageID < report.MissingFiles[j].ImageID })
+
+	// A directory is empty once its orphan files are gone and every
+	// subdirectory is empty too; walk deepest first.
+	orphaned := map[string]bool{}
+	for _, f := range report.OrphanFiles {
+		orphaned[filepath.Join(s.Root, f.RelPath)] = true
+	}
+	emptyDir := map[string]bool{}
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return report, err
+		}
+		empty := true
+		for _, e := range entries {
+			child := filepath.Join(dir, e.Name())
+			if !orphaned[child] && !emptyDir[child] {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			emptyDir[dir] = true
+			rel, _ := filepath.Rel(s.Root, dir)
+			report.EmptyDirs = append(report.EmptyDirs, rel)
+		}
+	}
+
+	if !apply {
+		return report, nil
+	}
+	for _, f := range report.OrphanFiles {
+		if err := os.Remove(filepath.Join(s.Root, f.RelPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return report, err
+		}
+	}
+	for _, rel := range report.EmptyDirs {
+		if err := os.Remove(filepath.Join(s.Root, rel)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	value := strconv.FormatFloat(float64(n)/float64(div), 'f', 1, 64)
+	return strings.TrimSuffix(value, ".0") + " " + string("KMGTPE"[exp]) + "iB"
+}
+
+// fitOriginals maps the names of a run's fitted images to the generated files
+// they were made from, as recorded in its settings.
+func fitOriginals(settingsJSON string) map[string]string {
+	var settings RunSettings
+	if json.Unmarshal([]byte(settingsJSON), &settings) != nil {
+		return nil
+	}
+	originals := map[string]string{}
+	for _, e := range settings.Encodes {
+		if e.Original != "" && filepath.Base(e.Original) == e.Original {
+			originals[e.Filename] = e.Original
+		}
+	}
+	return originals
+}
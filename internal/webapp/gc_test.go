@@ -0,0 +1,66 @@
+package webapp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbageKeepsOriginalsAndMissingRows(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.CreateProject("Launch", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateWorkItem("launch", "Hero", "", "a kite", ""); err != nil {
+		t.Fatal(err)
+	}
+	fittedID := addFinishedJob(t, store, "launch", "hero", "fitted")
+	missingID := addFinishedJob(t, store, "launch", "hero", "gone")
+
+	var fittedRel, missingRel string
+	var runID int64
+	if err := store.db.QueryRow(`SELECT run_id, rel_path FROM run_images WHERE id = ?;`, fittedID).Scan(&runID, &fittedRel); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.db.QueryRow(`SELECT rel_path FROM run_images WHERE id = ?;`, missingID).Scan(&missingRel); err != nil {
+		t.Fatal(err)
+	}
+	settings := `{"model":"openai","max_bytes":5000,"encodes":[{"filename":"openai-1.png","original":"openai-1-original.png","quality":80,"width":8,"height":8,"bytes":6}]}`
+	if err := store.UpdateRunSettings(runID, settings); err != nil {
+		t.Fatal(err)
+	}
+	originalRel := filepath.Join(filepath.Dir(fittedRel), "openai-1-original.png")
+	orphanRel := filepath.Join(filepath.Dir(fittedRel), "stray.png")
+	writeDataFile(t, store.Root, originalRel, "original")
+	writeDataFile(t, store.Root, orphanRel, "stray")
+	if err := os.Remove(filepath.Join(store.Root, missingRel)); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * gcGracePeriod)
+	for _, rel := range []string{fittedRel, originalRel, orphanRel} {
+		if err := os.Chtimes(filepath.Join(store.Root, rel), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := store.CollectGarbage(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.OrphanFiles) != 1 || report.OrphanFiles[0].RelPath != orphanRel {
+		t.Fatalf("orphans = %+v, want only %s", report.OrphanFiles, orphanRel)
+	}
+	if len(report.MissingFiles) != 1 || report.MissingFiles[0].ImageID != missingID {
+		t.Fatalf("missing = %+v, want image %d", report.MissingFiles, missingID)
+	}
+	for rel, want := range map[string]bool{fittedRel: true, originalRel: true, orphanRel: false} {
+		if _, err := os.Stat(filepath.Join(store.Root, rel)); (err == nil) != want {
+			t.Errorf("%s exists = %v, want %v", rel, err == nil, want)
+		}
+	}
+	var rows int
+	if err := store.db.QueryRow(`SELECT COUNT(*) FROM run_images WHERE id = ?;`, missingID).Scan(&rows); err != nil || rows != 1 {
+		t.Fatalf("record of missing file deleted by gc: %d rows, %v", rows, err)
+	}
+}
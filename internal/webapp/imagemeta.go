@@ -0,0 +1,73 @@
+package webapp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"imagegen/internal/imageconv"
+)
+
+// imageMetaOf hashes and probes an encoded image. Size and hash are always
+// set; dimensions and MIME type only when the header can be read.
+func imageMetaOf(data []byte) ImageMeta {
+	sum := sha256.Sum256(data)
+	meta := ImageMeta{SizeBytes: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+	if info, err := imageconv.Probe(data); err == nil {
+		meta.Width, meta.Height, meta.MIMEType = info.Width, info.Height, info.MIMEType
+	}
+	return meta
+}
+
+func readImageMeta(path string) (ImageMeta, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ImageMeta{}, err
+	}
+	return imageMetaOf(data), nil
+}
+
+// BackfillReport counts what BackfillImageMeta did.
+type BackfillReport struct {
+	Updated      int
+	MissingFiles []GCMissingImage
+}
+
+// BackfillImageMeta records the metadata of run images stored before it was
+// captured. Rows whose file is gone are reported and left alone.
+func (s *Store) BackfillImageMeta() (BackfillReport, error) {
+	var report BackfillReport
+	rows, err := s.db.Query(`SELECT id, rel_path FROM run_images WHERE sha256 = '' ORDER BY id;`)
+	if err != nil {
+		return report, err
+	}
+	pending, err := collectRows(rows, func(sc rowScanner) (GCMissingImage, error) {
+		var m GCMissingImage
+		err := sc.Scan(&m.ImageID, &m.RelPath)
+		return m, err
+	})
+	if err != nil {
+		return report, err
+	}
+	for _, img := range pending {
+		meta, err := readImageMeta(filepath.Join(s.Root, img.RelPath))
+		if errors.Is(err, os.ErrNotExist) {
+			report.MissingFiles = append(report.MissingFiles, img)
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		_, err = s.db.Exec(`
+			UPDATE run_images SET width = ?, height = ?, size_bytes = ?, sha256 = ?, mime_type = ?
+			WHERE id = ?;
+		`, meta.Width, meta.Height, meta.SizeBytes, meta.SHA256, meta.MIMEType, img.ImageID)
+		if err != nil {
+			return report, err
+		}
+		report.Updated++
+	}
+	return report, nil
+}
@@ -0,0 +1,716 @@
+package webapp
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// migration is one numbered schema step. Versions must be unique and
+// ascending; Down must undo exactly what Up did.
+type migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS brands (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				slug TEXT NOT NULL UNIQUE,
+				content TEXT NOT NULL,
+				created_at TEXT NOT NULL,
+				updated_at TEXT NOT NULL
+			);`,
+			`CREATE TABLE IF NOT EXISTS projects (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL,
+				slug TEXT NOT NULL UNIQUE,
+				default_brand_id INTEGER NULL,
+				created_at TEXT NOT NULL,
+				updated_at TEXT NOT NULL,
+				FOREIGN KEY(default_brand_id) REFERENCES brands(id) ON DELETE SET NULL
+			);`,
+			`CREATE TABLE IF NOT EXISTS work_items (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				project_id INTEGER NOT NULL,
+				name TEXT NOT NULL,
+				slug TEXT NOT NULL,
+				type TEXT NOT NULL,
+				prompt TEXT NOT NULL,
+				brand_id INTEGER NULL,
+				created_at TEXT NOT NULL,
+				updated_at TEXT NOT NULL,
+				UNIQUE(project_id, slug),
+				FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE,
+				FOREIGN KEY(brand_id) REFERENCES brands(id) ON DELETE SET NULL
+			);`,
+			`CREATE TABLE IF NOT EXISTS jobs (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				work_item_id INTEGER NOT NULL,
+				run_id INTEGER NULL,
+				status TEXT NOT NULL,
+				payload_json TEXT NOT NULL,
+				error_message TEXT NULL,
+				created_at TEXT NOT NULL,
+				started_at TEXT NULL,
+				finished_at TEXT NULL,
+				FOREIGN KEY(work_item_id) REFERENCES work_items(id) ON DELETE CASCADE
+			);`,
+			`CREATE TABLE IF NOT EXISTS runs (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				job_id INTEGER NOT NULL UNIQUE,
+				work_item_id INTEGER NOT NULL,
+				prompt_snapshot TEXT NOT NULL,
+				settings_json TEXT NOT NULL,
+				status TEXT NOT NULL,
+				error_message TEXT NULL,
+				created_at TEXT NOT NULL,
+				finished_at TEXT NULL,
+				FOREIGN KEY(job_id) REFERENCES jobs(id) ON DELETE CASCADE,
+				FOREIGN KEY(work_item_id) REFERENCES work_items(id) ON DELETE CASCADE
+			);`,
+			`CREATE TABLE IF NOT EXISTS run_images (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				run_id INTEGER NOT NULL,
+				filename TEXT NOT NULL,
+				rel_path TEXT NOT NULL,
+				format TEXT NOT NULL,
+				created_at TEXT NOT NULL,
+				FOREIGN KEY(run_id) REFERENCES runs(id) ON DELETE CASCADE
+			);`,
+			`CREATE INDEX IF NOT EXISTS idx_jobs_status_created ON jobs(status, created_at);`,
+			`CREATE INDEX IF NOT EXISTS idx_jobs_work_item_created ON jobs(work_item_id, created_at DESC);`,
+			`CREATE INDEX IF NOT EXISTS idx_runs_work_item_created ON runs(work_item_id, created_at DESC);`,
+			`CREATE INDEX IF NOT EXISTS idx_run_images_run_created ON run_images(run_id, created_at);`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS run_images;`,
+			`DROP TABLE IF EXISTS runs;`,
+			`DROP TABLE IF EXISTS jobs;`,
+			`DROP TABLE IF EXISTS work_items;`,
+			`DROP TABLE IF EXISTS projects;`,
+			`DROP TABLE IF EXISTS brands;`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "brand_versions",
+		Up: []string{
+			`CREATE TABLE brand_versions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				brand_id INTEGER NOT NULL,
+				version INTEGER NOT NULL,
+				content TEXT NOT NULL,
+				author TEXT NOT NULL DEFAULT '',
+				note TEXT NOT NULL DEFAULT '',
+				created_at TEXT NOT NULL,
+				UNIQUE(brand_id, version),
+				FOREIGN KEY(brand_id) REFERENCES brands(id) ON DELETE CASCADE
+			);`,
+			`INSERT INTO brand_versions (brand_id, version, content, author, note, created_at)
+			 SELECT id, 1, content, '', 'imported', updated_at FROM brands;`,
+			`ALTER TABLE runs ADD COLUMN brand_version_id INTEGER NULL;`,
+		},
+		Down: []string{
+			`ALTER TABLE runs DROP COLUMN brand_version_id;`,
+			`DROP TABLE brand_versions;`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "prompt_revisions",
+		Up: []string{
+			`CREATE TABLE prompt_revisions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				work_item_id INTEGER NOT NULL,
+				revision INTEGER NOT NULL,
+				prompt TEXT NOT NULL,
+				note TEXT NOT NULL DEFAULT '',
+				created_at TEXT NOT NULL,
+				UNIQUE(work_item_id, revision),
+				FOREIGN KEY(work_item_id) REFERENCES work_items(id) ON DELETE CASCADE
+			);`,
+			`INSERT INTO prompt_revisions (work_item_id, revision, prompt, note, created_at)
+			 SELECT id, 1, prompt, 'imported', updated_at FROM work_items;`,
+			`ALTER TABLE runs ADD COLUMN prompt_revision_id INTEGER NULL;`,
+		},
+		Down: []string{
+			`ALTER TABLE runs DROP COLUMN prompt_revision_id;`,
+			`DROP TABLE prompt_revisions;`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "archived_at",
+		Up: []string{
+			`ALTER TABLE brands ADD COLUMN archived_at TEXT NULL;`,
+			`ALTER TABLE projects ADD COLUMN archived_at TEXT NULL;`,
+			`ALTER TABLE work_items ADD COLUMN archived_at TEXT NULL;`,
+		},
+		Down: []string{
+			`ALTER TABLE work_items DROP COLUMN archived_at;`,
+			`ALTER TABLE projects DROP COLUMN archived_at;`,
+			`ALTER TABLE brands DROP COLUMN archived_at;`,
+		},
+	},
+	{
+		// The rowid of a search_index row is ref_id*8 + a per-kind code, so
+		// triggers can replace or delete a document without a scan.
+		Version: 5,
+		Name:    "search_index",
+		Up: []string{
+			`CREATE VIRTUAL TABLE search_index USING fts5(
+				kind UNINDEXED,
+				ref_id UNINDEXED,
+				title,
+				body,
+				tokenize = 'porter unicode61'
+			);`,
+			`CREATE TRIGGER search_brands_ai AFTER INSERT ON brands BEGIN
+				INSERT INTO search_index (rowid, kind, ref_id, title, body)
+				VALUES (NEW.id * 8 + 1, 'brand', NEW.id, NEW.name, NEW.content);
+			END;`,
+			`CREATE TRIGGER search_brands_au AFTER UPDATE OF name, content ON brands BEGIN
+				DELETE FROM search_index WHERE rowid = OLD.id * 8 + 1;
+				INSERT INTO search_index (rowid, kind, ref_id, title, body)
+				VALUES (NEW.id * 8 + 1, 'brand', NEW.id, NEW.name, NEW.content);
+			END;`,
+			`CREATE TRIGGER search_brands_ad AFTER DELETE ON brands BEGIN
+				DELETE FROM search_index WHERE rowid = OLD.id * 8 + 1;
+			END;`,
+			`CREATE TRIGGER search_work_items_ai AFTER INSERT ON work_items BEGIN
+				INSERT INTO search_index (rowid, kind, ref_id, title, body)
+				VALUES (NEW.id * 8 + 2, 'work_item', NEW.id, NEW.name, NEW.prompt);
+			END;`,
+			`CREATE TRIGGER search_work_items_au AFTER UPDATE OF name, prompt ON work_items BEGIN
+				DELETE FROM search_index WHERE rowid = OLD.id * 8 + 2;
+				INSERT INTO search_index (rowid, kind, ref_id, title, body)
+				VALUES (NEW.id * 8 + 2, 'work_item', NEW.id, NEW.name, NEW.prompt);
+			END;`,
+			`CREATE TRIGGER search_work_items_ad AFTER DELETE ON work_items BEGIN
+				DELETE FROM search_index WHERE rowid = OLD.id * 8 + 2;
+			END;`,
+			`CREATE TRIGGER search_runs_ai AFTER INSERT ON runs BEGIN
+				INSERT INTO search_index (rowid, kind, ref_id, title, body)
+				VALUES (NEW.id * 8 + 3, 'run', NEW.id,
+					(SELECT name FROM work_items WHERE id = NEW.work_item_id), NEW.prompt_snapshot);
+			END;`,
+			`CREATE TRIGGER search_runs_ad AFTER DELETE ON runs BEGIN
+				DELETE FROM search_index WHERE rowid = OLD.id * 8 + 3;
+			END;`,
+			`CREATE TRIGGER search_jobs_ai AFTER INSERT ON jobs
+			WHEN COALESCE(json_extract(NEW.payload_json, '$.adjustment'), '') != '' BEGIN
+				INSERT INTO search_index (rowid, kind, ref_id, title, body)
+				VALUES (NEW.id * 8 + 4, 'job', NEW.id,
+					(SELECT name FROM work_items WHERE id = NEW.work_item_id), json_extract(NEW.payload_json, '$.adjustment'));
+			END;`,
+			`CREATE TRIGGER search_jobs_ad AFTER DELETE ON jobs BEGIN
+				DELETE FROM search_index WHERE rowid = OLD.id * 8 + 4;
+			END;`,
+			`INSERT INTO search_index (rowid, kind, ref_id, title, body)
+			 SELECT id * 8 + 1, 'brand', id, name, content FROM brands;`,
+			`INSERT INTO search_index (rowid, kind, ref_id, title, body)
+			 SELECT id * 8 + 2, 'work_item', id, name, prompt FROM work_items;`,
+			`INSERT INTO search_index (rowid, kind, ref_id, title, body)
+			 SELECT r.id * 8 + 3, 'run', r.id, w.name, r.prompt_snapshot
+			 FROM runs r JOIN work_items w ON w.id = r.work_item_id;`,
+			`INSERT INTO search_index (rowid, kind, ref_id, title, body)
+			 SELECT j.id * 8 + 4, 'job', j.id, w.name, json_extract(j.payload_json, '$.adjustment')
+			 FROM jobs j JOIN work_items w ON w.id = j.work_item_id
+			 WHERE COALESCE(json_extract(j.payload_json, '$.adjustment'), '') != '';`,
+		},
+		Down: []string{
+			`DROP TRIGGER search_jobs_ad;`,
+			`DROP TRIGGER search_jobs_ai;`,
+			`DROP TRIGGER search_runs_ad;`,
+			`DROP TRIGGER search_runs_ai;`,
+			`DROP TRIGGER search_work_items_ad;`,
+			`DROP TRIGGER search_work_items_au;`,
+			`DROP TRIGGER search_work_items_ai;`,
+			`DROP TRIGGER search_brands_ad;`,
+			`DROP TRIGGER search_brands_au;`,
+			`DROP TRIGGER search_brands_ai;`,
+			`DROP TABLE search_index;`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "listing_keyset_indexes",
+		Up: []string{
+			`CREATE INDEX idx_jobs_created_id ON jobs(created_at, id);`,
+			`CREATE INDEX idx_run_images_created_id ON run_images(created_at, id);`,
+		},
+		Down: []string{
+			`DROP INDEX idx_run_images_created_id;`,
+			`DROP INDEX idx_jobs_created_id;`,
+		},
+	},
+	{
+		// Tags are indexed for search with the document they label: a work
+		// item's tags join its name in the title column, and tagged images
+		// get their own documents (kind code 5).
+		Version: 7,
+		Name:    "tags",
+		Up: []string{
+			`CREATE TABLE tags (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL UNIQUE,
+				created_at TEXT NOT NULL
+			);`,
+			`CREATE TABLE work_item_tags (
+				work_item_id INTEGER NOT NULL,
+				tag_id INTEGER NOT NULL,
+				created_at TEXT NOT NULL,
+				PRIMARY KEY(work_item_id, tag_id),
+				FOREIGN KEY(work_item_id) REFERENCES work_items(id) ON DELETE CASCADE,
+				FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE
+			);`,
+			`CREATE TABLE image_tags (
+				image_id INTEGER NOT NULL,
+				tag_id INTEGER NOT NULL,
+				created_at TEXT NOT NULL,
+				PRIMARY KEY(image_id, tag_id),
+				FOREIGN KEY(image_id) REFERENCES run_images(id) ON DELETE CASCADE,
+				FOREIGN KEY(tag_id) REFERENCES tags(id) ON DELETE CASCADE
+			);`,
+			`CREATE INDEX idx_work_item_tags_tag ON work_item_tags(tag_id);`,
+			`CREATE INDEX idx_image_tags_tag ON image_tags(tag_id);`,
+			`DROP TRIGGER search_work_items_au;`,
+			`CREATE TRIGGER search_work_items_au AFTER UPDATE OF name, prompt ON work_items BEGIN
+				DELETE FROM search_index WHERE rowid = OLD.id * 8 + 2;
+				INSERT INTO search_index (rowid, kind, ref_id, title, body)
+				SELECT NEW.id * 8 + 2, 'work_item', NEW.id,
+					NEW.name || COALESCE(' ' || group_concat(t.name, ' '), ''), NEW.prompt
+				FROM (SELECT 1) LEFT JOIN work_item_tags wt ON wt.work_item_id = NEW.id
+				LEFT JOIN tags t ON t.id = wt.tag_id;
+			END;`,
+			`CREATE TRIGGER search_work_item_tags_ai AFTER INSERT ON work_item_tags BEGIN
+				UPDATE work_items SET name = name WHERE id = NEW.work_item_id;
+			END;`,
+			`CREATE TRIGGER search_work_item_tags_ad AFTER DELETE ON work_item_tags BEGIN
+				UPDATE work_items SET name = name WHERE id = OLD.work_item_id;
+			END;`,
+			`CREATE TRIGGER search_image_tags_ai AFTER INSERT ON image_tags BEGIN
+				DELETE FROM search_index WHERE rowid = NEW.image_id * 8 + 5;
+				INSERT INTO search_index (rowid, kind, ref_id, title, body)
+				SELECT NEW.image_id * 8 + 5, 'image', NEW.image_id, ri.filename, group_concat(t.name, ' ')
+				FROM run_images ri
+				JOIN image_tags it ON it.image_id = ri.id
+				JOIN tags t ON t.id = it.tag_id
+				WHERE ri.id = NEW.image_id
+				GROUP BY ri.id;
+			END;`,
+			`CREATE TRIGGER search_image_tags_ad AFTER DELETE ON image_tags BEGIN
+				DELETE FROM search_index WHERE rowid = OLD.image_id * 8 + 5;
+				INSERT INTO search_index (rowid, kind, ref_id, title, body)
+				SELECT OLD.image_id * 8 + 5, 'image', OLD.image_id, ri.filename, group_concat(t.name, ' ')
+				FROM run_images ri
+				JOIN image_tags it ON it.image_id = ri.id
+				JOIN tags t ON t.id = it.tag_id
+				WHERE ri.id = OLD.image_id
+				GROUP BY ri.id;
+			END;`,
+		},
+		Down: []string{
+			`DROP TRIGGER search_image_tags_ad;`,
+			`DROP TRIGGER search_image_tags_ai;`,
+			`DROP TRIGGER search_work_item_tags_ad;`,
+			`DROP TRIGGER search_work_item_tags_ai;`,
+			`DELETE FROM search_index WHERE kind = 'image';`,
+			`DROP TRIGGER search_work_items_au;`,
+			`CREATE TRIGGER search_work_items_au AFTER UPDATE OF name, prompt ON work_items BEGIN
+				DELETE FROM search_index WHERE rowid = OLD.id * 8 + 2;
+				INSERT INTO search_index (rowid, kind, ref_id, title, body)
+				VALUES (NEW.id * 8 + 2, 'work_item', NEW.id, NEW.name, NEW.prompt);
+			END;`,
+			`UPDATE work_items SET name = name WHERE id IN (SELECT work_item_id FROM work_item_tags);`,
+			`DROP TABLE image_tags;`,
+			`DROP TABLE work_item_tags;`,
+			`DROP TABLE tags;`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "image_ratings",
+		Up: []string{
+			`ALTER TABLE run_images ADD COLUMN rating INTEGER NULL CHECK (rating BETWEEN 1 AND 5);`,
+			`ALTER TABLE run_images ADD COLUMN rating_note TEXT NOT NULL DEFAULT '';`,
+			`ALTER TABLE run_images ADD COLUMN rated_at TEXT NULL;`,
+			`CREATE INDEX idx_run_images_rating ON run_images(rating, created_at, id);`,
+		},
+		Down: []string{
+			`DROP INDEX idx_run_images_rating;`,
+			`ALTER TABLE run_images DROP COLUMN rated_at;`,
+			`ALTER TABLE run_images DROP COLUMN rating_note;`,
+			`ALTER TABLE run_images DROP COLUMN rating;`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "comparisons",
+		Up: []string{
+			`CREATE TABLE comparisons (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				work_item_id INTEGER NOT NULL,
+				winner_image_id INTEGER NOT NULL,
+				loser_image_id INTEGER NOT NULL,
+				created_at TEXT NOT NULL,
+				FOREIGN KEY(work_item_id) REFERENCES work_items(id) ON DELETE CASCADE,
+				FOREIGN KEY(winner_image_id) REFERENCES run_images(id) ON DELETE CASCADE,
+				FOREIGN KEY(loser_image_id) REFERENCES run_images(id) ON DELETE CASCADE
+			);`,
+			`CREATE INDEX idx_comparisons_work_item ON comparisons(work_item_id, created_at);`,
+			`CREATE INDEX idx_comparisons_winner ON comparisons(winner_image_id);`,
+			`CREATE INDEX idx_comparisons_loser ON comparisons(loser_image_id);`,
+			`ALTER TABLE run_images ADD COLUMN elo REAL NOT NULL DEFAULT 1500;`,
+		},
+		Down: []string{
+			`ALTER TABLE run_images DROP COLUMN elo;`,
+			`DROP TABLE comparisons;`,
+		},
+	},
+	{
+		Version: 10,
+		Name:    "work_item_finals",
+		Up: []string{
+			`CREATE TABLE work_item_finals (
+				work_item_id INTEGER PRIMARY KEY,
+				image_id INTEGER NULL,
+				filename TEXT NOT NULL,
+				rel_path TEXT NOT NULL,
+				finalized_by TEXT NOT NULL DEFAULT '',
+				finalized_at TEXT NOT NULL,
+				FOREIGN KEY(work_item_id) REFERENCES work_items(id) ON DELETE CASCADE,
+				FOREIGN KEY(image_id) REFERENCES run_images(id) ON DELETE SET NULL
+			);`,
+			`CREATE INDEX idx_work_item_finals_image ON work_item_finals(image_id);`,
+		},
+		Down: []string{
+			`DROP TABLE work_item_finals;`,
+		},
+	},
+	{
+		Version: 11,
+		Name:    "work_item_status",
+		Up: []string{
+			`ALTER TABLE work_items ADD COLUMN status TEXT NOT NULL DEFAULT 'draft'
+				CHECK (status IN ('draft', 'generating', 'in_review', 'approved', 'delivered'));`,
+			`UPDATE work_items SET status = CASE
+				WHEN EXISTS (SELECT 1 FROM work_item_finals f WHERE f.work_item_id = work_items.id) THEN 'approved'
+				WHEN EXISTS (SELECT 1 FROM jobs j WHERE j.work_item_id = work_items.id AND j.status IN ('queued', 'running')) THEN 'generating'
+				WHEN EXISTS (SELECT 1 FROM runs r JOIN run_images ri ON ri.run_id = r.id WHERE r.work_item_id = work_items.id) THEN 'in_review'
+				ELSE 'draft'
+			END;`,
+			`CREATE INDEX idx_work_items_status ON work_items(project_id, status);`,
+			`CREATE TABLE work_item_status_changes (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				work_item_id INTEGER NOT NULL,
+				from_status TEXT NOT NULL,
+				to_status TEXT NOT NULL,
+				note TEXT NOT NULL DEFAULT '',
+				created_at TEXT NOT NULL,
+				FOREIGN KEY(work_item_id) REFERENCES work_items(id) ON DELETE CASCADE
+			);`,
+			`CREATE INDEX idx_work_item_status_changes_item ON work_item_status_changes(work_item_id, created_at);`,
+		},
+		Down: []string{
+			`DROP TABLE work_item_status_changes;`,
+			`DROP INDEX idx_work_items_status;`,
+			`ALTER TABLE work_items DROP COLUMN status;`,
+		},
+	},
+	{
+		Version: 12,
+		Name:    "work_item_constraints",
+		Up: []string{
+			`ALTER TABLE work_items ADD COLUMN constraints_json TEXT NOT NULL DEFAULT '{}';`,
+		},
+		Down: []string{
+			`ALTER TABLE work_items DROP COLUMN constraints_json;`,
+		},
+	},
+	{
+		Version: 13,
+		Name:    "brand_assets",
+		Up: []string{
+			`CREATE TABLE brand_assets (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				brand_id INTEGER NOT NULL,
+				kind TEXT NOT NULL CHECK (kind IN ('logo', 'reference')),
+				filename TEXT NOT NULL,
+				rel_path TEXT NOT NULL,
+				mime_type TEXT NOT NULL,
+				width INTEGER NOT NULL,
+				height INTEGER NOT NULL,
+				size_bytes INTEGER NOT NULL,
+				sha256 TEXT NOT NULL,
+				created_at TEXT NOT NULL,
+				UNIQUE(brand_id, sha256),
+				FOREIGN KEY(brand_id) REFERENCES brands(id) ON DELETE CASCADE
+			);`,
+		},
+		Down: []string{
+			`DROP TABLE brand_assets;`,
+		},
+	},
+	{
+		Version: 14,
+		Name:    "run_image_metadata",
+		Up: []string{
+			`ALTER TABLE run_images ADD COLUMN width INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE run_images ADD COLUMN height INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE run_images ADD COLUMN size_bytes INTEGER NOT NULL DEFAULT 0;`,
+			`ALTER TABLE run_images ADD COLUMN sha256 TEXT NOT NULL DEFAULT '';`,
+			`ALTER TABLE run_images ADD COLUMN mime_type TEXT NOT NULL DEFAULT '';`,
+		},
+		Down: []string{
+			`ALTER TABLE run_images DROP COLUMN mime_type;`,
+			`ALTER TABLE run_images DROP COLUMN sha256;`,
+			`ALTER TABLE run_images DROP COLUMN size_bytes;`,
+			`ALTER TABLE run_images DROP COLUMN height;`,
+			`ALTER TABLE run_images DROP COLUMN width;`,
+		},
+	},
+	{
+		Version: 15,
+		Name:    "slug_aliases",
+		Up: []string{
+			`CREATE TABLE project_slug_aliases (
+				slug TEXT PRIMARY KEY,
+				project_id INTEGER NOT NULL,
+				created_at TEXT NOT NULL,
+				FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE
+			);`,
+			`CREATE INDEX idx_project_slug_aliases_project ON project_slug_aliases(project_id);`,
+			`CREATE TABLE work_item_slug_aliases (
+				project_id INTEGER NOT NULL,
+				slug TEXT NOT NULL,
+				work_item_id INTEGER NOT NULL,
+				created_at TEXT NOT NULL,
+				PRIMARY KEY(project_id, slug),
+				FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE,
+				FOREIGN KEY(work_item_id) REFERENCES work_items(id) ON DELETE CASCADE
+			);`,
+			`CREATE INDEX idx_work_item_slug_aliases_item ON work_item_slug_aliases(work_item_id);`,
+		},
+		Down: []string{
+			`DROP TABLE work_item_slug_aliases;`,
+			`DROP TABLE project_slug_aliases;`,
+		},
+	},
+	{
+		Version: 16,
+		Name:    "run_image_model",
+		Up: []string{
+			`ALTER TABLE run_images ADD COLUMN model TEXT NOT NULL DEFAULT '';`,
+			// Existing images get their job's model, or for "both" jobs the
+			// model named in the filename, as imageModel does.
+			`UPDATE run_images SET model = COALESCE((
+				SELECT CASE
+					WHEN COALESCE(json_extract(j.payload_json, '$.model'), 'both') NOT IN ('', 'both') THEN json_extract(j.payload_json, '$.model')
+					WHEN instr(lower(run_images.filename), 'openai') OR instr(lower(run_images.filename), 'gpt') THEN 'openai'
+					WHEN instr(lower(run_images.filename), 'google') OR instr(lower(run_images.filename), 'gemini') THEN 'google'
+					ELSE ''
+				END
+				FROM runs r
+				JOIN jobs j ON j.id = r.job_id
+				WHERE r.id = run_images.run_id
+			), '');`,
+		},
+		Down: []string{
+			`ALTER TABLE run_images DROP COLUMN model;`,
+		},
+	},
+}
+
+func (s *Store) ensureMigrationsTable() error {
+	_, err := s.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		);
+	`)
+	return err
+}
+
+func (s *Store) appliedMigrations() (map[int]time.Time, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`SELECT version, applied_at FROM schema_migrations;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = parseTime(appliedAt)
+	}
+	return applied, rows.Err()
+}
+
+func (s *Store) MigrationStatus() ([]MigrationState, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		state := MigrationState{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			state.AppliedAt = &at
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func (s *Store) SchemaVersion() (int, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+	current := 0
+	for version := range applied {
+		current = max(current, version)
+	}
+	return current, nil
+}
+
+// MigrateUp applies pending migrations up to and including target (0 means
+// all). The database file is backed up first whenever something is pending.
+// It returns the versions applied.
+func (s *Store) MigrateUp(target int) ([]int, error) {
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	pending := []migration{}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if target > 0 && m.Version > target {
+			break
+		}
+		pending = append(pending, m)
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	if _, err := s.backupBeforeMigrate(); err != nil {
+		return nil, fmt.Errorf("pre-migration backup failed: %w", err)
+	}
+
+	done := []int{}
+	for _, m := range pending {
+		err := s.withTx(func(tx *sql.Tx) error {
+			for _, stmt := range m.Up {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?);`, m.Version, m.Name, nowText())
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
+
+// MigrateDown rolls back the most recent steps applied migrations after
+// backing up the database file. It returns the versions rolled back.
+func (s *Store) MigrateDown(steps int) ([]int, error) {
+	if steps < 1 {
+		return nil, errors.New("steps must be >= 1")
+	}
+	applied, err := s.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	rollback := []migration{}
+	for i := len(migrations) - 1; i >= 0 && len(rollback) < steps; i-- {
+		if _, ok := applied[migrations[i].Version]; ok {
+			rollback = append(rollback, migrations[i])
+		}
+	}
+	if len(rollback) == 0 {
+		return nil, nil
+	}
+	if _, err := s.backupBeforeMigrate(); err != nil {
+		return nil, fmt.Errorf("pre-migration backup failed: %w", err)
+	}
+
+	done := []int{}
+	for _, m := range rollback {
+		err := s.withTx(func(tx *sql.Tx) error {
+			for _, stmt := range m.Down {
+				if _, err := tx.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?;`, m.Version)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("rollback of migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
+
+// backupBeforeMigrate snapshots the database into <root>/backups with
+// VACUUM INTO, which is consistent even while other connections are open.
+// Databases without any user tables are not backed up.
+func (s *Store) backupBeforeMigrate() (string, error) {
+	var tables int
+	if err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'schema_migrations';
+	`).Scan(&tables); err != nil {
+		return "", err
+	}
+	if tables == 0 {
+		return "", nil
+	}
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(s.Root, "backups")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	stamp := time.Now().UTC().Format("20060102T150405.000Z")
+	path := filepath.Join(dir, fmt.Sprintf("imagegen-%s-v%d.db", stamp, version))
+	if _, err := s.db.Exec(`VACUUM INTO ?;`, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,211 @@
+package webapp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// seededV1Store returns a store at schema version 1 holding a brand, a
+// project, a work item and two finished jobs: one for openai and one for both
+// models, whose images name their model only in the filename.
+func seededV1Store(t *testing.T) *Store {
+	t.Helper()
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	if _, err := store.MigrateUp(1); err != nil {
+		t.Fatal(err)
+	}
+	const at = "2024-05-06T07:08:09.000Z"
+	for _, stmt := range []string{
+		`INSERT INTO brands (id, name, slug, content, created_at, updated_at) VALUES (1, 'Acme', 'acme', 'Bold.', '` + at + `', '` + at + `');`,
+		`INSERT INTO projects (id, name, slug, default_brand_id, created_at, updated_at) VALUES (1, 'Launch', 'launch', 1, '` + at + `', '` + at + `');`,
+		`INSERT INTO work_items (id, project_id, name, slug, type, prompt, created_at, updated_at) VALUES (1, 1, 'Hero', 'hero', 'generic', 'a red kite', '` + at + `', '` + at + `');`,
+		`INSERT INTO jobs (id, work_item_id, status, payload_json, created_at) VALUES
+			(1, 1, 'succeeded', '{"model":"openai"}', '` + at + `'),
+			(2, 1, 'succeeded', '{"model":"both"}', '` + at + `');`,
+		`INSERT INTO runs (id, job_id, work_item_id, prompt_snapshot, settings_json, status, created_at) VALUES
+			(1, 1, 1, 'a red kite', '{}', 'succeeded', '` + at + `'),
+			(2, 2, 1, 'a red kite', '{}', 'succeeded', '` + at + `');`,
+		`INSERT INTO run_images (id, run_id, filename, rel_path, format, created_at) VALUES
+			(1, 1, 'image.png', 'images/launch/hero/1/image.png', 'png', '` + at + `'),
+			(2, 2, 'Gemini-1.png', 'images/launch/hero/2/Gemini-1.png', 'png', '` + at + `'),
+			(3, 2, 'gpt-image-1.png', 'images/launch/hero/2/gpt-image-1.png', 'png', '` + at + `'),
+			(4, 2, 'other.png', 'images/launch/hero/2/other.png', 'png', '` + at + `');`,
+	} {
+		if _, err := store.db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+// schemaSQL returns the schema objects of the database, sorted, for
+// comparing one migration path with another.
+func schemaSQL(t *testing.T, store *Store) []string {
+	t.Helper()
+	rows, err := store.db.Query(`SELECT type || ' ' || name || ': ' || COALESCE(sql, '') FROM sqlite_master WHERE name NOT LIKE 'sqlite_%';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := collectRows(rows, func(sc rowScanner) (string, error) {
+		var s string
+		err := sc.Scan(&s)
+		return s, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(schema)
+	return schema
+}
+
+func TestMigrationsBackfillSeededData(t *testing.T) {
+	store := seededV1Store(t)
+	done, err := store.MigrateUp(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := len(migrations) - 1; len(done) != want {
+		t.Fatalf("applied %v, want %d migrations", done, want)
+	}
+
+	brand, err := store.GetBrand("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brand.Version != 1 {
+		t.Fatalf("brand version = %d, want 1", brand.Version)
+	}
+	v, err := store.GetBrandVersion("acme", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Content != "Bold." || v.Note != "imported" || !v.CreatedAt.Equal(brand.UpdatedAt) {
+		t.Fatalf("backfilled brand version = %+v", v)
+	}
+
+	item, err := store.GetWorkItem("launch", "hero")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.PromptRevision != 1 {
+		t.Fatalf("prompt revision = %d, want 1", item.PromptRevision)
+	}
+	rev, err := store.GetPromptRevision("launch", "hero", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rev.Prompt != "a red kite" || rev.Note != "imported" {
+		t.Fatalf("backfilled prompt revision = %+v", rev)
+	}
+
+	checkModels := func() {
+		t.Helper()
+		for id, want := range map[int64]string{1: "openai", 2: "google", 3: "openai", 4: ""} {
+			var model string
+			if err := store.db.QueryRow(`SELECT model FROM run_images WHERE id = ?;`, id).Scan(&model); err != nil {
+				t.Fatal(err)
+			}
+			if model != want {
+				t.Errorf("image %d model = %q, want %q", id, model, want)
+			}
+		}
+	}
+	checkModels()
+	// Rolling back the last migration and applying it again backfills the
+	// models the same way.
+	if _, err := store.MigrateDown(1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+	checkModels()
+}
+
+func TestMigrateDownAndUpAgain(t *testing.T) {
+	store := seededV1Store(t)
+	if _, err := store.MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+	schema := schemaSQL(t, store)
+
+	done, err := store.MigrateDown(len(migrations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != len(migrations) || done[0] != migrations[len(migrations)-1].Version || done[len(done)-1] != 1 {
+		t.Fatalf("rolled back %v", done)
+	}
+	if version, _ := store.SchemaVersion(); version != 0 {
+		t.Fatalf("schema version after rollback = %d", version)
+	}
+	if left := schemaSQL(t, store); len(left) != 1 || !strings.HasPrefix(left[0], "table schema_migrations:") {
+		t.Fatalf("objects left after rollback: %v", left)
+	}
+
+	if _, err := store.MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+	if again := schemaSQL(t, store); !slices.Equal(again, schema) {
+		t.Fatalf("schema after down and up differs:\n%s\nwant\n%s", strings.Join(again, "\n"), strings.Join(schema, "\n"))
+	}
+	if _, err := store.CreateBrand("Acme", "Bold."); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupBeforeMigrate(t *testing.T) {
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	path, err := store.backupBeforeMigrate()
+	if err != nil || path != "" {
+		t.Fatalf("backup of empty database = %q, %v; want none", path, err)
+	}
+	if _, err := store.MigrateUp(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(store.Root, "backups")); !os.IsNotExist(err) {
+		t.Fatalf("migrating a new database made a backup: %v", err)
+	}
+	if _, err := store.CreateBrand("Acme", "Bold."); err != nil {
+		t.Fatal(err)
+	}
+
+	latest := migrations[len(migrations)-1].Version
+	path, err = store.backupBeforeMigrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir, name := filepath.Split(path); filepath.Clean(dir) != filepath.Join(store.Root, "backups") ||
+		!strings.HasPrefix(name, "imagegen-") || !strings.HasSuffix(name, fmt.Sprintf("-v%d.db", latest)) {
+		t.Fatalf("backup path = %q", path)
+	}
+	db, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var slug string
+	if err := db.QueryRow(`SELECT slug FROM brands;`).Scan(&slug); err != nil || slug != "acme" {
+		t.Fatalf("backup brand = %q, %v", slug, err)
+	}
+
+	if _, err := store.MigrateDown(1); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := filepath.Glob(filepath.Join(store.Root, "backups", fmt.Sprintf("imagegen-*-v%d.db", latest)))
+	if err != nil || len(backups) != 2 {
+		t.Fatalf("backups after rollback = %v, %v; want 2", backups, err)
+	}
+}
@@ -0,0 +1,116 @@
+package webapp
+
+import (
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Listings are ordered by (created_at, id) descending and paged by keyset:
+// a cursor holds the sort key of the row a page ended (or started) at, so
+// rows inserted meanwhile never shift later pages. Listings sorted by rating
+// put the rating in front of that key.
+type pageCursor struct {
+	// Newer is set for cursors that page towards newer rows.
+	Newer     bool
+	CreatedAt string
+	ID        int64
+	// Ranked is set for cursors of rating-sorted listings; Rating is then
+	// the row's rating, 0 when unrated.
+	Ranked bool
+	Rating int
+}
+
+var errBadCursor = errors.New("invalid cursor")
+
+func encodeCursor(c pageCursor) string {
+	dir := "n"
+	if c.Newer {
+		dir = "p"
+	}
+	raw := dir + "|" + c.CreatedAt + "|" + strconv.FormatInt(c.ID, 10)
+	if c.Ranked {
+		raw += "|" + strconv.Itoa(c.Rating)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (*pageCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errBadCursor
+	}
+	parts := strings.Split(string(raw), "|")
+	if len(parts) < 3 || len(parts) > 4 || (parts[0] != "n" && parts[0] != "p") {
+		return nil, errBadCursor
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, errBadCursor
+	}
+	cur := &pageCursor{Newer: parts[0] == "p", CreatedAt: parts[1], ID: id}
+	if len(parts) == 4 {
+		cur.Ranked = true
+		if cur.Rating, err = strconv.Atoi(parts[3]); err != nil {
+			return nil, errBadCursor
+		}
+	}
+	return cur, nil
+}
+
+func pageLimit(limit int, fallback int) int {
+	if limit <= 0 {
+		return fallback
+	}
+	return min(limit, 500)
+}
+
+// filterTime formats a filter bound like the stored timestamps so the two
+// compare as text; zero means unbounded.
+func filterTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(timestampLayout)
+}
+
+// cursorAt is the sort key of a row in a newest-first listing.
+func cursorAt(createdAt time.Time, id int64) pageCursor {
+	return pageCursor{CreatedAt: createdAt.UTC().Format(timestampLayout), ID: id}
+}
+
+// finishPage takes up to limit+1 rows fetched in cursor order (oldest first
+// when paging towards newer rows), trims them to a newest-first page and
+// works out the cursors on either side of it.
+func finishPage[T any](rows []T, limit int, cur *pageCursor, key func(T) pageCursor) ([]T, string, string) {
+	more := len(rows) > limit
+	if more {
+		rows = rows[:limit]
+	}
+	newer := cur != nil && cur.Newer
+	if newer {
+		slices.Reverse(rows)
+	}
+	if len(rows) == 0 {
+		return rows, "", ""
+	}
+	edge := func(item T, towardsNewer bool) string {
+		c := key(item)
+		c.Newer = towardsNewer
+		return encodeCursor(c)
+	}
+	var next, prev string
+	if more || newer {
+		next = edge(rows[len(rows)-1], false)
+	}
+	if (more && newer) || (cur != nil && !newer) {
+		prev = edge(rows[0], true)
+	}
+	return rows, next, prev
+}
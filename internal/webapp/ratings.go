@@ -0,0 +1,88 @@
+package webapp
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// modelFileNames maps the names the generator writes into its filenames to
+// models.
+var modelFileNames = map[string]string{
+	"openai": "openai",
+	"gpt":    "openai",
+	"google": "google",
+	"gemini": "google",
+}
+
+// imageModel names the model that produced an output file of a job run for
+// jobModel. A single-model job produced all of its files; for "both" the
+// generator names each file after its model. It is recorded once, when the
+// image is stored.
+func imageModel(jobModel string, filename string) string {
+	if jobModel != "" && jobModel != "both" {
+		return jobModel
+	}
+	words := strings.FieldsFunc(strings.ToLower(filename), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if model, ok := modelFileNames[word]; ok {
+			return model
+		}
+	}
+	return ""
+}
+
+// RateImage sets an image's star rating and note. A rating of 0 clears it.
+func (s *Store) RateImage(imageID int64, rating int, note string) (WorkItemImage, error) {
+	if rating < 0 || rating > 5 {
+		return WorkItemImage{}, errors.New("rating must be between 1 and 5 (0 clears it)")
+	}
+	res, err := s.db.Exec(`
+		UPDATE run_images
+		SET rating = NULLIF(?, 0), rating_note = ?, rated_at = ?
+		WHERE id = ?;
+	`, rating, strings.TrimSpace(note), nowText(), imageID)
+	if err != nil {
+		return WorkItemImage{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return WorkItemImage{}, os.ErrNotExist
+	}
+	row := s.db.QueryRow(imageSelectSQL+`
+		WHERE ri.id = ?;
+	`, imageID)
+	img, err := scanImage(row)
+	if err != nil {
+		return WorkItemImage{}, notFound(err)
+	}
+	return img, nil
+}
+
+// ModelRatings averages the ratings of a project's images per model and work
+// item type, best first within each type.
+func (s *Store) ModelRatings(projectSlug string) ([]ModelRating, error) {
+	if _, err := s.GetProject(projectSlug); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`
+		SELECT ri.model, w.type, COUNT(*), COUNT(ri.rating), COALESCE(AVG(ri.rating), 0)
+		FROM run_images ri
+		JOIN runs r ON r.id = ri.run_id
+		JOIN work_items w ON w.id = r.work_item_id
+		JOIN projects p ON p.id = w.project_id
+		WHERE p.slug = ?
+		GROUP BY ri.model, w.type
+		ORDER BY w.type, 5 DESC, ri.model;
+	`, Slugify(projectSlug))
+	if err != nil {
+		return nil, err
+	}
+	return collectRows(rows, func(sc rowScanner) (ModelRating, error) {
+		var m ModelRating
+		err := sc.Scan(&m.Model, &m.ItemType, &m.Images, &m.Rated, &m.Average)
+		return m, err
+	})
+}
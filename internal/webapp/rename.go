@@ -0,0 +1,313 @@
+package webapp
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Renaming a project or work item changes its slug, which names its URL and
+// its directory under images/. The old slug is kept as an alias so old links
+// can be redirected; a live project or work item with that slug wins over an
+// alias.
+
+// RenameProject gives a project a new name and the slug derived from it,
+// moving its image directory along.
+func (s *Store) RenameProject(slug string, name string) (Project, error) {
+	slug = Slugify(slug)
+	name = strings.TrimSpace(name)
+	newSlug := Slugify(name)
+	if newSlug == "" {
+		return Project{}, errors.New("project name is required")
+	}
+	err := s.renameWithFiles(func(tx *sql.Tx) ([]fileMove, error) {
+		var id int64
+		if err := tx.QueryRow(`SELECT id FROM projects WHERE slug = ?;`, slug).Scan(&id); err != nil {
+			return nil, notFound(err)
+		}
+		if _, err := tx.Exec(`UPDATE projects SET name = ?, updated_at = ? WHERE id = ?;`, name, nowText(), id); err != nil {
+			return nil, err
+		}
+		if newSlug == slug {
+			return nil, nil
+		}
+		if err := checkNotRunning(tx, `w.project_id = ?`, id); err != nil {
+			return nil, err
+		}
+		var taken bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM projects WHERE slug = ?);`, newSlug).Scan(&taken); err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, fmt.Errorf("project %q already exists", newSlug)
+		}
+		if _, err := tx.Exec(`UPDATE projects SET slug = ? WHERE id = ?;`, newSlug, id); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO project_slug_aliases (slug, project_id, created_at)
+			VALUES (?, ?, ?)
+			ON CONFLICT(slug) DO UPDATE SET project_id = excluded.project_id, created_at = excluded.created_at;
+		`, slug, id, nowText()); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`DELETE FROM project_slug_aliases WHERE slug = ?;`, newSlug); err != nil {
+			return nil, err
+		}
+		from, to := filepath.Join("images", slug), filepath.Join("images", newSlug)
+		if err := rewriteRelPaths(tx, `w.project_id = ?`, id, from, to); err != nil {
+			return nil, err
+		}
+		return []fileMove{{from, to}}, nil
+	})
+	if err != nil {
+		return Project{}, err
+	}
+	return s.GetProject(newSlug)
+}
+
+// RenameWorkItem gives a work item a new name and the slug derived from it,
+// moving its image directory and final copy along.
+func (s *Store) RenameWorkItem(projectSlug string, itemSlug string, name string) (WorkItem, error) {
+	projectSlug, itemSlug = Slugify(projectSlug), Slugify(itemSlug)
+	name = strings.TrimSpace(name)
+	newSlug := Slugify(name)
+	if newSlug == "" {
+		return WorkItem{}, errors.New("work item name is required")
+	}
+	err := s.renameWithFiles(func(tx *sql.Tx) ([]fileMove, error) {
+		var id, projectID int64
+		err := tx.QueryRow(`
+			SELECT w.id, w.project_id
+			FROM work_items w
+			JOIN projects p ON p.id = w.project_id
+			WHERE p.slug = ? AND w.slug = ?;
+		`, projectSlug, itemSlug).Scan(&id, &projectID)
+		if err != nil {
+			return nil, notFound(err)
+		}
+		if _, err := tx.Exec(`UPDATE work_items SET name = ?, updated_at = ? WHERE id = ?;`, name, nowText(), id); err != nil {
+			return nil, err
+		}
+		if newSlug == itemSlug {
+			return nil, nil
+		}
+		if err := checkNotRunning(tx, `w.id = ?`, id); err != nil {
+			return nil, err
+		}
+		var taken bool
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM work_items WHERE project_id = ? AND slug = ?);`, projectID, newSlug).Scan(&taken)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			return nil, fmt.Errorf("work item %q already exists", newSlug)
+		}
+		if _, err := tx.Exec(`UPDATE work_items SET slug = ? WHERE id = ?;`, newSlug, id); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO work_item_slug_aliases (project_id, slug, work_item_id, created_at)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT(project_id, slug) DO UPDATE SET work_item_id = excluded.work_item_id, created_at = excluded.created_at;
+		`, projectID, itemSlug, id, nowText()); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`DELETE FROM work_item_slug_aliases WHERE project_id = ? AND slug = ?;`, projectID, newSlug); err != nil {
+			return nil, err
+		}
+		from, to := filepath.Join("images", projectSlug, itemSlug), filepath.Join("images", projectSlug, newSlug)
+		if err := rewriteRelPaths(tx, `w.id = ?`, id, from, to); err != nil {
+			return nil, err
+		}
+		moves := []fileMove{{from, to}}
+
+		// The final copy is named after the work item, so it is renamed
+		// inside the moved directory.
+		var final string
+		err = tx.QueryRow(`SELECT rel_path FROM work_item_finals WHERE work_item_id = ?;`, id).Scan(&final)
+		if errors.Is(err, sql.ErrNoRows) {
+			return moves, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		renamed := finalRelPath(projectSlug, newSlug, filepath.Ext(final))
+		if renamed != final {
+			if _, err := tx.Exec(`UPDATE work_item_finals SET rel_path = ? WHERE work_item_id = ?;`, renamed, id); err != nil {
+				return nil, err
+			}
+			moves = append(moves, fileMove{final, renamed})
+		}
+		return moves, nil
+	})
+	if err != nil {
+		return WorkItem{}, err
+	}
+	return s.GetWorkItem(projectSlug, newSlug)
+}
+
+// ResolveSlugAlias returns the current slugs of the project and, when
+// itemSlug is set, the work item named by possibly outdated slugs.
+func (s *Store) ResolveSlugAlias(projectSlug string, itemSlug string) (string, string, error) {
+	var projectID int64
+	var project string
+	err := s.db.QueryRow(`
+		SELECT id, slug FROM projects WHERE slug = ?
+		UNION ALL
+		SELECT p.id, p.slug FROM project_slug_aliases a JOIN projects p ON p.id = a.project_id WHERE a.slug = ?
+		LIMIT 1;
+	`, Slugify(projectSlug), Slugify(projectSlug)).Scan(&projectID, &project)
+	if err != nil {
+		return "", "", notFound(err)
+	}
+	if itemSlug == "" {
+		return project, "", nil
+	}
+	var item string
+	err = s.db.QueryRow(`
+		SELECT slug FROM work_items WHERE project_id = ? AND slug = ?
+		UNION ALL
+		SELECT w.slug FROM work_item_slug_aliases a JOIN work_items w ON w.id = a.work_item_id
+		WHERE a.project_id = ? AND a.slug = ?
+		LIMIT 1;
+	`, projectID, Slugify(itemSlug), projectID, Slugify(itemSlug)).Scan(&item)
+	if err != nil {
+		return "", "", notFound(err)
+	}
+	return project, item, nil
+}
+
+// checkNotRunning refuses a rename while a running job of the work items
+// matching cond could still write to the old directory.
+func checkNotRunning(tx *sql.Tx, cond string, arg any) error {
+	var jobID int64
+	err := tx.QueryRow(`
+		SELECT j.id
+		FROM jobs j
+		JOIN work_items w ON w.id = j.work_item_id
+		WHERE j.status = 'running' AND `+cond+`
+		LIMIT 1;
+	`, arg).Scan(&jobID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("job #%d is still running; rename once it has finished", jobID)
+}
+
+// rewriteRelPaths moves the stored paths of the run images and final
+// copies of the work items matching cond from the from directory to to.
+func rewriteRelPaths(tx *sql.Tx, cond string, arg any, from string, to string) error {
+	type storedPath struct {
+		id  int64
+		rel string
+	}
+	for _, q := range []struct{ query, update string }{
+		{`
+			SELECT ri.id, ri.rel_path
+			FROM run_images ri
+			JOIN runs r ON r.id = ri.run_id
+			JOIN work_items w ON w.id = r.work_item_id
+			WHERE ` + cond + `;`,
+			`UPDATE run_images SET rel_path = ? WHERE id = ?;`},
+		{`
+			SELECT f.work_item_id, f.rel_path
+			FROM work_item_finals f
+			JOIN work_items w ON w.id = f.work_item_id
+			WHERE ` + cond + `;`,
+			`UPDATE work_item_finals SET rel_path = ? WHERE work_item_id = ?;`},
+	} {
+		rows, err := tx.Query(q.query, arg)
+		if err != nil {
+			return err
+		}
+		paths, err := collectRows(rows, func(sc rowScanner) (storedPath, error) {
+			var p storedPath
+			err := sc.Scan(&p.id, &p.rel)
+			return p, err
+		})
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			if moved := movedPath(p.rel, from, to); moved != p.rel {
+				if _, err := tx.Exec(q.update, moved, p.id); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// movedPath rewrites rel if it lies under the from directory.
+func movedPath(rel string, from string, to string) string {
+	rest, ok := strings.CutPrefix(filepath.Clean(rel), from+string(filepath.Separator))
+	if !ok {
+		return rel
+	}
+	return filepath.Join(to, rest)
+}
+
+// fileMove renames one path below the data root.
+type fileMove struct {
+	from, to string
+}
+
+// renameWithFiles runs fn in a transaction and then makes the file moves it
+// returns, still inside the transaction. A failed move rolls the transaction
+// back; a failed commit moves the files back.
+func (s *Store) renameWithFiles(fn func(tx *sql.Tx) ([]fileMove, error)) error {
+	var done []fileMove
+	err := s.withTx(func(tx *sql.Tx) error {
+		moves, err := fn(tx)
+		if err != nil {
+			return err
+		}
+		done, err = s.moveFiles(moves)
+		return err
+	})
+	if err != nil && len(done) > 0 {
+		s.undoMoves(done)
+	}
+	return err
+}
+
+// moveFiles makes the moves in order and returns the ones made. Sources that
+// do not exist are skipped; if a move fails the earlier ones are undone.
+func (s *Store) moveFiles(moves []fileMove) ([]fileMove, error) {
+	var done []fileMove
+	for _, m := range moves {
+		from, to := filepath.Join(s.Root, m.from), filepath.Join(s.Root, m.to)
+		if _, err := os.Stat(from); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		err := func() error {
+			if _, err := os.Stat(to); err == nil {
+				return fmt.Errorf("%s already exists; run gc to remove files left by purged records", m.to)
+			}
+			if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+				return err
+			}
+			return os.Rename(from, to)
+		}()
+		if err != nil {
+			s.undoMoves(done)
+			return nil, err
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+func (s *Store) undoMoves(done []fileMove) {
+	for i := len(done) - 1; i >= 0; i-- {
+		_ = os.Rename(filepath.Join(s.Root, done[i].to), filepath.Join(s.Root, done[i].from))
+	}
+}
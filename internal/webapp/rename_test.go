@@ -0,0 +1,135 @@
+package webapp
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// storedPaths returns the rel_path of every run image and final copy.
+func storedPaths(t *testing.T, store *Store) []string {
+	t.Helper()
+	rows, err := store.db.Query(`
+		SELECT rel_path FROM run_images
+		UNION ALL
+		SELECT rel_path FROM work_item_finals
+		ORDER BY 1;
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := collectRows(rows, func(sc rowScanner) (string, error) {
+		var rel string
+		err := sc.Scan(&rel)
+		return rel, err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestRenameWorkItemRollsBackFailedMove(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.CreateProject("Launch", ""); err != nil {
+		t.Fatal(err)
+	}
+	item, err := store.CreateWorkItem("launch", "Hero", "", "a kite", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageID := addFinishedJob(t, store, "launch", "hero", "kite pixels")
+	if _, err := store.FinalizeImage(item.ID, imageID, "ana"); err != nil {
+		t.Fatal(err)
+	}
+	paths := storedPaths(t, store)
+	if len(paths) != 2 {
+		t.Fatalf("stored paths = %v, want an image and a final", paths)
+	}
+
+	// The directory move succeeds, then renaming the final copy inside it
+	// runs into a stray file and the directory has to be moved back.
+	stray := filepath.Join("images", "launch", "hero", "final", "banner-final.png")
+	writeDataFile(t, store.Root, stray, "stray")
+	if _, err := store.RenameWorkItem("launch", "hero", "Banner"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("rename error = %v, want an existing file", err)
+	}
+
+	got, err := store.GetWorkItem("launch", "hero")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Hero" {
+		t.Fatalf("work item name = %q after a failed rename", got.Name)
+	}
+	if _, err := store.GetWorkItem("launch", "banner"); err == nil {
+		t.Fatal("new slug kept after a failed rename")
+	}
+	if _, item, err := store.ResolveSlugAlias("launch", "banner"); err == nil {
+		t.Fatalf("alias kept after a failed rename: %q", item)
+	}
+	if after := storedPaths(t, store); !slices.Equal(after, paths) {
+		t.Fatalf("stored paths = %v, want %v", after, paths)
+	}
+	for _, rel := range paths {
+		if _, err := os.Stat(filepath.Join(store.Root, rel)); err != nil {
+			t.Errorf("file not moved back: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(store.Root, "images", "launch", "banner")); !os.IsNotExist(err) {
+		t.Fatalf("new directory left behind: %v", err)
+	}
+
+	// Once the stray file is gone the rename goes through.
+	if err := os.Remove(filepath.Join(store.Root, stray)); err != nil {
+		t.Fatal(err)
+	}
+	renamed, err := store.RenameWorkItem("launch", "hero", "Banner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.Slug != "banner" {
+		t.Fatalf("renamed slug = %q", renamed.Slug)
+	}
+	for _, rel := range storedPaths(t, store) {
+		if !strings.HasPrefix(rel, filepath.Join("images", "launch", "banner")+string(filepath.Separator)) {
+			t.Errorf("stored path %s not moved", rel)
+		}
+		if _, err := os.Stat(filepath.Join(store.Root, rel)); err != nil {
+			t.Errorf("moved file missing: %v", err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(store.Root, finalRelPath("launch", "banner", ".png"))); err != nil {
+		t.Fatalf("final copy not renamed: %v", err)
+	}
+}
+
+func TestRenameProjectKeepsFilesWhenTargetExists(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.CreateProject("Launch", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateWorkItem("launch", "Hero", "", "a kite", ""); err != nil {
+		t.Fatal(err)
+	}
+	addFinishedJob(t, store, "launch", "hero", "kite pixels")
+	paths := storedPaths(t, store)
+	writeDataFile(t, store.Root, "images/relaunch/leftover.png", "left by a purged project")
+
+	if _, err := store.RenameProject("launch", "Relaunch"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("rename error = %v, want an existing directory", err)
+	}
+	if _, err := store.GetProject("launch"); err != nil {
+		t.Fatalf("project after a failed rename: %v", err)
+	}
+	if after := storedPaths(t, store); !slices.Equal(after, paths) {
+		t.Fatalf("stored paths = %v, want %v", after, paths)
+	}
+	for _, rel := range append(paths, "images/relaunch/leftover.png") {
+		if _, err := os.Stat(filepath.Join(store.Root, rel)); err != nil {
+			t.Errorf("file moved by a failed rename: %v", err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package webapp
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	StorageSQLite = "sqlite"
+	StorageFiles  = "files"
+)
+
+type BrandRepository interface {
+	CreateBrand(name string, content string) (Brand, error)
+	UpdateBrand(slug string, content string, author string) (Brand, error)
+	GetBrand(slug string) (Brand, error)
+	ListBrands() ([]Brand, error)
+}
+
+type ProjectRepository interface {
+	CreateProject(name string, defaultBrandSlug string) (Project, error)
+	GetProject(slug string) (Project, error)
+	ListProjects() ([]Project, error)
+}
+
+type WorkItemRepository interface {
+	CreateWorkItem(projectSlug string, name string, itemType string, prompt string, brandOverrideSlug string) (WorkItem, error)
+	GetWorkItem(projectSlug string, itemSlug string) (WorkItem, error)
+	ListWorkItems(projectSlug string) ([]WorkItem, error)
+	UpdateWorkItemPrompt(projectSlug string, itemSlug string, prompt string, note string) (WorkItem, error)
+	UpdateWorkItemConstraints(projectSlug string, itemSlug string, constraints Constraints) (WorkItem, error)
+}
+
+type JobRepository interface {
+	CreateGenerateJob(projectSlug string, itemSlug string, payload GenerateJobPayload) (Job, error)
+	ListJobs(filter JobFilter) (JobPage, error)
+	GetJob(jobID int64) (Job, error)
+	ClaimNextQueuedJob() (*JobExecutionContext, error)
+	MarkJobSucceeded(jobID int64) error
+	MarkJobFailed(jobID int64, message string) error
+}
+
+type RunRepository interface {
+	CreateRun(job *JobExecutionContext, promptSnapshot string, settingsJSON string) (int64, error)
+	UpdateRunSettings(runID int64, settingsJSON string) error
+	MarkRunSucceeded(runID int64) error
+	MarkRunFailed(runID int64, message string) error
+	AddRunImage(runID int64, filename string, relPath string, format string, model string, meta ImageMeta) error
+	ListImages(filter ImageFilter) (ImagePage, error)
+	ListJobImages(jobID int64) ([]WorkItemImage, error)
+	ImagePathByID(imageID int64) (string, error)
+	WorkItemImagesDir(projectSlug string, itemSlug string, runID int64) string
+	RelPath(abs string) (string, error)
+}
+
+// BrandHistoryRepository is implemented by backends that keep every saved
+// brand revision. The files backend leaves history to git.
+type BrandHistoryRepository interface {
+	ListBrandVersions(slug string) ([]BrandVersion, error)
+	GetBrandVersion(slug string, version int) (BrandVersion, error)
+	RevertBrand(slug string, version int, author string) (Brand, error)
+}
+
+// PromptHistoryRepository is implemented by backends that keep every saved
+// work item prompt.
+type PromptHistoryRepository interface {
+	ListPromptRevisions(projectSlug string, itemSlug string) ([]PromptRevision, error)
+	GetPromptRevision(projectSlug string, itemSlug string, revision int) (PromptRevision, error)
+	RestorePromptRevision(projectSlug string, itemSlug string, revision int) (WorkItem, error)
+}
+
+// ArchiveRepository is implemented by backends that support archiving,
+// restoring and purging records. The plain List methods omit archived records;
+// the ListAll variants include them.
+type ArchiveRepository interface {
+	ListAllBrands() ([]Brand, error)
+	ListAllProjects() ([]Project, error)
+	ListAllWorkItems(projectSlug string) ([]WorkItem, error)
+	ArchiveBrand(slug string) error
+	RestoreBrand(slug string) error
+	PurgeBrand(slug string) error
+	ArchiveProject(slug string) error
+	RestoreProject(slug string) error
+	PurgeProject(slug string) error
+	ArchiveWorkItem(projectSlug string, itemSlug string) error
+	RestoreWorkItem(projectSlug string, itemSlug string) error
+	PurgeWorkItem(projectSlug string, itemSlug string) error
+}
+
+// GarbageCollector is implemented by backends that can reconcile image
+// files on disk with their image records.
+type GarbageCollector interface {
+	CollectGarbage(apply bool) (GCReport, error)
+}
+
+// Checker is implemented by backends that can check their database and
+// files for consistency and repair what is safe to repair.
+type Checker interface {
+	Fsck(opts FsckOptions) (FsckReport, error)
+}
+
+// ProjectPorter is implemented by backends that can write a project to a
+// portable bundle and recreate one from it.
+type ProjectPorter interface {
+	ExportProject(slug string, w io.Writer) (ProjectBundle, error)
+	ImportProject(bundlePath string, as string) (ImportResult, error)
+}
+
+// Searcher is implemented by backends with a full-text index.
+type Searcher interface {
+	Search(query string, limit int) ([]SearchResult, error)
+}
+
+// TagRepository is implemented by backends that can label work items and
+// images with tags.
+type TagRepository interface {
+	ListTags(prefix string, limit int) ([]Tag, error)
+	TagWorkItem(projectSlug string, itemSlug string, tag string) error
+	UntagWorkItem(projectSlug string, itemSlug string, tag string) error
+	TagImage(imageID int64, tag string) error
+	UntagImage(imageID int64, tag string) error
+}
+
+// RatingRepository is implemented by backends that keep star ratings and
+// notes on images.
+type RatingRepository interface {
+	RateImage(imageID int64, rating int, note string) (WorkItemImage, error)
+	ModelRatings(projectSlug string) ([]ModelRating, error)
+}
+
+// Comparer is implemented by backends that rank a work item's images from
+// pairwise picks.
+type Comparer interface {
+	NextComparison(workItemID int64, skip ...int64) (ComparisonPair, bool, error)
+	RecordComparison(workItemID int64, winnerID int64, loserID int64) (Comparison, error)
+	Leaderboard(workItemID int64) ([]RankedImage, error)
+}
+
+// Finalizer is implemented by backends that can mark one candidate as a
+// work item's final deliverable.
+type Finalizer interface {
+	FinalizeImage(workItemID int64, imageID int64, finalizedBy string) (Final, error)
+	Unfinalize(workItemID int64) error
+	FinalPath(projectSlug string, itemSlug string) (string, error)
+}
+
+// StatusRepository is implemented by backends that track work item status
+// transitions and their history.
+type StatusRepository interface {
+	WorkItemStatus(workItemID int64) (string, error)
+	SetWorkItemStatus(workItemID int64, status string, note string) (StatusChange, error)
+	ListStatusChanges(workItemID int64) ([]StatusChange, error)
+}
+
+// BrandAssetRepository is implemented by backends that store image assets
+// attached to brands.
+type BrandAssetRepository interface {
+	AddBrandAsset(brandSlug string, kind string, filename string, data []byte) (BrandAsset, error)
+	ListBrandAssets(brandSlug string) ([]BrandAsset, error)
+	BrandAssetPath(brandSlug string, assetID int64) (BrandAsset, string, error)
+	DeleteBrandAsset(brandSlug string, assetID int64) error
+}
+
+// Renamer is implemented by backends that can rename projects and work
+// items and resolve the slugs they had before.
+type Renamer interface {
+	RenameProject(slug string, name string) (Project, error)
+	RenameWorkItem(projectSlug string, itemSlug string, name string) (WorkItem, error)
+	ResolveSlugAlias(projectSlug string, itemSlug string) (string, string, error)
+}
+
+// Repository is the full persistence surface the web server depends on.
+type Repository interface {
+	BrandRepository
+	ProjectRepository
+	WorkItemRepository
+	JobRepository
+	RunRepository
+	Close() error
+}
+
+var (
+	_ Repository              = (*Store)(nil)
+	_ Repository              = (*FileStore)(nil)
+	_ BrandHistoryRepository  = (*Store)(nil)
+	_ PromptHistoryRepository = (*Store)(nil)
+	_ ArchiveRepository       = (*Store)(nil)
+	_ GarbageCollector        = (*Store)(nil)
+	_ Checker                 = (*Store)(nil)
+	_ Renamer                 = (*Store)(nil)
+	_ ProjectPorter           = (*Store)(nil)
+	_ Searcher                = (*Store)(nil)
+	_ TagRepository           = (*Store)(nil)
+	_ RatingRepository        = (*Store)(nil)
+	_ Comparer                = (*Store)(nil)
+	_ Finalizer               = (*Store)(nil)
+	_ StatusRepository        = (*Store)(nil)
+	_ BrandAssetRepository    = (*Store)(nil)
+)
+
+func OpenRepository(dataRoot string, storage string) (Repository, error) {
+	switch storage {
+	case "", StorageSQLite:
+		return NewStore(dataRoot)
+	case StorageFiles:
+		return NewFileStore(dataRoot)
+	default:
+		return nil, fmt.Errorf("unknown storage %q (use %s or %s)", storage, StorageSQLite, StorageFiles)
+	}
+}
@@ -0,0 +1,125 @@
+package webapp
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Snippets come back from FTS5 with these markers around matched terms so
+// they can be escaped before the highlight is turned into markup.
+const (
+	searchMarkStart = "\x02"
+	searchMarkEnd   = "\x03"
+)
+
+// SearchResult is one match from the search index. Kind is "brand",
+// "work_item", "run", "job" or "image"; URL points at the page showing the
+// match.
+type SearchResult struct {
+	Kind     string `json:"kind"`
+	ID       int64  `json:"id"`
+	Title    string `json:"title"`
+	Context  string `json:"context,omitempty"`
+	Snippet  string `json:"snippet"`
+	URL      string `json:"url"`
+	Archived bool   `json:"archived,omitempty"`
+	// snippet keeps the match markers for SnippetHTML.
+	snippet string
+}
+
+// SnippetHTML is the escaped snippet with matched terms wrapped in <mark>.
+func (r SearchResult) SnippetHTML() string {
+	escaped := html.EscapeString(r.snippet)
+	escaped = strings.ReplaceAll(escaped, searchMarkStart, "<mark>")
+	return strings.ReplaceAll(escaped, searchMarkEnd, "</mark>")
+}
+
+// Search runs a full-text query over brands, work item names, tags and
+// prompts, run prompt snapshots, job adjustments and image tags, best matches
+// first. Every word of the query
+// must match, as a prefix.
+func (s *Store) Search(query string, limit int) ([]SearchResult, error) {
+	match := searchMatchExpr(query)
+	if match == "" {
+		return []SearchResult{}, nil
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := s.db.Query(`
+		SELECT m.kind, m.ref_id, m.snip,
+		       COALESCE(b.name, w.name, rw.name, jw.name, ii.filename, ''),
+		       COALESCE(wp.slug, rp.slug, jp.slug, ip.slug || ' / ' || iw.slug, ''),
+		       CASE m.kind
+		           WHEN 'brand' THEN '/brands/' || b.slug
+		           WHEN 'work_item' THEN '/projects/' || wp.slug || '/work-items/' || w.slug
+		           WHEN 'run' THEN '/jobs/' || r.job_id
+		           WHEN 'job' THEN '/jobs/' || j.id
+		           WHEN 'image' THEN '/jobs/' || ir.job_id
+		       END,
+		       COALESCE(b.archived_at, w.archived_at, wp.archived_at, rw.archived_at, rp.archived_at, jw.archived_at, jp.archived_at, iw.archived_at, ip.archived_at) IS NOT NULL
+		FROM (
+			SELECT kind, ref_id, rank,
+			       snippet(search_index, 3, char(2), char(3), '…', 16) AS snip
+			FROM search_index
+			WHERE search_index MATCH ?
+			ORDER BY rank
+			LIMIT ?
+		) m
+		LEFT JOIN brands b ON m.kind = 'brand' AND b.id = m.ref_id
+		LEFT JOIN work_items w ON m.kind = 'work_item' AND w.id = m.ref_id
+		LEFT JOIN projects wp ON wp.id = w.project_id
+		LEFT JOIN runs r ON m.kind = 'run' AND r.id = m.ref_id
+		LEFT JOIN work_items rw ON rw.id = r.work_item_id
+		LEFT JOIN projects rp ON rp.id = rw.project_id
+		LEFT JOIN jobs j ON m.kind = 'job' AND j.id = m.ref_id
+		LEFT JOIN work_items jw ON jw.id = j.work_item_id
+		LEFT JOIN projects jp ON jp.id = jw.project_id
+		LEFT JOIN run_images ii ON m.kind = 'image' AND ii.id = m.ref_id
+		LEFT JOIN runs ir ON ir.id = ii.run_id
+		LEFT JOIN work_items iw ON iw.id = ir.work_item_id
+		LEFT JOIN projects ip ON ip.id = iw.project_id
+		ORDER BY m.rank;
+	`, match, limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectRows(rows, func(sc rowScanner) (SearchResult, error) {
+		var r SearchResult
+		var name, project string
+		if err := sc.Scan(&r.Kind, &r.ID, &r.snippet, &name, &project, &r.URL, &r.Archived); err != nil {
+			return r, err
+		}
+		r.Snippet = strings.NewReplacer(searchMarkStart, "", searchMarkEnd, "").Replace(r.snippet)
+		switch r.Kind {
+		case "brand":
+			r.Title = "Brand: " + name
+		case "work_item":
+			r.Title = "Prompt: " + name
+		case "run":
+			r.Title = fmt.Sprintf("Run #%d: %s", r.ID, name)
+		case "job":
+			r.Title = fmt.Sprintf("Adjustment, job #%d: %s", r.ID, name)
+		case "image":
+			r.Title = "Image: " + name
+		}
+		r.Context = project
+		return r, nil
+	})
+}
+
+// searchMatchExpr turns free text into an FTS5 expression that ANDs a
+// quoted prefix query per word, so punctuation in the input is never parsed
+// as query syntax.
+func searchMatchExpr(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([]string, 0, len(words))
+	for _, w := range words {
+		terms = append(terms, `"`+w+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
@@ -1,34 +1,45 @@
 package webapp
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"text/template"
 	"time"
+
+	"imagegen/internal/imageconv"
 )
 
 var hashedDistAssetPattern = regexp.MustCompile(`^[a-z0-9-]+-[A-Z0-9]{6,}\.(js|css|png|jpg|jpeg|webp|svg|ico)$`)
 
 type Server struct {
-	store         *Store
+	store         Repository
 	templates     *template.Template
 	manifestMu    sync.RWMutex
 	assetManifest map[string]string
 	logger        *log.Logger
 	staticFS      http.FileSystem
+	// DefaultMaxBytes is the byte budget of JPG and WEBP jobs that do not
+	// set one; 0 means none.
+	DefaultMaxBytes int
 }
 
 type PageData struct {
@@ -44,11 +55,55 @@ type PageData struct {
 	WorkImages  []WorkItemImage
 	Jobs        []Job
 	Job         Job
-	Error       string
+	Versions    []BrandVersion
+	DiffFrom    int
+	DiffTo      int
+	Diff        []DiffRow
+	Timeline    []PromptTimelineEntry
+	GC          *GCReport
+	Fsck        *FsckReport
+	// SearchQuery is the text of the search box; SearchResults its matches.
+	SearchQuery   string
+	SearchResults []SearchResult
+	// JobFilter holds the filters of the jobs listing; NextPageURL and
+	// PrevPageURL link to the neighbouring pages of a paged listing.
+	JobFilter   JobFilter
+	NextPageURL string
+	PrevPageURL string
+	// ImageFilter holds the filters and sort order of an image gallery.
+	ImageFilter ImageFilter
+	// ModelRatings are a project's average image ratings per model.
+	ModelRatings []ModelRating
+	// Comparison is the pair on the compare page, nil with fewer than two
+	// images; Leaderboard ranks the work item's images.
+	Comparison  *ComparisonPair
+	Leaderboard []RankedImage
+	// Finals are the listed work items that have a final deliverable.
+	Finals []WorkItem
+	// BrandAssets are the images attached to the page's brand.
+	BrandAssets []BrandAsset
+	// Board groups a project's work items by status; StatusChanges is a
+	// work item's status history.
+	Board         []BoardColumn
+	StatusChanges []StatusChange
+	// Tags are the known tags offered for autocomplete; TagFilter is the tag
+	// a work item listing is narrowed to.
+	Tags      []Tag
+	TagFilter string
+	// ShowArchived is set when list pages include archived records.
+	ShowArchived bool
+	Error        string
+}
+
+// PromptTimelineEntry is a prompt revision with its diff against the
+// revision before it (nil for the first).
+type PromptTimelineEntry struct {
+	PromptRevision
+	Diff []DiffRow
 }
 
-func NewServer(dataRoot string) (*Server, error) {
-	store, err := NewStore(dataRoot)
+func NewServer(dataRoot string, storage string) (*Server, error) {
+	store, err := OpenRepository(dataRoot, storage)
 	if err != nil {
 		return nil, err
 	}
@@ -78,21 +133,73 @@ func (s *Server) Routes() http.Handler {
 	mux.HandleFunc("POST /brands", s.handleCreateBrand)
 	mux.HandleFunc("GET /brands/{slug}", s.handleBrandEdit)
 	mux.HandleFunc("POST /brands/{slug}", s.handleUpdateBrand)
+	mux.HandleFunc("POST /brands/{slug}/archive", s.handleLifecycle("archive", "brand"))
+	mux.HandleFunc("POST /brands/{slug}/restore", s.handleLifecycle("restore", "brand"))
+	mux.HandleFunc("POST /brands/{slug}/purge", s.handleLifecycle("purge", "brand"))
+	mux.HandleFunc("GET /brands/{slug}/history", s.handleBrandHistory)
+	mux.HandleFunc("POST /brands/{slug}/history/{version}/revert", s.handleRevertBrand)
+	mux.HandleFunc("POST /brands/{slug}/assets", s.handleUploadBrandAsset)
+	mux.HandleFunc("GET /brands/{slug}/assets/{assetID}", s.handleBrandAssetFile)
+	mux.HandleFunc("POST /brands/{slug}/assets/{assetID}/delete", s.handleDeleteBrandAsset)
 
 	mux.HandleFunc("GET /projects", s.handleProjects)
 	mux.HandleFunc("POST /projects", s.handleCreateProject)
+	mux.HandleFunc("POST /projects/import", s.handleImportProject)
 	mux.HandleFunc("GET /projects/{slug}", s.handleProjectDetail)
+	mux.HandleFunc("GET /projects/{slug}/export", s.handleExportProject)
+	mux.HandleFunc("GET /projects/{slug}/board", s.handleProjectBoard)
+	mux.HandleFunc("POST /projects/{slug}/archive", s.handleLifecycle("archive", "project"))
+	mux.HandleFunc("POST /projects/{slug}/restore", s.handleLifecycle("restore", "project"))
+	mux.HandleFunc("POST /projects/{slug}/purge", s.handleLifecycle("purge", "project"))
+	mux.HandleFunc("POST /projects/{slug}/rename", s.handleRename("project"))
 	mux.HandleFunc("POST /projects/{slug}/work-items", s.handleCreateWorkItem)
 	mux.HandleFunc("GET /projects/{slug}/work-items/{itemSlug}", s.handleWorkItemDetail)
 	mux.HandleFunc("POST /projects/{slug}/work-items/{itemSlug}/prompt", s.handleUpdateWorkItemPrompt)
+	mux.HandleFunc("POST /projects/{slug}/work-items/{itemSlug}/prompt/revisions/{revision}/restore", s.handleRestorePromptRevision)
+	mux.HandleFunc("POST /projects/{slug}/work-items/{itemSlug}/constraints", s.handleUpdateWorkItemConstraints)
 	mux.HandleFunc("POST /projects/{slug}/work-items/{itemSlug}/generate", s.handleGenerateWorkItem)
+	mux.HandleFunc("POST /projects/{slug}/work-items/{itemSlug}/archive", s.handleLifecycle("archive", "work-item"))
+	mux.HandleFunc("POST /projects/{slug}/work-items/{itemSlug}/restore", s.handleLifecycle("restore", "work-item"))
+	mux.HandleFunc("POST /projects/{slug}/work-items/{itemSlug}/purge", s.handleLifecycle("purge", "work-item"))
+	mux.HandleFunc("POST /projects/{slug}/work-items/{itemSlug}/rename", s.handleRename("work-item"))
+	mux.HandleFunc("GET /projects/{slug}/work-items/{itemSlug}/compare", s.handleCompare)
+	mux.HandleFunc("POST /projects/{slug}/work-items/{itemSlug}/compare", s.handleRecordComparison)
+	mux.HandleFunc("GET /projects/{slug}/work-items/{itemSlug}/final", s.handleFinalImage)
+	mux.HandleFunc("POST /projects/{slug}/work-items/{itemSlug}/finalize", s.handleFinalize)
+	mux.HandleFunc("POST /projects/{slug}/work-items/{itemSlug}/unfinalize", s.handleUnfinalize)
+	mux.HandleFunc("POST /projects/{slug}/work-items/{itemSlug}/status", s.handleSetWorkItemStatus)
+	mux.HandleFunc("POST /projects/{slug}/work-items/{itemSlug}/tags", s.handleWorkItemTag(true))
+	mux.HandleFunc("POST /projects/{slug}/work-items/{itemSlug}/tags/{tag}/delete", s.handleWorkItemTag(false))
 
 	mux.HandleFunc("GET /jobs", s.handleJobs)
 	mux.HandleFunc("GET /jobs/{jobID}", s.handleJobDetail)
 	mux.HandleFunc("GET /images/{imageID}", s.handleImageByID)
+	mux.HandleFunc("GET /images/{imageID}/print", s.handleImagePrintExport)
+	mux.HandleFunc("GET /images/{imageID}/download", s.handleImageDownload)
+	mux.HandleFunc("POST /images/{imageID}/rating", s.handleRateImage)
+	mux.HandleFunc("POST /images/{imageID}/tags", s.handleImageTag(true))
+	mux.HandleFunc("POST /images/{imageID}/tags/{tag}/delete", s.handleImageTag(false))
+	mux.HandleFunc("GET /api/tags", s.handleAPITags)
+	mux.HandleFunc("GET /api/jobs", s.handleAPIJobs)
 	mux.HandleFunc("GET /api/jobs/{jobID}", s.handleAPIJobStatus)
+	mux.HandleFunc("GET /api/images", s.handleAPIImages)
+	mux.HandleFunc("POST /api/images/{imageID}/rating", s.handleRateImage)
+	mux.HandleFunc("GET /api/projects/{slug}/model-ratings", s.handleAPIModelRatings)
+	mux.HandleFunc("GET /api/work-items/{workItemID}/compare", s.handleAPICompare)
+	mux.HandleFunc("POST /api/work-items/{workItemID}/compare", s.handleAPICompare)
+	mux.HandleFunc("POST /api/work-items/{workItemID}/finalize", s.handleAPIFinalize)
+	mux.HandleFunc("DELETE /api/work-items/{workItemID}/finalize", s.handleAPIFinalize)
+	mux.HandleFunc("GET /api/work-items/{workItemID}/status", s.handleAPIWorkItemStatus)
+	mux.HandleFunc("POST /api/work-items/{workItemID}/status", s.handleAPIWorkItemStatus)
+	mux.HandleFunc("GET /search", s.handleSearch)
+	mux.HandleFunc("GET /api/search", s.handleAPISearch)
+
+	mux.HandleFunc("GET /admin/gc", s.handleGC)
+	mux.HandleFunc("POST /admin/gc", s.handleGC)
+	mux.HandleFunc("GET /admin/fsck", s.handleFsck)
+	mux.HandleFunc("POST /admin/fsck", s.handleFsck)
 
-	return s.loggingMiddleware(mux)
+	return s.loggingMiddleware(s.slugAliasFallback(mux))
 }
 
 func (s *Server) staticHandler() http.Handler {
@@ -107,16 +214,18 @@ func (s *Server) staticHandler() http.Handler {
 }
 
 func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
-	projects, _ := s.store.ListProjects()
-	brands, _ := s.store.ListBrands()
-	jobs, _ := s.store.ListJobs(8)
+	showArchived := r.URL.Query().Get("archived") == "1"
+	projects, _ := s.listProjects(showArchived)
+	brands, _ := s.listBrands(showArchived)
+	jobs, _ := s.store.ListJobs(JobFilter{Limit: 8})
 	s.render(w, r, "dashboard", PageData{
-		Title:       "Dashboard",
-		CurrentPath: r.URL.Path,
-		Projects:    projects,
-		Brands:      brands,
-		Jobs:        jobs,
-		Flash:       r.URL.Query().Get("ok"),
+		Title:        "Dashboard",
+		CurrentPath:  r.URL.Path,
+		Projects:     projects,
+		Brands:       brands,
+		Jobs:         jobs.Jobs,
+		ShowArchived: showArchived,
+		Flash:        r.URL.Query().Get("ok"),
 	})
 }
 
@@ -128,16 +237,18 @@ func (s *Server) handleAbout(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleBrands(w http.ResponseWriter, r *http.Request) {
-	brands, err := s.store.ListBrands()
+	showArchived := r.URL.Query().Get("archived") == "1"
+	brands, err := s.listBrands(showArchived)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	s.render(w, r, "brands", PageData{
-		Title:       "Brands",
-		CurrentPath: r.URL.Path,
-		Brands:      brands,
-		Flash:       r.URL.Query().Get("ok"),
+		Title:        "Brands",
+		CurrentPath:  r.URL.Path,
+		Brands:       brands,
+		ShowArchived: showArchived,
+		Flash:        r.URL.Query().Get("ok"),
 	})
 }
 
@@ -162,17 +273,26 @@ func (s *Server) handleCreateBrand(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleBrandEdit(w http.ResponseWriter, r *http.Request) {
-	slug := r.PathValue("slug")
-	brand, err := s.store.GetBrand(slug)
+	brand, err := s.store.GetBrand(r.PathValue("slug"))
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
+	s.renderBrandPage(w, r, brand, "")
+}
+
+func (s *Server) renderBrandPage(w http.ResponseWriter, r *http.Request, brand Brand, renderErr string) {
+	var assets []BrandAsset
+	if repo, ok := s.store.(BrandAssetRepository); ok {
+		assets, _ = repo.ListBrandAssets(brand.Slug)
+	}
 	s.render(w, r, "brand-edit", PageData{
 		Title:       fmt.Sprintf("Brand: %s", brand.Slug),
 		CurrentPath: "/brands",
 		Brand:       brand,
+		BrandAssets: assets,
 		Flash:       r.URL.Query().Get("ok"),
+		Error:       renderErr,
 	})
 }
 
@@ -183,27 +303,383 @@ func (s *Server) handleUpdateBrand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	content := strings.TrimSpace(r.FormValue("content"))
-	brand, err := s.store.UpdateBrand(slug, content)
+	author := strings.TrimSpace(r.FormValue("author"))
+	brand, err := s.store.UpdateBrand(slug, content, author)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		current, getErr := s.store.GetBrand(slug)
+		if getErr != nil {
+			http.NotFound(w, r)
+			return
+		}
+		current.Content = content
+		s.renderBrandPage(w, r, current, err.Error())
 		return
 	}
 	http.Redirect(w, r, "/brands/"+brand.Slug+"?ok=Brand+saved", http.StatusSeeOther)
 }
 
+func (s *Server) handleUploadBrandAsset(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.store.(BrandAssetRepository)
+	if !ok {
+		http.Error(w, "brand assets require -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	brand, err := s.store.GetBrand(r.PathValue("slug"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBrandAssetBytes+1<<20)
+	file, header, err := r.FormFile("asset")
+	if err != nil {
+		s.renderBrandPage(w, r, brand, "choose an image file")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		s.renderBrandPage(w, r, brand, err.Error())
+		return
+	}
+	if _, err := repo.AddBrandAsset(brand.Slug, r.FormValue("kind"), header.Filename, data); err != nil {
+		s.renderBrandPage(w, r, brand, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/brands/"+brand.Slug+"?ok=Asset+uploaded", http.StatusSeeOther)
+}
+
+func (s *Server) handleBrandAssetFile(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.store.(BrandAssetRepository)
+	if !ok {
+		http.Error(w, "brand assets require -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	assetID, _ := strconv.ParseInt(r.PathValue("assetID"), 10, 64)
+	asset, path, err := repo.BrandAssetPath(r.PathValue("slug"), assetID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", asset.MIMEType)
+	http.ServeFile(w, r, path)
+}
+
+func (s *Server) handleDeleteBrandAsset(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.store.(BrandAssetRepository)
+	if !ok {
+		http.Error(w, "brand assets require -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	slug := Slugify(r.PathValue("slug"))
+	assetID, _ := strconv.ParseInt(r.PathValue("assetID"), 10, 64)
+	if err := repo.DeleteBrandAsset(slug, assetID); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/brands/"+slug+"?ok=Asset+deleted", http.StatusSeeOther)
+}
+
+func (s *Server) handleBrandHistory(w http.ResponseWriter, r *http.Request) {
+	history, ok := s.store.(BrandHistoryRepository)
+	if !ok {
+		http.Error(w, "brand history requires -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	brand, err := s.store.GetBrand(r.PathValue("slug"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	versions, err := history.ListBrandVersions(brand.Slug)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	to, _ := strconv.Atoi(r.URL.Query().Get("to"))
+	if to <= 0 {
+		to = brand.Version
+	}
+	from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+	if from <= 0 {
+		from = max(to-1, 1)
+	}
+	data := PageData{
+		Title:       fmt.Sprintf("Brand History: %s", brand.Slug),
+		CurrentPath: "/brands",
+		Brand:       brand,
+		Versions:    versions,
+		DiffFrom:    from,
+		DiffTo:      to,
+		Flash:       r.URL.Query().Get("ok"),
+	}
+	if len(versions) > 0 {
+		before, errFrom := history.GetBrandVersion(brand.Slug, from)
+		after, errTo := history.GetBrandVersion(brand.Slug, to)
+		if errFrom != nil || errTo != nil {
+			data.Error = fmt.Sprintf("cannot compare v%d with v%d", from, to)
+		} else {
+			data.Diff = DiffLines(before.Content, after.Content)
+		}
+	}
+	s.render(w, r, "brand-history", data)
+}
+
+func (s *Server) handleRevertBrand(w http.ResponseWriter, r *http.Request) {
+	history, ok := s.store.(BrandHistoryRepository)
+	if !ok {
+		http.Error(w, "brand history requires -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	version, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil || version < 1 {
+		http.Error(w, "invalid version", http.StatusBadRequest)
+		return
+	}
+	brand, err := history.RevertBrand(r.PathValue("slug"), version, strings.TrimSpace(r.FormValue("author")))
+	if errors.Is(err, os.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/brands/%s/history?ok=Reverted+to+v%d", brand.Slug, version), http.StatusSeeOther)
+}
+
+// handleLifecycle archives, restores or purges a brand, project or work item
+// and redirects to the page that still shows it.
+func (s *Server) handleLifecycle(action string, kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo, ok := s.store.(ArchiveRepository)
+		if !ok {
+			http.Error(w, "archiving requires -storage sqlite", http.StatusNotImplemented)
+			return
+		}
+		slug := Slugify(r.PathValue("slug"))
+		itemSlug := Slugify(r.PathValue("itemSlug"))
+
+		var err error
+		var detail, list string
+		switch kind {
+		case "brand":
+			detail, list = "/brands/"+slug, "/brands"
+			err = map[string]func(string) error{
+				"archive": repo.ArchiveBrand,
+				"restore": repo.RestoreBrand,
+				"purge":   repo.PurgeBrand,
+			}[action](slug)
+		case "project":
+			detail, list = "/projects/"+slug, "/projects"
+			err = map[string]func(string) error{
+				"archive": repo.ArchiveProject,
+				"restore": repo.RestoreProject,
+				"purge":   repo.PurgeProject,
+			}[action](slug)
+		case "work-item":
+			detail, list = "/projects/"+slug+"/work-items/"+itemSlug, "/projects/"+slug
+			err = map[string]func(string, string) error{
+				"archive": repo.ArchiveWorkItem,
+				"restore": repo.RestoreWorkItem,
+				"purge":   repo.PurgeWorkItem,
+			}[action](slug, itemSlug)
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		switch action {
+		case "archive":
+			http.Redirect(w, r, list+"?ok=Archived", http.StatusSeeOther)
+		case "restore":
+			http.Redirect(w, r, detail+"?ok=Restored", http.StatusSeeOther)
+		default:
+			http.Redirect(w, r, list+"?archived=1&ok=Purged", http.StatusSeeOther)
+		}
+	}
+}
+
+// handleRename renames a project or work item and redirects to its new URL.
+func (s *Server) handleRename(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo, ok := s.store.(Renamer)
+		if !ok {
+			http.Error(w, "renaming requires -storage sqlite", http.StatusNotImplemented)
+			return
+		}
+		slug := Slugify(r.PathValue("slug"))
+		itemSlug := Slugify(r.PathValue("itemSlug"))
+		name := r.FormValue("name")
+
+		var err error
+		var target string
+		switch kind {
+		case "project":
+			var project Project
+			project, err = repo.RenameProject(slug, name)
+			target = "/projects/" + project.Slug
+		case "work-item":
+			var item WorkItem
+			item, err = repo.RenameWorkItem(slug, itemSlug, name)
+			target = "/projects/" + slug + "/work-items/" + item.Slug
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Redirect(w, r, target+"?ok=Renamed", http.StatusSeeOther)
+	}
+}
+
+// slugAliasFallback redirects requests that a handler answered with 404
+// when they name a project or work item by a slug it had before a rename:
+// 301 for GET and HEAD, 308 otherwise so forms keep their method. Aliases are
+// only looked up once the normal lookup has failed.
+func (s *Server) slugAliasFallback(next http.Handler) http.Handler {
+	repo, ok := s.store.(Renamer)
+	if !ok {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		base := 0
+		if parts[0] == "api" {
+			base = 1
+		}
+		if len(parts) < base+2 || parts[base] != "projects" || parts[base+1] == "import" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		nw := &notFoundWriter{ResponseWriter: w}
+		next.ServeHTTP(nw, r)
+		if !nw.notFound {
+			return
+		}
+
+		projectAt, itemAt := base+1, -1
+		if len(parts) > base+3 && parts[base+2] == "work-items" {
+			itemAt = base + 3
+		}
+		itemSlug := ""
+		if itemAt > 0 {
+			itemSlug = parts[itemAt]
+		}
+		project, item, err := repo.ResolveSlugAlias(parts[projectAt], itemSlug)
+		if err != nil || (project == parts[projectAt] && item == itemSlug) {
+			nw.replay()
+			return
+		}
+		parts[projectAt] = project
+		if itemAt > 0 {
+			parts[itemAt] = item
+		}
+		target := "/" + strings.Join(parts, "/")
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		w.Header().Del("Content-Type")
+		http.Redirect(w, r, target, code)
+	})
+}
+
+// notFoundWriter holds back a 404 response so slugAliasFallback can replace
+// it with a redirect. Other responses pass straight through.
+type notFoundWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	notFound    bool
+	body        bytes.Buffer
+}
+
+func (w *notFoundWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code == http.StatusNotFound {
+		w.notFound = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *notFoundWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.notFound {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *notFoundWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// replay sends the held back 404.
+func (w *notFoundWriter) replay() {
+	w.ResponseWriter.WriteHeader(http.StatusNotFound)
+	_, _ = w.ResponseWriter.Write(w.body.Bytes())
+}
+
+func (s *Server) listBrands(includeArchived bool) ([]Brand, error) {
+	if repo, ok := s.store.(ArchiveRepository); ok && includeArchived {
+		return repo.ListAllBrands()
+	}
+	return s.store.ListBrands()
+}
+
+func (s *Server) listProjects(includeArchived bool) ([]Project, error) {
+	if repo, ok := s.store.(ArchiveRepository); ok && includeArchived {
+		return repo.ListAllProjects()
+	}
+	return s.store.ListProjects()
+}
+
+func (s *Server) listWorkItems(projectSlug string, includeArchived bool) ([]WorkItem, error) {
+	if repo, ok := s.store.(ArchiveRepository); ok && includeArchived {
+		return repo.ListAllWorkItems(projectSlug)
+	}
+	return s.store.ListWorkItems(projectSlug)
+}
+
 func (s *Server) handleProjects(w http.ResponseWriter, r *http.Request) {
-	projects, err := s.store.ListProjects()
+	showArchived := r.URL.Query().Get("archived") == "1"
+	projects, err := s.listProjects(showArchived)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	brands, _ := s.store.ListBrands()
 	s.render(w, r, "projects", PageData{
-		Title:       "Projects",
-		CurrentPath: r.URL.Path,
-		Projects:    projects,
-		Brands:      brands,
-		Flash:       r.URL.Query().Get("ok"),
+		Title:        "Projects",
+		CurrentPath:  r.URL.Path,
+		Projects:     projects,
+		Brands:       brands,
+		ShowArchived: showArchived,
+		Flash:        r.URL.Query().Get("ok"),
 	})
 }
 
@@ -237,16 +713,137 @@ func (s *Server) handleProjectDetail(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	items, _ := s.store.ListWorkItems(slug)
+	showArchived := r.URL.Query().Get("archived") == "1"
+	items, _ := s.listWorkItems(slug, showArchived)
+	tag := NormalizeTag(r.URL.Query().Get("tag"))
+	if tag != "" {
+		tagged := []WorkItem{}
+		for _, item := range items {
+			if slices.Contains(item.Tags, tag) {
+				tagged = append(tagged, item)
+			}
+		}
+		items = tagged
+	}
+	var finals []WorkItem
+	for _, item := range items {
+		if item.Final != nil {
+			finals = append(finals, item)
+		}
+	}
 	brands, _ := s.store.ListBrands()
+	var modelRatings []ModelRating
+	if repo, ok := s.store.(RatingRepository); ok {
+		modelRatings, _ = repo.ModelRatings(slug)
+	}
 	s.render(w, r, "project-detail", PageData{
-		Title:       fmt.Sprintf("Project: %s", project.Name),
+		Title:        fmt.Sprintf("Project: %s", project.Name),
+		CurrentPath:  "/projects",
+		Project:      project,
+		WorkItems:    items,
+		Brands:       brands,
+		Tags:         s.tagOptions(),
+		TagFilter:    tag,
+		ModelRatings: modelRatings,
+		Finals:       finals,
+		ShowArchived: showArchived,
+		Flash:        r.URL.Query().Get("ok"),
+	})
+}
+
+func (s *Server) handleProjectBoard(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.store.(StatusRepository); !ok {
+		http.Error(w, "the project board requires -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	project, err := s.store.GetProject(r.PathValue("slug"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	items, err := s.store.ListWorkItems(project.Slug)
+	data := PageData{
+		Title:       fmt.Sprintf("Board: %s", project.Name),
 		CurrentPath: "/projects",
 		Project:     project,
-		WorkItems:   items,
-		Brands:      brands,
+		Board:       GroupByStatus(items),
 		Flash:       r.URL.Query().Get("ok"),
-	})
+	}
+	if err != nil {
+		data.Error = err.Error()
+	}
+	s.render(w, r, "project-board", data)
+}
+
+func (s *Server) handleExportProject(w http.ResponseWriter, r *http.Request) {
+	porter, ok := s.store.(ProjectPorter)
+	if !ok {
+		http.Error(w, "project export requires -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	project, err := s.store.GetProject(r.PathValue("slug"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", bundleFilename(project.Slug)))
+	if _, err := porter.ExportProject(project.Slug, w); err != nil {
+		s.logger.Printf("export project %s: %v", project.Slug, err)
+	}
+}
+
+func (s *Server) handleImportProject(w http.ResponseWriter, r *http.Request) {
+	porter, ok := s.store.(ProjectPorter)
+	if !ok {
+		http.Error(w, "project import requires -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	result, err := s.importUploadedBundle(porter, r)
+	if err != nil {
+		projects, _ := s.store.ListProjects()
+		brands, _ := s.store.ListBrands()
+		s.render(w, r, "projects", PageData{
+			Title:       "Projects",
+			CurrentPath: "/projects",
+			Projects:    projects,
+			Brands:      brands,
+			Error:       "Import failed: " + err.Error(),
+		})
+		return
+	}
+	msg := "Project imported"
+	if len(result.Renamed) > 0 {
+		renames := []string{}
+		for _, from := range sortedKeys(result.Renamed) {
+			renames = append(renames, from+" as "+result.Renamed[from])
+		}
+		msg += " (" + strings.Join(renames, ", ") + ")"
+	}
+	http.Redirect(w, r, "/projects/"+result.Project.Slug+"?ok="+url.QueryEscape(msg), http.StatusSeeOther)
+}
+
+// importUploadedBundle spools the uploaded bundle to a temp file, since
+// import reads it twice.
+func (s *Server) importUploadedBundle(porter ProjectPorter, r *http.Request) (ImportResult, error) {
+	file, _, err := r.FormFile("bundle")
+	if err != nil {
+		return ImportResult{}, errors.New("choose a bundle file")
+	}
+	defer file.Close()
+	tmp, err := os.CreateTemp("", "imagegen-import-*.tar.gz")
+	if err != nil {
+		return ImportResult{}, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, file); err != nil {
+		tmp.Close()
+		return ImportResult{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return ImportResult{}, err
+	}
+	return porter.ImportProject(tmp.Name(), r.FormValue("as"))
 }
 
 func (s *Server) handleCreateWorkItem(w http.ResponseWriter, r *http.Request) {
@@ -279,13 +876,61 @@ func (s *Server) handleUpdateWorkItemPrompt(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	prompt := strings.TrimSpace(r.FormValue("prompt"))
-	if _, err := s.store.UpdateWorkItemPrompt(projectSlug, itemSlug, prompt); err != nil {
+	note := strings.TrimSpace(r.FormValue("note"))
+	if _, err := s.store.UpdateWorkItemPrompt(projectSlug, itemSlug, prompt, note); err != nil {
 		s.renderWorkItemPage(w, r, projectSlug, itemSlug, err.Error())
 		return
 	}
 	http.Redirect(w, r, "/projects/"+Slugify(projectSlug)+"/work-items/"+Slugify(itemSlug)+"?ok=Prompt+saved", http.StatusSeeOther)
 }
 
+func (s *Server) handleUpdateWorkItemConstraints(w http.ResponseWriter, r *http.Request) {
+	projectSlug := r.PathValue("slug")
+	itemSlug := r.PathValue("itemSlug")
+	if err := r.ParseForm(); err != nil {
+		s.renderWorkItemPage(w, r, projectSlug, itemSlug, "invalid form")
+		return
+	}
+	constraints := Constraints{
+		AspectRatio: r.FormValue("aspect_ratio"),
+		Models:      r.Form["models"],
+		Formats:     r.Form["formats"],
+		Sizes:       r.Form["sizes"],
+		MustInclude: strings.Split(r.FormValue("must_include"), "\n"),
+		MustAvoid:   strings.Split(r.FormValue("must_avoid"), "\n"),
+		Transparent: r.FormValue("transparent") != "",
+	}
+	if _, err := s.store.UpdateWorkItemConstraints(projectSlug, itemSlug, constraints); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		s.renderWorkItemPage(w, r, projectSlug, itemSlug, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/projects/"+Slugify(projectSlug)+"/work-items/"+Slugify(itemSlug)+"?ok=Constraints+saved", http.StatusSeeOther)
+}
+
+func (s *Server) handleRestorePromptRevision(w http.ResponseWriter, r *http.Request) {
+	projectSlug := r.PathValue("slug")
+	itemSlug := r.PathValue("itemSlug")
+	history, ok := s.store.(PromptHistoryRepository)
+	if !ok {
+		http.Error(w, "prompt history requires -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	revision, err := strconv.Atoi(r.PathValue("revision"))
+	if err != nil || revision < 1 {
+		http.Error(w, "invalid revision", http.StatusBadRequest)
+		return
+	}
+	if _, err := history.RestorePromptRevision(projectSlug, itemSlug, revision); err != nil {
+		s.renderWorkItemPage(w, r, projectSlug, itemSlug, err.Error())
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/projects/%s/work-items/%s?ok=Restored+revision+%d", Slugify(projectSlug), Slugify(itemSlug), revision), http.StatusSeeOther)
+}
+
 func (s *Server) handleGenerateWorkItem(w http.ResponseWriter, r *http.Request) {
 	projectSlug := Slugify(r.PathValue("slug"))
 	itemSlug := Slugify(r.PathValue("itemSlug"))
@@ -302,13 +947,37 @@ func (s *Server) handleGenerateWorkItem(w http.ResponseWriter, r *http.Request)
 		}
 		count = v
 	}
+	outputFormat := strings.TrimSpace(r.FormValue("output_format"))
+	maxBytes := 0
+	if outputFormat == "jpg" || outputFormat == "webp" {
+		maxBytes = s.DefaultMaxBytes
+	}
+	if raw := strings.TrimSpace(r.FormValue("max_bytes")); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v < 0 {
+			s.renderWorkItemPage(w, r, projectSlug, itemSlug, "max bytes must be >= 0")
+			return
+		}
+		maxBytes = v
+	}
+	var references []int64
+	for _, raw := range r.Form["reference_asset_ids"] {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			s.renderWorkItemPage(w, r, projectSlug, itemSlug, "invalid reference image")
+			return
+		}
+		references = append(references, id)
+	}
 	payload := GenerateJobPayload{
-		Model:        strings.TrimSpace(r.FormValue("model")),
-		Count:        count,
-		OutputFormat: strings.TrimSpace(r.FormValue("output_format")),
-		ImageSize:    strings.TrimSpace(r.FormValue("image_size")),
-		AspectRatio:  strings.TrimSpace(r.FormValue("aspect_ratio")),
-		Adjustment:   strings.TrimSpace(r.FormValue("adjustment")),
+		Model:             strings.TrimSpace(r.FormValue("model")),
+		Count:             count,
+		OutputFormat:      outputFormat,
+		ImageSize:         strings.TrimSpace(r.FormValue("image_size")),
+		AspectRatio:       strings.TrimSpace(r.FormValue("aspect_ratio")),
+		Adjustment:        strings.TrimSpace(r.FormValue("adjustment")),
+		MaxBytes:          maxBytes,
+		ReferenceAssetIDs: references,
 	}
 	job, err := s.store.CreateGenerateJob(projectSlug, itemSlug, payload)
 	if err != nil {
@@ -332,19 +1001,152 @@ func (s *Server) handleGenerateWorkItem(w http.ResponseWriter, r *http.Request)
 }
 
 func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
-	jobs, err := s.store.ListJobs(100)
+	filter, err := parseJobFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := s.store.ListJobs(filter)
+	if errors.Is(err, errBadCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	projects, _ := s.store.ListProjects()
 	s.render(w, r, "jobs", PageData{
 		Title:       "Jobs",
 		CurrentPath: "/jobs",
-		Jobs:        jobs,
+		Jobs:        page.Jobs,
+		Projects:    projects,
+		Tags:        s.tagOptions(),
+		JobFilter:   filter,
+		NextPageURL: pageURL(r, "cursor", page.NextCursor),
+		PrevPageURL: pageURL(r, "cursor", page.PrevCursor),
 		Flash:       r.URL.Query().Get("ok"),
 	})
 }
 
+func (s *Server) handleAPIJobs(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseJobFilter(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	page, err := s.store.ListJobs(filter)
+	if errors.Is(err, errBadCursor) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	jobs := make([]map[string]any, 0, len(page.Jobs))
+	for _, job := range page.Jobs {
+		jobs = append(jobs, apiJob(job))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"jobs":        jobs,
+		"next_cursor": page.NextCursor,
+		"prev_cursor": page.PrevCursor,
+	})
+}
+
+func (s *Server) handleAPIImages(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseImageFilter(r.URL.Query())
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	page, err := s.store.ListImages(filter)
+	if errors.Is(err, errBadCursor) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"images":      page.Images,
+		"next_cursor": page.NextCursor,
+		"prev_cursor": page.PrevCursor,
+	})
+}
+
+// parseJobFilter reads listing filters from a query string: status, project,
+// item, model, tag, from and to (YYYY-MM-DD, both inclusive), cursor and
+// limit.
+func parseJobFilter(q url.Values) (JobFilter, error) {
+	filter := JobFilter{
+		Status:       strings.TrimSpace(q.Get("status")),
+		ProjectSlug:  strings.TrimSpace(q.Get("project")),
+		WorkItemSlug: strings.TrimSpace(q.Get("item")),
+		Model:        strings.TrimSpace(q.Get("model")),
+		Tag:          NormalizeTag(q.Get("tag")),
+		Cursor:       strings.TrimSpace(q.Get("cursor")),
+	}
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return filter, errors.New("limit must be a positive integer")
+		}
+		filter.Limit = limit
+	}
+	for _, bound := range []struct {
+		name string
+		dst  *time.Time
+		days int
+	}{{"from", &filter.From, 0}, {"to", &filter.To, 1}} {
+		raw := strings.TrimSpace(q.Get(bound.name))
+		if raw == "" {
+			continue
+		}
+		day, err := time.ParseInLocation(time.DateOnly, raw, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("%s must be a date like 2006-01-02", bound.name)
+		}
+		*bound.dst = day.AddDate(0, 0, bound.days)
+	}
+	return filter, nil
+}
+
+// parseImageFilter reads the job listing filters plus min_rating (1 to 5)
+// and sort ("newest" or "rating").
+func parseImageFilter(q url.Values) (ImageFilter, error) {
+	jobFilter, err := parseJobFilter(q)
+	filter := ImageFilter{JobFilter: jobFilter, Sort: strings.TrimSpace(q.Get("sort"))}
+	if err != nil {
+		return filter, err
+	}
+	if filter.Sort != "" && filter.Sort != "newest" && filter.Sort != "rating" {
+		return filter, errors.New(`sort must be "newest" or "rating"`)
+	}
+	if raw := strings.TrimSpace(q.Get("min_rating")); raw != "" {
+		rating, err := strconv.Atoi(raw)
+		if err != nil || rating < 1 || rating > 5 {
+			return filter, errors.New("min_rating must be between 1 and 5")
+		}
+		filter.MinRating = rating
+	}
+	return filter, nil
+}
+
+// pageURL is the current URL with param set to cursor, or "" without a
+// cursor.
+func pageURL(r *http.Request, param string, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	q := r.URL.Query()
+	q.Set(param, cursor)
+	q.Del("ok")
+	return r.URL.Path + "?" + q.Encode()
+}
+
 func (s *Server) handleJobDetail(w http.ResponseWriter, r *http.Request) {
 	jobID, err := strconv.ParseInt(r.PathValue("jobID"), 10, 64)
 	if err != nil || jobID < 1 {
@@ -362,6 +1164,7 @@ func (s *Server) handleJobDetail(w http.ResponseWriter, r *http.Request) {
 		CurrentPath: "/jobs",
 		Job:         job,
 		WorkImages:  images,
+		Tags:        s.tagOptions(),
 		Flash:       r.URL.Query().Get("ok"),
 	})
 }
@@ -380,6 +1183,107 @@ func (s *Server) handleImageByID(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, imagePath)
 }
 
+// handleImageDownload sends an image as an attachment, converted to the
+// format given in the query if it is one of imageconv.ConvertFormats.
+func (s *Server) handleImageDownload(w http.ResponseWriter, r *http.Request) {
+	imageID, err := strconv.ParseInt(r.PathValue("imageID"), 10, 64)
+	if err != nil || imageID < 1 {
+		http.NotFound(w, r)
+		return
+	}
+	imagePath, err := s.store.ImagePathByID(imageID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	if format == "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(imagePath)))
+		http.ServeFile(w, r, imagePath)
+		return
+	}
+	if !slices.Contains(imageconv.ConvertFormats, format) {
+		http.Error(w, "format must be one of "+strings.Join(imageconv.ConvertFormats, ", "), http.StatusBadRequest)
+		return
+	}
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out, err := imageconv.Convert(data, format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	base := strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+	w.Header().Set("Content-Type", imageconv.MIMEType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", base+"."+format))
+	_, _ = w.Write(out)
+}
+
+func (s *Server) handleImagePrintExport(w http.ResponseWriter, r *http.Request) {
+	imageID, err := strconv.ParseInt(r.PathValue("imageID"), 10, 64)
+	if err != nil || imageID < 1 {
+		http.NotFound(w, r)
+		return
+	}
+	imagePath, err := s.store.ImagePathByID(imageID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	widthIn, err := strconv.ParseFloat(strings.TrimSpace(query.Get("width_in")), 64)
+	if err != nil {
+		http.Error(w, "width_in must be a number", http.StatusBadRequest)
+		return
+	}
+	heightIn, err := strconv.ParseFloat(strings.TrimSpace(query.Get("height_in")), 64)
+	if err != nil {
+		http.Error(w, "height_in must be a number", http.StatusBadRequest)
+		return
+	}
+	dpi := 300
+	if raw := strings.TrimSpace(query.Get("dpi")); raw != "" {
+		dpi, err = strconv.Atoi(raw)
+		if err != nil {
+			http.Error(w, "dpi must be an integer", http.StatusBadRequest)
+			return
+		}
+	}
+	format := strings.ToLower(strings.TrimSpace(query.Get("format")))
+	if format == "" {
+		format = "tiff"
+	}
+
+	data, err := os.ReadFile(imagePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result, err := imageconv.ToPrint(data, imageconv.PrintOptions{
+		WidthInches:  widthIn,
+		HeightInches: heightIn,
+		DPI:          dpi,
+		Format:       format,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, warning := range result.Warnings {
+		s.logger.Printf("print export image %d: %s", imageID, warning)
+		w.Header().Add("X-Print-Warning", warning)
+	}
+
+	base := strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+	filename := fmt.Sprintf("%s-%gx%gin-%ddpi.%s", base, widthIn, heightIn, dpi, format)
+	w.Header().Set("Content-Type", result.MIMEType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	_, _ = w.Write(result.Data)
+}
+
 func (s *Server) handleAPIJobStatus(w http.ResponseWriter, r *http.Request) {
 	jobID, err := strconv.ParseInt(r.PathValue("jobID"), 10, 64)
 	if err != nil || jobID < 1 {
@@ -392,6 +1296,12 @@ func (s *Server) handleAPIJobStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	images, _ := s.store.ListJobImages(jobID)
+	payload := apiJob(job)
+	payload["images"] = images
+	writeJSON(w, http.StatusOK, payload)
+}
+
+func apiJob(job Job) map[string]any {
 	payload := map[string]any{
 		"id":             job.ID,
 		"status":         job.Status,
@@ -399,7 +1309,6 @@ func (s *Server) handleAPIJobStatus(w http.ResponseWriter, r *http.Request) {
 		"project_slug":   job.ProjectSlug,
 		"work_item_slug": job.WorkItemSlug,
 		"created_at":     job.CreatedAt.Format(time.RFC3339Nano),
-		"images":         images,
 	}
 	if job.StartedAt != nil {
 		payload["started_at"] = job.StartedAt.Format(time.RFC3339Nano)
@@ -407,7 +1316,7 @@ func (s *Server) handleAPIJobStatus(w http.ResponseWriter, r *http.Request) {
 	if job.FinishedAt != nil {
 		payload["finished_at"] = job.FinishedAt.Format(time.RFC3339Nano)
 	}
-	writeJSON(w, http.StatusOK, payload)
+	return payload
 }
 
 func (s *Server) renderWorkItemPage(w http.ResponseWriter, r *http.Request, projectSlug string, itemSlug string, renderErr string) {
@@ -421,17 +1330,56 @@ func (s *Server) renderWorkItemPage(w http.ResponseWriter, r *http.Request, proj
 		http.NotFound(w, r)
 		return
 	}
-	images, _ := s.store.ListWorkItemImages(projectSlug, itemSlug, 30)
-	jobs, _ := s.store.ListJobsForWorkItem(projectSlug, itemSlug, 10)
+	imageFilter, err := parseImageFilter(r.URL.Query())
+	if err != nil && renderErr == "" {
+		renderErr = err.Error()
+	}
+	imageFilter.ProjectSlug, imageFilter.WorkItemSlug, imageFilter.Limit = projectSlug, itemSlug, 30
+	images, err := s.store.ListImages(imageFilter)
+	if err != nil && renderErr == "" {
+		renderErr = err.Error()
+	}
+	jobs, _ := s.store.ListJobs(JobFilter{ProjectSlug: projectSlug, WorkItemSlug: itemSlug, Limit: 10})
+	var brand Brand
+	var brandAssets []BrandAsset
+	if brandSlug := cmp.Or(item.BrandOverride, project.DefaultBrandSlug); brandSlug != "" {
+		brand, _ = s.store.GetBrand(brandSlug)
+		if repo, ok := s.store.(BrandAssetRepository); ok {
+			brandAssets, _ = repo.ListBrandAssets(brandSlug)
+		}
+	}
+	var statusChanges []StatusChange
+	if repo, ok := s.store.(StatusRepository); ok {
+		statusChanges, _ = repo.ListStatusChanges(item.ID)
+	}
+	var timeline []PromptTimelineEntry
+	if history, ok := s.store.(PromptHistoryRepository); ok {
+		revisions, _ := history.ListPromptRevisions(projectSlug, itemSlug)
+		for i, rev := range revisions {
+			entry := PromptTimelineEntry{PromptRevision: rev}
+			if i+1 < len(revisions) {
+				entry.Diff = DiffLines(revisions[i+1].Prompt, rev.Prompt)
+			}
+			timeline = append(timeline, entry)
+		}
+	}
 	s.render(w, r, "work-item-detail", PageData{
-		Title:       fmt.Sprintf("Work Item: %s", item.Name),
-		CurrentPath: "/projects",
-		Project:     project,
-		WorkItem:    item,
-		WorkImages:  images,
-		Jobs:        jobs,
-		Flash:       r.URL.Query().Get("ok"),
-		Error:       renderErr,
+		Title:         fmt.Sprintf("Work Item: %s", item.Name),
+		CurrentPath:   "/projects",
+		Project:       project,
+		WorkItem:      item,
+		Brand:         brand,
+		BrandAssets:   brandAssets,
+		WorkImages:    images.Images,
+		Jobs:          jobs.Jobs,
+		Timeline:      timeline,
+		Tags:          s.tagOptions(),
+		ImageFilter:   imageFilter,
+		StatusChanges: statusChanges,
+		NextPageURL:   pageURL(r, "cursor", images.NextCursor),
+		PrevPageURL:   pageURL(r, "cursor", images.PrevCursor),
+		Flash:         r.URL.Query().Get("ok"),
+		Error:         renderErr,
 	})
 }
 
@@ -479,6 +1427,7 @@ func (s *Server) assetPath(key string) string {
 		"project-detail.js":   "/static/dist/project-detail.js",
 		"work-item-detail.js": "/static/dist/work-item-detail.js",
 		"job-detail.js":       "/static/dist/job-detail.js",
+		"compare.js":          "/static/dist/compare.js",
 	}
 	if p, ok := fallback[key]; ok {
 		return p
@@ -493,6 +1442,625 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// handleGC shows a dry-run garbage collection report on GET and deletes the
+// findings on POST.
+func (s *Server) handleGC(w http.ResponseWriter, r *http.Request) {
+	gc, ok := s.store.(GarbageCollector)
+	if !ok {
+		http.Error(w, "garbage collection requires -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	report, err := gc.CollectGarbage(r.Method == http.MethodPost)
+	data := PageData{
+		Title:       "Storage Cleanup",
+		CurrentPath: "/admin/gc",
+		GC:          &report,
+	}
+	if err != nil {
+		data.Error = err.Error()
+	}
+	s.render(w, r, "admin-gc", data)
+}
+
+// handleFsck shows an integrity report on GET and applies the safe repairs
+// on POST, deleting records of missing files only when delete_missing is set.
+func (s *Server) handleFsck(w http.ResponseWriter, r *http.Request) {
+	checker, ok := s.store.(Checker)
+	if !ok {
+		http.Error(w, "integrity checks require -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	report, err := checker.Fsck(FsckOptions{
+		Repair:        r.Method == http.MethodPost,
+		DeleteMissing: r.Method == http.MethodPost && r.FormValue("delete_missing") != "",
+	})
+	data := PageData{
+		Title:       "Integrity Check",
+		CurrentPath: "/admin/fsck",
+		Fsck:        &report,
+	}
+	if err != nil {
+		data.Error = err.Error()
+	}
+	s.render(w, r, "admin-fsck", data)
+}
+
+// tagOptions lists the known tags for autocomplete, or none when the
+// backend has no tags.
+func (s *Server) tagOptions() []Tag {
+	repo, ok := s.store.(TagRepository)
+	if !ok {
+		return nil
+	}
+	tags, _ := repo.ListTags("", 200)
+	return tags
+}
+
+// handleWorkItemTag adds the posted tag to a work item, or removes the tag
+// named in the path.
+func (s *Server) handleWorkItemTag(add bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo, ok := s.store.(TagRepository)
+		if !ok {
+			http.Error(w, "tags require -storage sqlite", http.StatusNotImplemented)
+			return
+		}
+		projectSlug := Slugify(r.PathValue("slug"))
+		itemSlug := Slugify(r.PathValue("itemSlug"))
+		var err error
+		msg := "Tag removed"
+		if add {
+			err = repo.TagWorkItem(projectSlug, itemSlug, r.FormValue("tag"))
+			msg = "Tag added"
+		} else {
+			err = repo.UntagWorkItem(projectSlug, itemSlug, r.PathValue("tag"))
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			s.renderWorkItemPage(w, r, projectSlug, itemSlug, err.Error())
+			return
+		}
+		http.Redirect(w, r, "/projects/"+projectSlug+"/work-items/"+itemSlug+"?ok="+url.QueryEscape(msg), http.StatusSeeOther)
+	}
+}
+
+// handleImageTag adds the posted tag to an image, or removes the tag named in
+// the path, then returns to the referring page on this site.
+func (s *Server) handleImageTag(add bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repo, ok := s.store.(TagRepository)
+		if !ok {
+			http.Error(w, "tags require -storage sqlite", http.StatusNotImplemented)
+			return
+		}
+		imageID, err := strconv.ParseInt(r.PathValue("imageID"), 10, 64)
+		if err != nil || imageID < 1 {
+			http.NotFound(w, r)
+			return
+		}
+		if add {
+			err = repo.TagImage(imageID, r.FormValue("tag"))
+		} else {
+			err = repo.UntagImage(imageID, r.PathValue("tag"))
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, localReferer(r, "/jobs"), http.StatusSeeOther)
+	}
+}
+
+// handleRateImage saves the posted rating (0 to 5) and note for an image.
+// API and fetch requests get the updated image back as JSON; form posts
+// return to the referring page.
+func (s *Server) handleRateImage(w http.ResponseWriter, r *http.Request) {
+	asJSON := strings.HasPrefix(r.URL.Path, "/api/") || wantsJSON(r)
+	fail := func(status int, msg string) {
+		if asJSON {
+			writeJSON(w, status, map[string]any{"error": msg})
+			return
+		}
+		http.Error(w, msg, status)
+	}
+	repo, ok := s.store.(RatingRepository)
+	if !ok {
+		fail(http.StatusNotImplemented, "ratings require -storage sqlite")
+		return
+	}
+	imageID, err := strconv.ParseInt(r.PathValue("imageID"), 10, 64)
+	if err != nil || imageID < 1 {
+		fail(http.StatusNotFound, "image not found")
+		return
+	}
+	var input struct {
+		Rating int    `json:"rating"`
+		Note   string `json:"note"`
+	}
+	if strings.Contains(strings.ToLower(r.Header.Get("Content-Type")), "application/json") {
+		err = json.NewDecoder(r.Body).Decode(&input)
+	} else {
+		input.Note = r.FormValue("note")
+		if raw := strings.TrimSpace(r.FormValue("rating")); raw != "" {
+			input.Rating, err = strconv.Atoi(raw)
+		}
+	}
+	if err != nil {
+		fail(http.StatusBadRequest, "rating must be a number from 0 to 5")
+		return
+	}
+	img, err := repo.RateImage(imageID, input.Rating, input.Note)
+	if errors.Is(err, os.ErrNotExist) {
+		fail(http.StatusNotFound, "image not found")
+		return
+	}
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	if asJSON {
+		writeJSON(w, http.StatusOK, map[string]any{"id": img.ID, "rating": img.Rating, "note": img.Note})
+		return
+	}
+	http.Redirect(w, r, localReferer(r, "/jobs"), http.StatusSeeOther)
+}
+
+func (s *Server) handleAPIModelRatings(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.store.(RatingRepository)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]any{"error": "ratings require -storage sqlite"})
+		return
+	}
+	ratings, err := repo.ModelRatings(r.PathValue("slug"))
+	if errors.Is(err, os.ErrNotExist) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "project not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"ratings": ratings})
+}
+
+func (s *Server) handleCompare(w http.ResponseWriter, r *http.Request) {
+	comparer, ok := s.store.(Comparer)
+	if !ok {
+		http.Error(w, "comparisons require -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	project, err := s.store.GetProject(r.PathValue("slug"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	item, err := s.store.GetWorkItem(project.Slug, r.PathValue("itemSlug"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var skip []int64
+	for _, raw := range strings.Split(r.URL.Query().Get("skip"), ",") {
+		if id, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			skip = append(skip, id)
+		}
+	}
+	data := PageData{
+		Title:       fmt.Sprintf("Compare: %s", item.Name),
+		CurrentPath: "/projects",
+		Project:     project,
+		WorkItem:    item,
+		Flash:       r.URL.Query().Get("ok"),
+	}
+	pair, ok, err := comparer.NextComparison(item.ID, skip...)
+	if err != nil {
+		data.Error = err.Error()
+	}
+	if ok {
+		data.Comparison = &pair
+	}
+	data.Leaderboard, _ = comparer.Leaderboard(item.ID)
+	s.render(w, r, "compare", data)
+}
+
+func (s *Server) handleRecordComparison(w http.ResponseWriter, r *http.Request) {
+	comparer, ok := s.store.(Comparer)
+	if !ok {
+		http.Error(w, "comparisons require -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	projectSlug := Slugify(r.PathValue("slug"))
+	itemSlug := Slugify(r.PathValue("itemSlug"))
+	item, err := s.store.GetWorkItem(projectSlug, itemSlug)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	winnerID, _ := strconv.ParseInt(r.FormValue("winner"), 10, 64)
+	loserID, _ := strconv.ParseInt(r.FormValue("loser"), 10, 64)
+	if _, err := comparer.RecordComparison(item.ID, winnerID, loserID); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = errors.New("both images must belong to this work item")
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/projects/"+projectSlug+"/work-items/"+itemSlug+"/compare?ok=Pick+saved", http.StatusSeeOther)
+}
+
+// handleAPICompare returns the next pair to compare and the leaderboard of a
+// work item; a POST with winner_id and loser_id records a pick first.
+func (s *Server) handleAPICompare(w http.ResponseWriter, r *http.Request) {
+	comparer, ok := s.store.(Comparer)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]any{"error": "comparisons require -storage sqlite"})
+		return
+	}
+	workItemID, err := strconv.ParseInt(r.PathValue("workItemID"), 10, 64)
+	if err != nil || workItemID < 1 {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "work item not found"})
+		return
+	}
+	resp := map[string]any{}
+	if r.Method == http.MethodPost {
+		var input struct {
+			WinnerID int64 `json:"winner_id"`
+			LoserID  int64 `json:"loser_id"`
+		}
+		if strings.Contains(strings.ToLower(r.Header.Get("Content-Type")), "application/json") {
+			err = json.NewDecoder(r.Body).Decode(&input)
+		} else {
+			input.WinnerID, _ = strconv.ParseInt(r.FormValue("winner_id"), 10, 64)
+			input.LoserID, _ = strconv.ParseInt(r.FormValue("loser_id"), 10, 64)
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid JSON body"})
+			return
+		}
+		comparison, err := comparer.RecordComparison(workItemID, input.WinnerID, input.LoserID)
+		if errors.Is(err, os.ErrNotExist) {
+			writeJSON(w, http.StatusNotFound, map[string]any{"error": "both images must belong to this work item"})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+			return
+		}
+		resp["comparison"] = map[string]any{
+			"id":         comparison.ID,
+			"winner_id":  comparison.WinnerID,
+			"loser_id":   comparison.LoserID,
+			"created_at": comparison.CreatedAt.Format(time.RFC3339Nano),
+		}
+	}
+	pair, ok, err := comparer.NextComparison(workItemID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	resp["pair"] = nil
+	if ok {
+		resp["pair"] = []map[string]any{apiRankedImage(pair.Left), apiRankedImage(pair.Right)}
+	}
+	board, err := comparer.Leaderboard(workItemID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	ranked := make([]map[string]any, 0, len(board))
+	for _, img := range board {
+		ranked = append(ranked, apiRankedImage(img))
+	}
+	resp["leaderboard"] = ranked
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func apiRankedImage(img RankedImage) map[string]any {
+	return map[string]any{
+		"id":     img.ID,
+		"name":   img.Name,
+		"url":    img.URL,
+		"elo":    math.Round(img.Elo*10) / 10,
+		"wins":   img.Wins,
+		"losses": img.Losses,
+		"rating": img.Rating,
+	}
+}
+
+// handleFinalImage serves the current final of a work item. The URL stays
+// the same when the pick changes, so it is never cached without revalidating.
+func (s *Server) handleFinalImage(w http.ResponseWriter, r *http.Request) {
+	finalizer, ok := s.store.(Finalizer)
+	if !ok {
+		http.Error(w, "finals require -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	finalPath, err := finalizer.FinalPath(r.PathValue("slug"), r.PathValue("itemSlug"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, finalPath)
+}
+
+func (s *Server) handleFinalize(w http.ResponseWriter, r *http.Request) {
+	finalizer, ok := s.store.(Finalizer)
+	if !ok {
+		http.Error(w, "finals require -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	projectSlug := Slugify(r.PathValue("slug"))
+	itemSlug := Slugify(r.PathValue("itemSlug"))
+	item, err := s.store.GetWorkItem(projectSlug, itemSlug)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	imageID, _ := strconv.ParseInt(r.FormValue("image_id"), 10, 64)
+	if _, err := finalizer.FinalizeImage(item.ID, imageID, r.FormValue("finalized_by")); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = errors.New("the image must belong to this work item and still be on disk")
+		}
+		s.renderWorkItemPage(w, r, projectSlug, itemSlug, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/projects/"+projectSlug+"/work-items/"+itemSlug+"?ok=Final+saved", http.StatusSeeOther)
+}
+
+func (s *Server) handleUnfinalize(w http.ResponseWriter, r *http.Request) {
+	finalizer, ok := s.store.(Finalizer)
+	if !ok {
+		http.Error(w, "finals require -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	projectSlug := Slugify(r.PathValue("slug"))
+	itemSlug := Slugify(r.PathValue("itemSlug"))
+	item, err := s.store.GetWorkItem(projectSlug, itemSlug)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := finalizer.Unfinalize(item.ID); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.renderWorkItemPage(w, r, projectSlug, itemSlug, err.Error())
+		return
+	}
+	http.Redirect(w, r, "/projects/"+projectSlug+"/work-items/"+itemSlug+"?ok=Final+cleared", http.StatusSeeOther)
+}
+
+// handleSetWorkItemStatus moves a work item to the posted status and returns
+// to the referring page, which is the board or the work item.
+func (s *Server) handleSetWorkItemStatus(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.store.(StatusRepository)
+	if !ok {
+		http.Error(w, "work item status requires -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	projectSlug := Slugify(r.PathValue("slug"))
+	itemSlug := Slugify(r.PathValue("itemSlug"))
+	item, err := s.store.GetWorkItem(projectSlug, itemSlug)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := repo.SetWorkItemStatus(item.ID, r.FormValue("status"), r.FormValue("note")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, localReferer(r, "/projects/"+projectSlug+"/work-items/"+itemSlug), http.StatusSeeOther)
+}
+
+// handleAPIWorkItemStatus returns a work item's status, the statuses it can
+// move to and its history; a POST with status (and an optional note) moves
+// it first.
+func (s *Server) handleAPIWorkItemStatus(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.store.(StatusRepository)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]any{"error": "work item status requires -storage sqlite"})
+		return
+	}
+	workItemID, err := strconv.ParseInt(r.PathValue("workItemID"), 10, 64)
+	if err != nil || workItemID < 1 {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "work item not found"})
+		return
+	}
+	if r.Method == http.MethodPost {
+		var input struct {
+			Status string `json:"status"`
+			Note   string `json:"note"`
+		}
+		if strings.Contains(strings.ToLower(r.Header.Get("Content-Type")), "application/json") {
+			err = json.NewDecoder(r.Body).Decode(&input)
+		} else {
+			input.Status, input.Note = r.FormValue("status"), r.FormValue("note")
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid JSON body"})
+			return
+		}
+		_, err = repo.SetWorkItemStatus(workItemID, input.Status, input.Note)
+		if errors.Is(err, os.ErrNotExist) {
+			writeJSON(w, http.StatusNotFound, map[string]any{"error": "work item not found"})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusConflict, map[string]any{"error": err.Error()})
+			return
+		}
+	}
+	changes, err := repo.ListStatusChanges(workItemID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	history := make([]map[string]any, 0, len(changes))
+	for _, c := range changes {
+		history = append(history, map[string]any{
+			"from":       c.From,
+			"to":         c.To,
+			"note":       c.Note,
+			"created_at": c.CreatedAt.Format(time.RFC3339Nano),
+		})
+	}
+	status, err := repo.WorkItemStatus(workItemID)
+	if errors.Is(err, os.ErrNotExist) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "work item not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":  status,
+		"next":    statusTransitions[status],
+		"history": history,
+	})
+}
+
+// handleAPIFinalize marks the posted image_id as the work item's final, or
+// clears the final on DELETE.
+func (s *Server) handleAPIFinalize(w http.ResponseWriter, r *http.Request) {
+	finalizer, ok := s.store.(Finalizer)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]any{"error": "finals require -storage sqlite"})
+		return
+	}
+	workItemID, err := strconv.ParseInt(r.PathValue("workItemID"), 10, 64)
+	if err != nil || workItemID < 1 {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "work item not found"})
+		return
+	}
+	if r.Method == http.MethodDelete {
+		err := finalizer.Unfinalize(workItemID)
+		if errors.Is(err, os.ErrNotExist) {
+			writeJSON(w, http.StatusNotFound, map[string]any{"error": "work item has no final"})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"final": nil})
+		return
+	}
+	var input struct {
+		ImageID     int64  `json:"image_id"`
+		FinalizedBy string `json:"finalized_by"`
+	}
+	if strings.Contains(strings.ToLower(r.Header.Get("Content-Type")), "application/json") {
+		err = json.NewDecoder(r.Body).Decode(&input)
+	} else {
+		input.ImageID, _ = strconv.ParseInt(r.FormValue("image_id"), 10, 64)
+		input.FinalizedBy = r.FormValue("finalized_by")
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid JSON body"})
+		return
+	}
+	final, err := finalizer.FinalizeImage(workItemID, input.ImageID, input.FinalizedBy)
+	if errors.Is(err, os.ErrNotExist) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "image not found in this work item"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"final": map[string]any{
+		"work_item_id": final.WorkItemID,
+		"image_id":     final.ImageID,
+		"filename":     final.Filename,
+		"path":         filepath.ToSlash(final.RelPath),
+		"finalized_by": final.FinalizedBy,
+		"finalized_at": final.FinalizedAt.Format(time.RFC3339Nano),
+	}})
+}
+
+func (s *Server) handleAPITags(w http.ResponseWriter, r *http.Request) {
+	repo, ok := s.store.(TagRepository)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]any{"error": "tags require -storage sqlite"})
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	tags, err := repo.ListTags(r.URL.Query().Get("q"), min(max(limit, 0), 200))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tags": tags})
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	searcher, ok := s.store.(Searcher)
+	if !ok {
+		http.Error(w, "search requires -storage sqlite", http.StatusNotImplemented)
+		return
+	}
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	data := PageData{
+		Title:       "Search",
+		CurrentPath: "/search",
+		SearchQuery: query,
+	}
+	if query != "" {
+		results, err := searcher.Search(query, 50)
+		if err != nil {
+			data.Error = err.Error()
+		}
+		data.SearchResults = results
+	}
+	s.render(w, r, "search", data)
+}
+
+func (s *Server) handleAPISearch(w http.ResponseWriter, r *http.Request) {
+	searcher, ok := s.store.(Searcher)
+	if !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]any{"error": "search requires -storage sqlite"})
+		return
+	}
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	results, err := searcher.Search(query, min(max(limit, 0), 200))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"query": query, "results": results})
+}
+
+// StartGarbageCollector deletes orphaned image files and empty directories
+// every interval.
+func (s *Server) StartGarbageCollector(interval time.Duration) {
+	gc, ok := s.store.(GarbageCollector)
+	if !ok || interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			report, err := gc.CollectGarbage(true)
+			if err != nil {
+				s.logger.Printf("scheduled gc failed: %v", err)
+				continue
+			}
+			if !report.Clean() {
+				s.logger.Printf("scheduled gc: removed %d orphan files (%s) and %d empty dirs; %d image rows point at missing files",
+					len(report.OrphanFiles), formatBytes(report.ReclaimableBytes), len(report.EmptyDirs), len(report.MissingFiles))
+			}
+		}
+	}()
+}
+
 func (s *Server) jobWorkerLoop() {
 	ticker := time.NewTicker(2 * time.Second)
 	defer ticker.Stop()
@@ -526,13 +2094,19 @@ func (s *Server) processNextJob() {
 		payload.ImageSize = "1K"
 	}
 
-	runPrompt := strings.TrimSpace(job.Prompt)
-	if strings.TrimSpace(payload.Adjustment) != "" {
-		runPrompt = runPrompt + "\n\nAdjustments:\n" + strings.TrimSpace(payload.Adjustment)
+	brandSpec, brandBody, _ := ParseBrand(job.BrandContent)
+	constraints := job.Constraints.WithBrand(brandSpec)
+	if payload.AspectRatio == "" {
+		payload.AspectRatio = constraints.AspectRatio
 	}
+	// The prompt states the ratio the generator is asked for, which may
+	// differ from the work item's when the job overrides it.
+	constraints.AspectRatio = payload.AspectRatio
+	runPrompt := AssemblePrompt(job.Prompt, constraints, payload.Adjustment)
 
-	runSettingsJSON, _ := json.Marshal(payload)
-	runID, err := s.store.CreateRun(job.JobID, job.WorkItemID, runPrompt, string(runSettingsJSON))
+	settings := RunSettings{GenerateJobPayload: payload}
+	runSettingsJSON, _ := json.Marshal(settings)
+	runID, err := s.store.CreateRun(job, runPrompt, string(runSettingsJSON))
 	if err != nil {
 		s.logger.Printf("create run failed for job %d: %v", job.JobID, err)
 		_ = s.store.MarkJobFailed(job.JobID, err.Error())
@@ -558,6 +2132,23 @@ func (s *Server) processNextJob() {
 	if payload.AspectRatio != "" {
 		args = append(args, "-aspect-ratio", payload.AspectRatio)
 	}
+	if len(payload.ReferenceAssetIDs) > 0 {
+		repo, ok := s.store.(BrandAssetRepository)
+		for _, id := range payload.ReferenceAssetIDs {
+			var path string
+			err := errors.New("brand assets require -storage sqlite")
+			if ok {
+				_, path, err = repo.BrandAssetPath(job.BrandSlug, id)
+			}
+			if err != nil {
+				msg := fmt.Sprintf("reference image #%d is no longer available: %v", id, err)
+				_ = s.store.MarkRunFailed(runID, msg)
+				_ = s.store.MarkJobFailed(job.JobID, msg)
+				return
+			}
+			args = append(args, "-reference-image", path)
+		}
+	}
 
 	var cleanup []func()
 	if strings.TrimSpace(job.BrandContent) != "" {
@@ -569,7 +2160,7 @@ func (s *Server) processNextJob() {
 		}
 		cleanup = append(cleanup, func() { _ = os.RemoveAll(brandDir) })
 		brandFile := filepath.Join(brandDir, "BRAND.md")
-		if writeErr := os.WriteFile(brandFile, []byte(job.BrandContent), 0o644); writeErr != nil {
+		if writeErr := os.WriteFile(brandFile, []byte(brandSpec.Guidelines(brandBody)+"\n"), 0o644); writeErr != nil {
 			_ = s.store.MarkRunFailed(runID, writeErr.Error())
 			_ = s.store.MarkJobFailed(job.JobID, writeErr.Error())
 			for _, fn := range cleanup {
@@ -580,7 +2171,7 @@ func (s *Server) processNextJob() {
 		args = append(args, "-brand-dir", brandDir)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), generateTimeout)
 	defer cancel()
 	cmd := exec.CommandContext(ctx, s.generatorBinaryPath(), args...)
 	cmd.Env = os.Environ()
@@ -602,13 +2193,14 @@ func (s *Server) processNextJob() {
 		_ = s.store.MarkJobFailed(job.JobID, readErr.Error())
 		return
 	}
+	var outputs []runOutput
 	for _, f := range files {
 		if f.IsDir() {
 			continue
 		}
 		name := f.Name()
 		ext := strings.ToLower(filepath.Ext(name))
-		if ext != ".png" && ext != ".jpg" && ext != ".jpeg" && ext != ".webp" && ext != ".ico" {
+		if !isImageExt(ext) {
 			continue
 		}
 		abs := filepath.Join(outputDir, name)
@@ -616,7 +2208,56 @@ func (s *Server) processNextJob() {
 		if err != nil {
 			continue
 		}
-		_ = s.store.AddRunImage(runID, name, rel, strings.TrimPrefix(ext, "."))
+		outputs = append(outputs, runOutput{name: name, abs: abs, rel: rel, format: strings.TrimPrefix(ext, "."), model: imageModel(payload.Model, name)})
+	}
+	// Fit every image before recording any, so a file that cannot be fitted
+	// fails the run without leaving some of its images recorded. Only the
+	// fitted files become candidates; the originals stay on disk and are
+	// listed in the run settings.
+	if payload.MaxBytes > 0 {
+		var fitted []runOutput
+		for _, out := range outputs {
+			fit, result, fitErr := s.fitToMaxBytes(out, payload.OutputFormat, payload.MaxBytes)
+			if fitErr != nil {
+				for _, f := range fitted {
+					_ = os.Remove(f.abs)
+				}
+				msg := fmt.Sprintf("fit %s under %d bytes: %v", out.name, payload.MaxBytes, fitErr)
+				_ = s.store.MarkRunFailed(runID, msg)
+				_ = s.store.MarkJobFailed(job.JobID, msg)
+				return
+			}
+			fitted = append(fitted, fit)
+			settings.Encodes = append(settings.Encodes, result)
+		}
+		outputs = fitted
+	}
+	// An image that cannot be read or recorded fails the run rather than
+	// being left on disk without a record for GC to remove.
+	metas := make([]ImageMeta, len(outputs))
+	for i, out := range outputs {
+		meta, err := readImageMeta(out.abs)
+		if err != nil {
+			msg := fmt.Sprintf("read %s: %v", out.name, err)
+			_ = s.store.MarkRunFailed(runID, msg)
+			_ = s.store.MarkJobFailed(job.JobID, msg)
+			s.logger.Printf("job %d failed: %s", job.JobID, msg)
+			return
+		}
+		metas[i] = meta
+	}
+	for i, out := range outputs {
+		if err := s.store.AddRunImage(runID, out.name, out.rel, out.format, out.model, metas[i]); err != nil {
+			msg := fmt.Sprintf("record %s: %v", out.name, err)
+			_ = s.store.MarkRunFailed(runID, msg)
+			_ = s.store.MarkJobFailed(job.JobID, msg)
+			s.logger.Printf("job %d failed: %s", job.JobID, msg)
+			return
+		}
+	}
+	if len(settings.Encodes) > 0 {
+		updatedJSON, _ := json.Marshal(settings)
+		_ = s.store.UpdateRunSettings(runID, string(updatedJSON))
 	}
 
 	_ = s.store.MarkRunSucceeded(runID)
@@ -624,6 +2265,59 @@ func (s *Server) processNextJob() {
 	s.logger.Printf("job %d succeeded", job.JobID)
 }
 
+// generateTimeout bounds a single run of the generator CLI.
+const generateTimeout = 8 * time.Minute
+
+// runOutput is an image file written for a run.
+type runOutput struct {
+	name, abs, rel, format, model string
+}
+
+// fitToMaxBytes re-encodes out under maxBytes and writes the result next to
+// it as <name>-fit.<format>, keeping the original.
+func (s *Server) fitToMaxBytes(out runOutput, format string, maxBytes int) (runOutput, RunEncodeResult, error) {
+	data, err := os.ReadFile(out.abs)
+	if err != nil {
+		return runOutput{}, RunEncodeResult{}, err
+	}
+	var result imageconv.BudgetResult
+	switch format {
+	case "jpg":
+		result, err = imageconv.ToJPGWithMaxBytes(data, maxBytes)
+	case "webp":
+		result, err = imageconv.ToWEBPWithMaxBytes(data, maxBytes)
+	default:
+		return runOutput{}, RunEncodeResult{}, fmt.Errorf("max bytes is not supported for %q output", format)
+	}
+	if err != nil {
+		return runOutput{}, RunEncodeResult{}, err
+	}
+	name := strings.TrimSuffix(out.name, filepath.Ext(out.name)) + "-fit." + format
+	fit := runOutput{name: name, abs: filepath.Join(filepath.Dir(out.abs), name), format: format, model: out.model}
+	if fit.rel, err = s.store.RelPath(fit.abs); err != nil {
+		return runOutput{}, RunEncodeResult{}, err
+	}
+	if err := os.WriteFile(fit.abs, result.Data, 0o644); err != nil {
+		return runOutput{}, RunEncodeResult{}, err
+	}
+	return fit, RunEncodeResult{
+		Filename: name,
+		Original: out.name,
+		Quality:  result.Quality,
+		Width:    result.Width,
+		Height:   result.Height,
+		Bytes:    len(result.Data),
+	}, nil
+}
+
+func isImageExt(ext string) bool {
+	switch ext {
+	case ".png", ".jpg", ".jpeg", ".webp", ".ico", ".tif", ".tiff", ".bmp", ".gif":
+		return true
+	}
+	return false
+}
+
 func (s *Server) generatorBinaryPath() string {
 	if _, err := os.Stat("./imagegen"); err == nil {
 		return "./imagegen"
@@ -645,6 +2339,32 @@ func loadTemplates(root string) (*template.Template, error) {
 			}
 			return t.Local().Format("2006-01-02 15:04:05")
 		},
+		"fmtBytes": formatBytes,
+		"fmtDate": func(t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.Local().Format(time.DateOnly)
+		},
+		"statusLabel":    StatusLabel,
+		"assemblePrompt": AssemblePrompt,
+		"join":           strings.Join,
+		"upper":          strings.ToUpper,
+		"constraintModels": func() []string {
+			return ConstraintModels
+		},
+		"constraintFormats": func() []string {
+			return ConstraintFormats
+		},
+		"constraintSizes": func() []string {
+			return ConstraintSizes
+		},
+		"constraintAspectRatios": func() []string {
+			return ConstraintAspectRatios
+		},
+		"dec": func(n int) int {
+			return n - 1
+		},
 		"fmtTimePtr": func(t *time.Time) string {
 			if t == nil || t.IsZero() {
 				return "-"
@@ -672,6 +2392,16 @@ func loadTemplates(root string) (*template.Template, error) {
 	return tmpl, nil
 }
 
+// localReferer is the path and query of the referring page, or fallback when
+// there is none, so redirects never leave this site.
+func localReferer(r *http.Request, fallback string) string {
+	ref, err := url.Parse(r.Referer())
+	if err != nil || !strings.HasPrefix(ref.Path, "/") || strings.HasPrefix(ref.Path, "//") {
+		return fallback
+	}
+	return (&url.URL{Path: ref.Path, RawQuery: ref.RawQuery}).String()
+}
+
 func wantsJSON(r *http.Request) bool {
 	accept := strings.ToLower(r.Header.Get("Accept"))
 	contentType := strings.ToLower(r.Header.Get("Content-Type"))
@@ -0,0 +1,166 @@
+package webapp
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	StatusDraft      = "draft"
+	StatusGenerating = "generating"
+	StatusInReview   = "in_review"
+	StatusApproved   = "approved"
+	StatusDelivered  = "delivered"
+)
+
+// WorkItemStatuses lists the work item statuses in board order.
+var WorkItemStatuses = []string{StatusDraft, StatusGenerating, StatusInReview, StatusApproved, StatusDelivered}
+
+// statusTransitions lists the statuses a work item may be moved to by hand
+// from each status.
+var statusTransitions = map[string][]string{
+	StatusDraft:      {StatusGenerating, StatusInReview},
+	StatusGenerating: {StatusDraft, StatusInReview},
+	StatusInReview:   {StatusDraft, StatusGenerating, StatusApproved},
+	StatusApproved:   {StatusInReview, StatusGenerating, StatusDelivered},
+	StatusDelivered:  {StatusApproved, StatusInReview},
+}
+
+// StatusLabel is the display name of a status: "in_review" is "In review".
+func StatusLabel(status string) string {
+	label := strings.ReplaceAll(status, "_", " ")
+	if label == "" {
+		return ""
+	}
+	return strings.ToUpper(label[:1]) + label[1:]
+}
+
+// NextStatuses lists the statuses the work item can be moved to.
+func (w WorkItem) NextStatuses() []string {
+	return statusTransitions[w.Status]
+}
+
+// GroupByStatus sorts work items into one board column per status, keeping
+// their order within each column.
+func GroupByStatus(items []WorkItem) []BoardColumn {
+	columns := make([]BoardColumn, len(WorkItemStatuses))
+	for i, status := range WorkItemStatuses {
+		columns[i].Status = status
+	}
+	for _, item := range items {
+		i := slices.Index(WorkItemStatuses, item.Status)
+		if i < 0 {
+			i = 0
+		}
+		columns[i].Items = append(columns[i].Items, item)
+	}
+	return columns
+}
+
+func (s *Store) WorkItemStatus(workItemID int64) (string, error) {
+	var status string
+	err := s.db.QueryRow(`SELECT status FROM work_items WHERE id = ?;`, workItemID).Scan(&status)
+	return status, notFound(err)
+}
+
+// SetWorkItemStatus moves a work item to status if the transition is
+// allowed and records the change with note.
+func (s *Store) SetWorkItemStatus(workItemID int64, status string, note string) (StatusChange, error) {
+	var change StatusChange
+	err := s.withTx(func(tx *sql.Tx) error {
+		var from string
+		if err := tx.QueryRow(`SELECT status FROM work_items WHERE id = ?;`, workItemID).Scan(&from); err != nil {
+			return notFound(err)
+		}
+		if !slices.Contains(WorkItemStatuses, status) {
+			return fmt.Errorf("unknown status %q", status)
+		}
+		if !slices.Contains(statusTransitions[from], status) {
+			return fmt.Errorf("cannot move a work item from %s to %s", StatusLabel(from), StatusLabel(status))
+		}
+		var err error
+		change, err = changeStatus(tx, workItemID, from, status, strings.TrimSpace(note))
+		return err
+	})
+	return change, err
+}
+
+// ListStatusChanges returns a work item's status history, newest first.
+func (s *Store) ListStatusChanges(workItemID int64) ([]StatusChange, error) {
+	rows, err := s.db.Query(`
+		SELECT id, work_item_id, from_status, to_status, note, created_at
+		FROM work_item_status_changes
+		WHERE work_item_id = ?
+		ORDER BY id DESC;
+	`, workItemID)
+	if err != nil {
+		return nil, err
+	}
+	return collectRows(rows, func(sc rowScanner) (StatusChange, error) {
+		var c StatusChange
+		var created string
+		err := sc.Scan(&c.ID, &c.WorkItemID, &c.From, &c.To, &c.Note, &created)
+		c.CreatedAt = parseTime(created)
+		return c, err
+	})
+}
+
+// autoStatus moves a work item to status when it currently has one of the
+// from statuses. The store calls it as jobs and finals change, so automatic
+// moves skip the hand transition rules.
+func autoStatus(tx *sql.Tx, workItemID int64, status string, note string, from ...string) error {
+	var current string
+	if err := tx.QueryRow(`SELECT status FROM work_items WHERE id = ?;`, workItemID).Scan(&current); err != nil {
+		return notFound(err)
+	}
+	if !slices.Contains(from, current) {
+		return nil
+	}
+	_, err := changeStatus(tx, workItemID, current, status, note)
+	return err
+}
+
+// settleJobStatus moves a generating work item on once its last queued or
+// running job has finished: to review if it has images, else back to draft.
+func settleJobStatus(tx *sql.Tx, jobID int64) error {
+	var workItemID int64
+	var busy, images bool
+	err := tx.QueryRow(`
+		SELECT j.work_item_id,
+		       EXISTS (SELECT 1 FROM jobs o WHERE o.work_item_id = j.work_item_id AND o.status IN ('queued', 'running')),
+		       EXISTS (SELECT 1 FROM runs r JOIN run_images ri ON ri.run_id = r.id WHERE r.work_item_id = j.work_item_id)
+		FROM jobs j
+		WHERE j.id = ?;
+	`, jobID).Scan(&workItemID, &busy, &images)
+	if errors.Is(err, sql.ErrNoRows) || busy {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if images {
+		return autoStatus(tx, workItemID, StatusInReview, fmt.Sprintf("job #%d finished", jobID), StatusGenerating)
+	}
+	return autoStatus(tx, workItemID, StatusDraft, fmt.Sprintf("job #%d finished without images", jobID), StatusGenerating)
+}
+
+func changeStatus(tx *sql.Tx, workItemID int64, from string, to string, note string) (StatusChange, error) {
+	change := StatusChange{WorkItemID: workItemID, From: from, To: to, Note: note}
+	if from == to {
+		return change, nil
+	}
+	now := nowText()
+	change.CreatedAt = parseTime(now)
+	if _, err := tx.Exec(`UPDATE work_items SET status = ?, updated_at = ? WHERE id = ?;`, to, now, workItemID); err != nil {
+		return change, err
+	}
+	err := tx.QueryRow(`
+		INSERT INTO work_item_status_changes (work_item_id, from_status, to_status, note, created_at)
+		VALUES (?, ?, ?, ?, ?)
+		RETURNING id;
+	`, workItemID, from, to, note, now).Scan(&change.ID)
+	return change, err
+}
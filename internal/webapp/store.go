@@ -1,39 +1,82 @@
 package webapp
 
 import (
+	"cmp"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
-	"sync"
 	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
 var slugSanitizePattern = regexp.MustCompile(`[^a-z0-9]+`)
 
+const timestampLayout = "2006-01-02T15:04:05.000Z"
+
 type Store struct {
 	Root   string
 	DBPath string
-	mu     sync.Mutex
+	db     *sql.DB
 }
 
 func NewStore(root string) (*Store, error) {
+	s, err := OpenStore(root)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.MigrateUp(0); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenStore opens the database without applying pending migrations.
+func OpenStore(root string) (*Store, error) {
 	if err := os.MkdirAll(root, 0o755); err != nil {
 		return nil, err
 	}
-	s := &Store{
+	dbPath := filepath.Join(root, "imagegen.db")
+	db, err := openDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
 		Root:   root,
-		DBPath: filepath.Join(root, "imagegen.db"),
+		DBPath: dbPath,
+		db:     db,
+	}, nil
+}
+
+func openDB(path string) (*sql.DB, error) {
+	dsn := "file:" + path +
+		"?_pragma=foreign_keys(1)" +
+		"&_pragma=busy_timeout(5000)" +
+		"&_pragma=journal_mode(WAL)" +
+		"&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
 	}
-	if err := s.runMigrations(); err != nil {
+	db.SetMaxOpenConns(8)
+	db.SetMaxIdleConns(8)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
 		return nil, err
 	}
-	return s, nil
+	return db, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
 }
 
 func Slugify(input string) string {
@@ -48,12 +91,23 @@ func (s *Store) CreateBrand(name string, content string) (Brand, error) {
 	if slug == "" {
 		return Brand{}, errors.New("brand name is required")
 	}
-	err := s.execSQL(fmt.Sprintf(`
-		INSERT INTO brands (name, slug, content, created_at, updated_at)
-		VALUES (%s, %s, %s, %s, %s);
-	`, q(strings.TrimSpace(name)), q(slug), q(strings.TrimSpace(content)), nowExpr(), nowExpr()))
+	if _, _, err := ParseBrand(content); err != nil {
+		return Brand{}, err
+	}
+	err := s.withTx(func(tx *sql.Tx) error {
+		now := nowText()
+		var brandID int64
+		if err := tx.QueryRow(`
+			INSERT INTO brands (name, slug, content, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?)
+			RETURNING id;
+		`, strings.TrimSpace(name), slug, strings.TrimSpace(content), now, now).Scan(&brandID); err != nil {
+			return err
+		}
+		return insertBrandVersion(tx, brandID, strings.TrimSpace(content), "", "")
+	})
 	if err != nil {
-		if strings.Contains(strings.ToLower(err.Error()), "unique") {
+		if isUniqueViolation(err) {
 			return Brand{}, fmt.Errorf("brand %q already exists", slug)
 		}
 		return Brand{}, err
@@ -61,51 +115,119 @@ func (s *Store) CreateBrand(name string, content string) (Brand, error) {
 	return s.GetBrand(slug)
 }
 
-func (s *Store) UpdateBrand(slug string, content string) (Brand, error) {
+// UpdateBrand saves content and records it as a new brand version.
+func (s *Store) UpdateBrand(slug string, content string, author string) (Brand, error) {
+	return s.saveBrandVersion(slug, strings.TrimSpace(content), author, "")
+}
+
+func (s *Store) ListBrandVersions(slug string) ([]BrandVersion, error) {
+	rows, err := s.db.Query(`
+		SELECT v.id, v.brand_id, v.version, v.content, v.author, v.note, v.created_at
+		FROM brand_versions v
+		JOIN brands b ON b.id = v.brand_id
+		WHERE b.slug = ?
+		ORDER BY v.version DESC;
+	`, Slugify(slug))
+	if err != nil {
+		return nil, err
+	}
+	return collectRows(rows, scanBrandVersion)
+}
+
+func (s *Store) GetBrandVersion(slug string, version int) (BrandVersion, error) {
+	row := s.db.QueryRow(`
+		SELECT v.id, v.brand_id, v.version, v.content, v.author, v.note, v.created_at
+		FROM brand_versions v
+		JOIN brands b ON b.id = v.brand_id
+		WHERE b.slug = ? AND v.version = ?;
+	`, Slugify(slug), version)
+	v, err := scanBrandVersion(row)
+	if err != nil {
+		return BrandVersion{}, notFound(err)
+	}
+	return v, nil
+}
+
+// RevertBrand restores the content of an earlier version. History is kept
+// linear: the restored text is saved as a new version.
+func (s *Store) RevertBrand(slug string, version int, author string) (Brand, error) {
+	v, err := s.GetBrandVersion(slug, version)
+	if err != nil {
+		return Brand{}, err
+	}
+	return s.saveBrandVersion(slug, v.Content, author, fmt.Sprintf("revert to v%d", version))
+}
+
+func (s *Store) saveBrandVersion(slug string, content string, author string, note string) (Brand, error) {
 	slug = Slugify(slug)
 	if slug == "" {
 		return Brand{}, errors.New("brand slug is required")
 	}
-	if err := s.execSQL(fmt.Sprintf(`
-		UPDATE brands SET content = %s, updated_at = %s WHERE slug = %s;
-	`, q(strings.TrimSpace(content)), nowExpr(), q(slug))); err != nil {
+	if _, _, err := ParseBrand(content); err != nil {
+		return Brand{}, err
+	}
+	err := s.withTx(func(tx *sql.Tx) error {
+		var brandID int64
+		err := tx.QueryRow(`
+			UPDATE brands SET content = ?, updated_at = ? WHERE slug = ?
+			RETURNING id;
+		`, content, nowText(), slug).Scan(&brandID)
+		if err != nil {
+			return notFound(err)
+		}
+		return insertBrandVersion(tx, brandID, content, author, note)
+	})
+	if err != nil {
 		return Brand{}, err
 	}
 	return s.GetBrand(slug)
 }
 
+func insertBrandVersion(tx *sql.Tx, brandID int64, content string, author string, note string) error {
+	_, err := tx.Exec(`
+		INSERT INTO brand_versions (brand_id, version, content, author, note, created_at)
+		SELECT ?, COALESCE(MAX(version), 0) + 1, ?, ?, ?, ?
+		FROM brand_versions WHERE brand_id = ?;
+	`, brandID, content, strings.TrimSpace(author), note, nowText(), brandID)
+	return err
+}
+
+const brandVersionSQL = `COALESCE((SELECT MAX(version) FROM brand_versions v WHERE v.brand_id = brands.id), 0)`
+
 func (s *Store) GetBrand(slug string) (Brand, error) {
-	slug = Slugify(slug)
-	rows := []brandRow{}
-	err := s.queryJSON(fmt.Sprintf(`
-		SELECT id, name, slug, content, created_at, updated_at
+	row := s.db.QueryRow(`
+		SELECT id, name, slug, content, `+brandVersionSQL+`, created_at, updated_at, archived_at
 		FROM brands
-		WHERE slug = %s
+		WHERE slug = ?
 		LIMIT 1;
-	`, q(slug)), &rows)
+	`, Slugify(slug))
+	brand, err := scanBrand(row)
 	if err != nil {
-		return Brand{}, err
+		return Brand{}, notFound(err)
 	}
-	if len(rows) == 0 {
-		return Brand{}, os.ErrNotExist
-	}
-	return rows[0].toBrand(), nil
+	return brand, nil
 }
 
+// ListBrands returns active brands; ListAllBrands includes archived ones.
 func (s *Store) ListBrands() ([]Brand, error) {
-	rows := []brandRow{}
-	if err := s.queryJSON(`
-		SELECT id, name, slug, content, created_at, updated_at
+	return s.listBrands(false)
+}
+
+func (s *Store) ListAllBrands() ([]Brand, error) {
+	return s.listBrands(true)
+}
+
+func (s *Store) listBrands(includeArchived bool) ([]Brand, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, slug, content, `+brandVersionSQL+`, created_at, updated_at, archived_at
 		FROM brands
+		WHERE ? OR archived_at IS NULL
 		ORDER BY slug ASC;
-	`, &rows); err != nil {
+	`, includeArchived)
+	if err != nil {
 		return nil, err
 	}
-	brands := make([]Brand, 0, len(rows))
-	for _, row := range rows {
-		brands = append(brands, row.toBrand())
-	}
-	return brands, nil
+	return collectRows(rows, scanBrand)
 }
 
 func (s *Store) CreateProject(name string, defaultBrandSlug string) (Project, error) {
@@ -113,20 +235,21 @@ func (s *Store) CreateProject(name string, defaultBrandSlug string) (Project, er
 	if slug == "" {
 		return Project{}, errors.New("project name is required")
 	}
-	brandIDExpr := "NULL"
+	var brandID sql.NullInt64
 	if b := Slugify(defaultBrandSlug); b != "" {
-		brandID, err := s.brandIDBySlug(b)
+		id, err := s.brandIDBySlug(b)
 		if err != nil {
 			return Project{}, err
 		}
-		brandIDExpr = strconv.FormatInt(brandID, 10)
+		brandID = sql.NullInt64{Int64: id, Valid: true}
 	}
-	err := s.execSQL(fmt.Sprintf(`
+	now := nowText()
+	_, err := s.db.Exec(`
 		INSERT INTO projects (name, slug, default_brand_id, created_at, updated_at)
-		VALUES (%s, %s, %s, %s, %s);
-	`, q(strings.TrimSpace(name)), q(slug), brandIDExpr, nowExpr(), nowExpr()))
+		VALUES (?, ?, ?, ?, ?);
+	`, strings.TrimSpace(name), slug, brandID, now, now)
 	if err != nil {
-		if strings.Contains(strings.ToLower(err.Error()), "unique") {
+		if isUniqueViolation(err) {
 			return Project{}, fmt.Errorf("project %q already exists", slug)
 		}
 		return Project{}, err
@@ -134,46 +257,45 @@ func (s *Store) CreateProject(name string, defaultBrandSlug string) (Project, er
 	return s.GetProject(slug)
 }
 
-func (s *Store) GetProject(slug string) (Project, error) {
-	slug = Slugify(slug)
-	rows := []projectRow{}
-	err := s.queryJSON(fmt.Sprintf(`
+const projectSelectSQL = `
 		SELECT p.id, p.name, p.slug, COALESCE(b.slug, '') AS default_brand_slug,
-		       p.created_at, p.updated_at, COUNT(w.id) AS work_item_count
+		       p.created_at, p.updated_at, COUNT(w.id) AS work_item_count, p.archived_at
 		FROM projects p
 		LEFT JOIN brands b ON b.id = p.default_brand_id
-		LEFT JOIN work_items w ON w.project_id = p.id
-		WHERE p.slug = %s
-		GROUP BY p.id, p.name, p.slug, b.slug, p.created_at, p.updated_at
+		LEFT JOIN work_items w ON w.project_id = p.id AND w.archived_at IS NULL`
+
+func (s *Store) GetProject(slug string) (Project, error) {
+	row := s.db.QueryRow(projectSelectSQL+`
+		WHERE p.slug = ?
+		GROUP BY p.id
 		LIMIT 1;
-	`, q(slug)), &rows)
+	`, Slugify(slug))
+	project, err := scanProject(row)
 	if err != nil {
-		return Project{}, err
-	}
-	if len(rows) == 0 {
-		return Project{}, os.ErrNotExist
+		return Project{}, notFound(err)
 	}
-	return rows[0].toProject(), nil
+	return project, nil
 }
 
+// ListProjects returns active projects; ListAllProjects includes archived ones.
 func (s *Store) ListProjects() ([]Project, error) {
-	rows := []projectRow{}
-	if err := s.queryJSON(`
-		SELECT p.id, p.name, p.slug, COALESCE(b.slug, '') AS default_brand_slug,
-		       p.created_at, p.updated_at, COUNT(w.id) AS work_item_count
-		FROM projects p
-		LEFT JOIN brands b ON b.id = p.default_brand_id
-		LEFT JOIN work_items w ON w.project_id = p.id
-		GROUP BY p.id, p.name, p.slug, b.slug, p.created_at, p.updated_at
+	return s.listProjects(false)
+}
+
+func (s *Store) ListAllProjects() ([]Project, error) {
+	return s.listProjects(true)
+}
+
+func (s *Store) listProjects(includeArchived bool) ([]Project, error) {
+	rows, err := s.db.Query(projectSelectSQL+`
+		WHERE ? OR p.archived_at IS NULL
+		GROUP BY p.id
 		ORDER BY p.slug ASC;
-	`, &rows); err != nil {
+	`, includeArchived)
+	if err != nil {
 		return nil, err
 	}
-	projects := make([]Project, 0, len(rows))
-	for _, row := range rows {
-		projects = append(projects, row.toProject())
-	}
-	return projects, nil
+	return collectRows(rows, scanProject)
 }
 
 func (s *Store) CreateWorkItem(projectSlug string, name string, itemType string, prompt string, brandOverrideSlug string) (WorkItem, error) {
@@ -194,20 +316,28 @@ func (s *Store) CreateWorkItem(projectSlug string, name string, itemType string,
 	if t == "" {
 		t = "generic"
 	}
-	brandIDExpr := "NULL"
+	var brandID sql.NullInt64
 	if b := Slugify(brandOverrideSlug); b != "" {
-		brandID, err := s.brandIDBySlug(b)
+		id, err := s.brandIDBySlug(b)
 		if err != nil {
 			return WorkItem{}, err
 		}
-		brandIDExpr = strconv.FormatInt(brandID, 10)
-	}
-	err = s.execSQL(fmt.Sprintf(`
-		INSERT INTO work_items (project_id, name, slug, type, prompt, brand_id, created_at, updated_at)
-		VALUES (%d, %s, %s, %s, %s, %s, %s, %s);
-	`, projectID, q(strings.TrimSpace(name)), q(slug), q(t), q(p), brandIDExpr, nowExpr(), nowExpr()))
+		brandID = sql.NullInt64{Int64: id, Valid: true}
+	}
+	err = s.withTx(func(tx *sql.Tx) error {
+		now := nowText()
+		var itemID int64
+		if err := tx.QueryRow(`
+			INSERT INTO work_items (project_id, name, slug, type, prompt, brand_id, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id;
+		`, projectID, strings.TrimSpace(name), slug, t, p, brandID, now, now).Scan(&itemID); err != nil {
+			return err
+		}
+		return insertPromptRevision(tx, itemID, p, "")
+	})
 	if err != nil {
-		if strings.Contains(strings.ToLower(err.Error()), "unique") {
+		if isUniqueViolation(err) {
 			return WorkItem{}, fmt.Errorf("work item %q already exists", slug)
 		}
 		return WorkItem{}, err
@@ -215,75 +345,166 @@ func (s *Store) CreateWorkItem(projectSlug string, name string, itemType string,
 	return s.GetWorkItem(projectSlug, slug)
 }
 
-func (s *Store) GetWorkItem(projectSlug string, itemSlug string) (WorkItem, error) {
-	projectSlug = Slugify(projectSlug)
-	itemSlug = Slugify(itemSlug)
-	rows := []workItemRow{}
-	err := s.queryJSON(fmt.Sprintf(`
+const workItemSelectSQL = `
 		SELECT w.id, w.name, w.slug, w.type, w.prompt, w.project_id,
-		       p.slug AS project_slug, COALESCE(b.slug, '') AS brand_override,
-		       w.created_at, w.updated_at
+		       p.slug AS project_slug, COALESCE(b.slug, '') AS brand_override, w.status, w.constraints_json,
+		       COALESCE((SELECT MAX(r.revision) FROM prompt_revisions r WHERE r.work_item_id = w.id), 0) AS prompt_revision,
+		       w.created_at, w.updated_at, w.archived_at,` + workItemTagsSQL + `,
+		       f.work_item_id, COALESCE(f.image_id, 0), COALESCE(f.filename, ''), COALESCE(f.rel_path, ''),
+		       COALESCE(f.finalized_by, ''), COALESCE(f.finalized_at, '')
 		FROM work_items w
 		JOIN projects p ON p.id = w.project_id
 		LEFT JOIN brands b ON b.id = w.brand_id
-		WHERE p.slug = %s AND w.slug = %s
+		LEFT JOIN work_item_finals f ON f.work_item_id = w.id`
+
+func (s *Store) GetWorkItem(projectSlug string, itemSlug string) (WorkItem, error) {
+	row := s.db.QueryRow(workItemSelectSQL+`
+		WHERE p.slug = ? AND w.slug = ?
 		LIMIT 1;
-	`, q(projectSlug), q(itemSlug)), &rows)
+	`, Slugify(projectSlug), Slugify(itemSlug))
+	item, err := scanWorkItem(row)
 	if err != nil {
-		return WorkItem{}, err
+		return WorkItem{}, notFound(err)
 	}
-	if len(rows) == 0 {
-		return WorkItem{}, os.ErrNotExist
-	}
-	return rows[0].toWorkItem(), nil
+	return item, nil
 }
 
+// ListWorkItems returns a project's active work items; ListAllWorkItems
+// includes archived ones.
 func (s *Store) ListWorkItems(projectSlug string) ([]WorkItem, error) {
-	projectSlug = Slugify(projectSlug)
-	rows := []workItemRow{}
-	err := s.queryJSON(fmt.Sprintf(`
-		SELECT w.id, w.name, w.slug, w.type, w.prompt, w.project_id,
-		       p.slug AS project_slug, COALESCE(b.slug, '') AS brand_override,
-		       w.created_at, w.updated_at
-		FROM work_items w
-		JOIN projects p ON p.id = w.project_id
-		LEFT JOIN brands b ON b.id = w.brand_id
-		WHERE p.slug = %s
+	return s.listWorkItems(projectSlug, false)
+}
+
+func (s *Store) ListAllWorkItems(projectSlug string) ([]WorkItem, error) {
+	return s.listWorkItems(projectSlug, true)
+}
+
+func (s *Store) listWorkItems(projectSlug string, includeArchived bool) ([]WorkItem, error) {
+	rows, err := s.db.Query(workItemSelectSQL+`
+		WHERE p.slug = ? AND (? OR w.archived_at IS NULL)
 		ORDER BY w.slug ASC;
-	`, q(projectSlug)), &rows)
+	`, Slugify(projectSlug), includeArchived)
 	if err != nil {
 		return nil, err
 	}
-	items := make([]WorkItem, 0, len(rows))
-	for _, row := range rows {
-		items = append(items, row.toWorkItem())
-	}
-	return items, nil
+	return collectRows(rows, scanWorkItem)
 }
 
-func (s *Store) UpdateWorkItemPrompt(projectSlug string, itemSlug string, prompt string) (WorkItem, error) {
-	projectSlug = Slugify(projectSlug)
-	itemSlug = Slugify(itemSlug)
+// UpdateWorkItemPrompt saves prompt and records it as a new revision with an
+// optional note.
+func (s *Store) UpdateWorkItemPrompt(projectSlug string, itemSlug string, prompt string, note string) (WorkItem, error) {
 	prompt = strings.TrimSpace(prompt)
 	if prompt == "" {
 		return WorkItem{}, errors.New("prompt is required")
 	}
-	err := s.execSQL(fmt.Sprintf(`
+	return s.savePromptRevision(projectSlug, itemSlug, prompt, strings.TrimSpace(note))
+}
+
+// UpdateWorkItemConstraints replaces a work item's generation constraints.
+func (s *Store) UpdateWorkItemConstraints(projectSlug string, itemSlug string, constraints Constraints) (WorkItem, error) {
+	constraints = constraints.Normalize()
+	if err := constraints.Validate(); err != nil {
+		return WorkItem{}, err
+	}
+	raw, err := json.Marshal(constraints)
+	if err != nil {
+		return WorkItem{}, err
+	}
+	res, err := s.db.Exec(`
 		UPDATE work_items
-		SET prompt = %s, updated_at = %s
+		SET constraints_json = ?, updated_at = ?
 		WHERE id IN (
 			SELECT w.id
 			FROM work_items w
 			JOIN projects p ON p.id = w.project_id
-			WHERE p.slug = %s AND w.slug = %s
+			WHERE p.slug = ? AND w.slug = ?
 		);
-	`, q(prompt), nowExpr(), q(projectSlug), q(itemSlug)))
+	`, string(raw), nowText(), Slugify(projectSlug), Slugify(itemSlug))
+	if err != nil {
+		return WorkItem{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return WorkItem{}, os.ErrNotExist
+	}
+	return s.GetWorkItem(projectSlug, itemSlug)
+}
+
+func (s *Store) ListPromptRevisions(projectSlug string, itemSlug string) ([]PromptRevision, error) {
+	rows, err := s.db.Query(`
+		SELECT r.id, r.work_item_id, r.revision, r.prompt, r.note, r.created_at
+		FROM prompt_revisions r
+		JOIN work_items w ON w.id = r.work_item_id
+		JOIN projects p ON p.id = w.project_id
+		WHERE p.slug = ? AND w.slug = ?
+		ORDER BY r.revision DESC;
+	`, Slugify(projectSlug), Slugify(itemSlug))
+	if err != nil {
+		return nil, err
+	}
+	return collectRows(rows, scanPromptRevision)
+}
+
+func (s *Store) GetPromptRevision(projectSlug string, itemSlug string, revision int) (PromptRevision, error) {
+	row := s.db.QueryRow(`
+		SELECT r.id, r.work_item_id, r.revision, r.prompt, r.note, r.created_at
+		FROM prompt_revisions r
+		JOIN work_items w ON w.id = r.work_item_id
+		JOIN projects p ON p.id = w.project_id
+		WHERE p.slug = ? AND w.slug = ? AND r.revision = ?;
+	`, Slugify(projectSlug), Slugify(itemSlug), revision)
+	rev, err := scanPromptRevision(row)
+	if err != nil {
+		return PromptRevision{}, notFound(err)
+	}
+	return rev, nil
+}
+
+// RestorePromptRevision makes an earlier revision current by saving its text
+// as a new revision.
+func (s *Store) RestorePromptRevision(projectSlug string, itemSlug string, revision int) (WorkItem, error) {
+	rev, err := s.GetPromptRevision(projectSlug, itemSlug, revision)
+	if err != nil {
+		return WorkItem{}, err
+	}
+	return s.savePromptRevision(projectSlug, itemSlug, rev.Prompt, fmt.Sprintf("restored r%d", revision))
+}
+
+func (s *Store) savePromptRevision(projectSlug string, itemSlug string, prompt string, note string) (WorkItem, error) {
+	projectSlug = Slugify(projectSlug)
+	itemSlug = Slugify(itemSlug)
+	err := s.withTx(func(tx *sql.Tx) error {
+		var itemID int64
+		err := tx.QueryRow(`
+			UPDATE work_items
+			SET prompt = ?, updated_at = ?
+			WHERE id IN (
+				SELECT w.id
+				FROM work_items w
+				JOIN projects p ON p.id = w.project_id
+				WHERE p.slug = ? AND w.slug = ?
+			)
+			RETURNING id;
+		`, prompt, nowText(), projectSlug, itemSlug).Scan(&itemID)
+		if err != nil {
+			return notFound(err)
+		}
+		return insertPromptRevision(tx, itemID, prompt, note)
+	})
 	if err != nil {
 		return WorkItem{}, err
 	}
 	return s.GetWorkItem(projectSlug, itemSlug)
 }
 
+func insertPromptRevision(tx *sql.Tx, workItemID int64, prompt string, note string) error {
+	_, err := tx.Exec(`
+		INSERT INTO prompt_revisions (work_item_id, revision, prompt, note, created_at)
+		SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ?, ?
+		FROM prompt_revisions WHERE work_item_id = ?;
+	`, workItemID, prompt, note, nowText(), workItemID)
+	return err
+}
+
 func (s *Store) CreateGenerateJob(projectSlug string, itemSlug string, payload GenerateJobPayload) (Job, error) {
 	projectSlug = Slugify(projectSlug)
 	itemSlug = Slugify(itemSlug)
@@ -291,257 +512,362 @@ func (s *Store) CreateGenerateJob(projectSlug string, itemSlug string, payload G
 	if err != nil {
 		return Job{}, err
 	}
+	project, err := s.GetProject(projectSlug)
+	if err != nil {
+		return Job{}, err
+	}
+	if item.ArchivedAt != nil || project.ArchivedAt != nil {
+		return Job{}, fmt.Errorf("work item %q is archived; restore it before generating", itemSlug)
+	}
 	if payload.Count < 1 {
 		payload.Count = 1
 	}
-	if payload.Model == "" {
-		payload.Model = "both"
+	if err := item.Constraints.apply(&payload); err != nil {
+		return Job{}, err
 	}
-	if payload.OutputFormat == "" {
-		payload.OutputFormat = "png"
+	if err := s.checkReferenceAssets(cmp.Or(item.BrandOverride, project.DefaultBrandSlug), payload); err != nil {
+		return Job{}, err
+	}
+	if payload.MaxBytes < 0 {
+		return Job{}, errors.New("max bytes must be >= 0")
 	}
-	if payload.ImageSize == "" {
-		payload.ImageSize = "1K"
+	if payload.MaxBytes > 0 && payload.OutputFormat != "jpg" && payload.OutputFormat != "webp" {
+		return Job{}, fmt.Errorf("max bytes requires jpg or webp output, got %q", payload.OutputFormat)
 	}
 	raw, _ := json.Marshal(payload)
 
-	rows := []idRow{}
-	err = s.queryJSON(fmt.Sprintf(`
-		INSERT INTO jobs (work_item_id, status, payload_json, created_at)
-		VALUES (%d, 'queued', %s, %s)
-		RETURNING id;
-	`, item.ID, q(string(raw)), nowExpr()), &rows)
+	var jobID int64
+	err = s.withTx(func(tx *sql.Tx) error {
+		if err := tx.QueryRow(`
+			INSERT INTO jobs (work_item_id, status, payload_json, created_at)
+			VALUES (?, 'queued', ?, ?)
+			RETURNING id;
+		`, item.ID, string(raw), nowText()).Scan(&jobID); err != nil {
+			return err
+		}
+		return autoStatus(tx, item.ID, StatusGenerating, fmt.Sprintf("job #%d queued", jobID),
+			StatusDraft, StatusInReview, StatusApproved)
+	})
 	if err != nil {
 		return Job{}, err
 	}
-	if len(rows) == 0 {
-		return Job{}, errors.New("failed to create job")
-	}
-	return s.GetJob(rows[0].ID)
+	return s.GetJob(jobID)
 }
 
-func (s *Store) ListJobs(limit int) ([]Job, error) {
-	if limit <= 0 {
-		limit = 50
-	}
-	rows := []jobRow{}
-	err := s.queryJSON(fmt.Sprintf(`
+const jobSelectSQL = `
 		SELECT j.id, j.status, p.slug AS project_slug, p.name AS project_name,
 		       w.slug AS work_item_slug, w.name AS work_item_name,
 		       j.payload_json, COALESCE(j.error_message, '') AS error_message,
-		       j.created_at, j.started_at, j.finished_at, COALESCE(j.run_id, 0) AS run_id
+		       j.created_at, j.started_at, j.finished_at, COALESCE(j.run_id, 0) AS run_id,
+		       COALESCE(b.slug, '') AS brand_slug, COALESCE(bv.version, 0) AS brand_version,
+		       COALESCE(pr.revision, 0) AS prompt_revision
 		FROM jobs j
 		JOIN work_items w ON w.id = j.work_item_id
 		JOIN projects p ON p.id = w.project_id
-		ORDER BY j.created_at DESC
-		LIMIT %d;
-	`, limit), &rows)
+		LEFT JOIN runs r ON r.id = j.run_id
+		LEFT JOIN brand_versions bv ON bv.id = r.brand_version_id
+		LEFT JOIN brands b ON b.id = bv.brand_id
+		LEFT JOIN prompt_revisions pr ON pr.id = r.prompt_revision_id`
+
+func (s *Store) ListJobs(filter JobFilter) (JobPage, error) {
+	cur, err := decodeCursor(filter.Cursor)
 	if err != nil {
-		return nil, err
+		return JobPage{}, err
+	}
+	limit := pageLimit(filter.Limit, 50)
+	conds, args := listingConds("j", filter, cur)
+	order := "DESC"
+	if cur != nil && cur.Newer {
+		order = "ASC"
+	}
+	rows, err := s.db.Query(jobSelectSQL+whereClause(conds)+`
+		ORDER BY j.created_at `+order+`, j.id `+order+`
+		LIMIT ?;
+	`, append(args, limit+1)...)
+	if err != nil {
+		return JobPage{}, err
 	}
-	jobs := make([]Job, 0, len(rows))
-	for _, row := range rows {
-		jobs = append(jobs, row.toJob())
+	jobs, err := collectRows(rows, scanJob)
+	if err != nil {
+		return JobPage{}, err
 	}
-	return jobs, nil
+	page := JobPage{}
+	page.Jobs, page.NextCursor, page.PrevCursor = finishPage(jobs, limit, cur, func(j Job) pageCursor {
+		return cursorAt(j.CreatedAt, j.ID)
+	})
+	return page, nil
 }
 
-func (s *Store) ListJobsForWorkItem(projectSlug string, itemSlug string, limit int) ([]Job, error) {
-	if limit <= 0 {
-		limit = 10
-	}
-	rows := []jobRow{}
-	err := s.queryJSON(fmt.Sprintf(`
-		SELECT j.id, j.status, p.slug AS project_slug, p.name AS project_name,
-		       w.slug AS work_item_slug, w.name AS work_item_name,
-		       j.payload_json, COALESCE(j.error_message, '') AS error_message,
-		       j.created_at, j.started_at, j.finished_at, COALESCE(j.run_id, 0) AS run_id
-		FROM jobs j
-		JOIN work_items w ON w.id = j.work_item_id
-		JOIN projects p ON p.id = w.project_id
-		WHERE p.slug = %s AND w.slug = %s
-		ORDER BY j.created_at DESC
-		LIMIT %d;
-	`, q(Slugify(projectSlug)), q(Slugify(itemSlug)), limit), &rows)
-	if err != nil {
-		return nil, err
+// listingConds builds the WHERE conditions shared by job and image listings.
+// alias is the table whose created_at and id the listing is ordered by; for
+// "ri" the model and tag filters apply to the image, otherwise the model
+// filter matches the job payload and the tag filter the work item.
+func listingConds(alias string, f JobFilter, cur *pageCursor) ([]string, []any) {
+	conds := []string{}
+	args := []any{}
+	add := func(cond string, arg ...any) {
+		conds = append(conds, cond)
+		args = append(args, arg...)
+	}
+	if f.Status != "" {
+		add("j.status = ?", f.Status)
+	}
+	if f.ProjectSlug != "" {
+		add("p.slug = ?", Slugify(f.ProjectSlug))
+	}
+	if f.WorkItemSlug != "" {
+		add("w.slug = ?", Slugify(f.WorkItemSlug))
+	}
+	if f.Model != "" && alias == "ri" {
+		add("ri.model = ?", f.Model)
+	} else if f.Model != "" {
+		add("json_extract(j.payload_json, '$.model') = ?", f.Model)
+	}
+	if f.Tag != "" && alias == "ri" {
+		add("ri.id IN (SELECT it.image_id FROM image_tags it JOIN tags t ON t.id = it.tag_id WHERE t.name = ?)", NormalizeTag(f.Tag))
+	} else if f.Tag != "" {
+		add("w.id IN (SELECT wt.work_item_id FROM work_item_tags wt JOIN tags t ON t.id = wt.tag_id WHERE t.name = ?)", NormalizeTag(f.Tag))
+	}
+	if t := filterTime(f.From); t != "" {
+		add(alias+".created_at >= ?", t)
+	}
+	if t := filterTime(f.To); t != "" {
+		add(alias+".created_at < ?", t)
+	}
+	if cur != nil {
+		op := "<"
+		if cur.Newer {
+			op = ">"
+		}
+		add("("+alias+".created_at, "+alias+".id) "+op+" (?, ?)", cur.CreatedAt, cur.ID)
 	}
-	jobs := make([]Job, 0, len(rows))
-	for _, row := range rows {
-		jobs = append(jobs, row.toJob())
+	return conds, args
+}
+
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
 	}
-	return jobs, nil
+	return "\n\t\tWHERE " + strings.Join(conds, " AND ")
 }
 
 func (s *Store) GetJob(jobID int64) (Job, error) {
-	rows := []jobRow{}
-	err := s.queryJSON(fmt.Sprintf(`
-		SELECT j.id, j.status, p.slug AS project_slug, p.name AS project_name,
-		       w.slug AS work_item_slug, w.name AS work_item_name,
-		       j.payload_json, COALESCE(j.error_message, '') AS error_message,
-		       j.created_at, j.started_at, j.finished_at, COALESCE(j.run_id, 0) AS run_id
-		FROM jobs j
-		JOIN work_items w ON w.id = j.work_item_id
-		JOIN projects p ON p.id = w.project_id
-		WHERE j.id = %d
+	row := s.db.QueryRow(jobSelectSQL+`
+		WHERE j.id = ?
 		LIMIT 1;
-	`, jobID), &rows)
+	`, jobID)
+	job, err := scanJob(row)
 	if err != nil {
-		return Job{}, err
+		return Job{}, notFound(err)
 	}
-	if len(rows) == 0 {
-		return Job{}, os.ErrNotExist
-	}
-	return rows[0].toJob(), nil
+	return job, nil
 }
 
 func (s *Store) ClaimNextQueuedJob() (*JobExecutionContext, error) {
-	rows := []claimRow{}
-	err := s.queryJSON(`
-		SELECT j.id AS job_id, w.id AS work_item_id, p.slug AS project_slug, p.name AS project_name,
-		       w.slug AS work_item_slug, w.name AS work_item_name, w.prompt,
-		       COALESCE(bw.slug, bp.slug, '') AS brand_slug,
-		       COALESCE(bw.content, bp.content, '') AS brand_content,
-		       j.payload_json
-		FROM jobs j
-		JOIN work_items w ON w.id = j.work_item_id
-		JOIN projects p ON p.id = w.project_id
-		LEFT JOIN brands bw ON bw.id = w.brand_id
-		LEFT JOIN brands bp ON bp.id = p.default_brand_id
-		WHERE j.status = 'queued'
-		ORDER BY j.created_at ASC
-		LIMIT 1;
-	`, &rows)
+	var claimed *JobExecutionContext
+	err := s.withTx(func(tx *sql.Tx) error {
+		ctx := JobExecutionContext{}
+		var payloadJSON, constraintsJSON string
+		err := tx.QueryRow(`
+			SELECT j.id AS job_id, w.id AS work_item_id, p.slug AS project_slug, p.name AS project_name,
+			       w.slug AS work_item_slug, w.name AS work_item_name, w.prompt,
+			       COALESCE(bw.slug, bp.slug, '') AS brand_slug,
+			       COALESCE(bw.content, bp.content, '') AS brand_content,
+			       COALESCE((SELECT MAX(v.id) FROM brand_versions v WHERE v.brand_id = COALESCE(bw.id, bp.id)), 0) AS brand_version_id,
+			       COALESCE((SELECT MAX(r.id) FROM prompt_revisions r WHERE r.work_item_id = w.id), 0) AS prompt_revision_id,
+			       w.constraints_json, j.payload_json
+			FROM jobs j
+			JOIN work_items w ON w.id = j.work_item_id
+			JOIN projects p ON p.id = w.project_id
+			LEFT JOIN brands bw ON bw.id = w.brand_id
+			LEFT JOIN brands bp ON bp.id = p.default_brand_id
+			WHERE j.status = 'queued'
+			ORDER BY j.created_at ASC
+			LIMIT 1;
+		`).Scan(
+			&ctx.JobID, &ctx.WorkItemID, &ctx.ProjectSlug, &ctx.ProjectName,
+			&ctx.WorkItemSlug, &ctx.WorkItemName, &ctx.Prompt,
+			&ctx.BrandSlug, &ctx.BrandContent, &ctx.BrandVersionID, &ctx.PromptRevisionID, &constraintsJSON, &payloadJSON,
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		res, err := tx.Exec(`
+			UPDATE jobs SET status = 'running', started_at = ?
+			WHERE id = ? AND status = 'queued';
+		`, nowText(), ctx.JobID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return nil
+		}
+		if err := json.Unmarshal([]byte(payloadJSON), &ctx.Payload); err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(constraintsJSON), &ctx.Constraints); err != nil {
+			return err
+		}
+		claimed = &ctx
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	if len(rows) == 0 {
-		return nil, nil
-	}
-	row := rows[0]
-	if err := s.execSQL(fmt.Sprintf(`
-		UPDATE jobs SET status = 'running', started_at = %s
-		WHERE id = %d AND status = 'queued';
-	`, nowExpr(), row.JobID)); err != nil {
-		return nil, err
-	}
-
-	payload := GenerateJobPayload{}
-	if err := json.Unmarshal([]byte(row.PayloadJSON), &payload); err != nil {
-		return nil, err
-	}
-	return &JobExecutionContext{
-		JobID:        row.JobID,
-		WorkItemID:   row.WorkItemID,
-		ProjectSlug:  row.ProjectSlug,
-		ProjectName:  row.ProjectName,
-		WorkItemSlug: row.WorkItemSlug,
-		WorkItemName: row.WorkItemName,
-		Prompt:       row.Prompt,
-		BrandSlug:    row.BrandSlug,
-		BrandContent: row.BrandContent,
-		Payload:      payload,
-	}, nil
+	return claimed, nil
 }
 
-func (s *Store) CreateRun(jobID int64, workItemID int64, promptSnapshot string, settingsJSON string) (int64, error) {
-	rows := []idRow{}
-	err := s.queryJSON(fmt.Sprintf(`
-		INSERT INTO runs (job_id, work_item_id, prompt_snapshot, settings_json, status, created_at)
-		VALUES (%d, %d, %s, %s, 'running', %s)
-		RETURNING id;
-	`, jobID, workItemID, q(promptSnapshot), q(settingsJSON), nowExpr()), &rows)
+func (s *Store) CreateRun(job *JobExecutionContext, promptSnapshot string, settingsJSON string) (int64, error) {
+	var runID int64
+	err := s.withTx(func(tx *sql.Tx) error {
+		if err := tx.QueryRow(`
+			INSERT INTO runs (job_id, work_item_id, prompt_snapshot, settings_json, status, created_at, brand_version_id, prompt_revision_id)
+			VALUES (?, ?, ?, ?, 'running', ?, NULLIF(?, 0), NULLIF(?, 0))
+			RETURNING id;
+		`, job.JobID, job.WorkItemID, promptSnapshot, settingsJSON, nowText(), job.BrandVersionID, job.PromptRevisionID).Scan(&runID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE jobs SET run_id = ? WHERE id = ?;`, runID, job.JobID)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
-	if len(rows) == 0 {
-		return 0, errors.New("failed to create run")
-	}
-	runID := rows[0].ID
-	if err := s.execSQL(fmt.Sprintf(`UPDATE jobs SET run_id = %d WHERE id = %d;`, runID, jobID)); err != nil {
-		return 0, err
-	}
 	return runID, nil
 }
 
+func (s *Store) UpdateRunSettings(runID int64, settingsJSON string) error {
+	_, err := s.db.Exec(`UPDATE runs SET settings_json = ? WHERE id = ?;`, settingsJSON, runID)
+	return err
+}
+
 func (s *Store) MarkRunSucceeded(runID int64) error {
-	return s.execSQL(fmt.Sprintf(`UPDATE runs SET status = 'succeeded', finished_at = %s WHERE id = %d;`, nowExpr(), runID))
+	_, err := s.db.Exec(`UPDATE runs SET status = 'succeeded', finished_at = ? WHERE id = ?;`, nowText(), runID)
+	return err
 }
 
 func (s *Store) MarkRunFailed(runID int64, message string) error {
-	return s.execSQL(fmt.Sprintf(`UPDATE runs SET status = 'failed', error_message = %s, finished_at = %s WHERE id = %d;`, q(strings.TrimSpace(message)), nowExpr(), runID))
+	return markRunFailed(s.db, runID, message)
+}
+
+func markRunFailed(db dbtx, runID int64, message string) error {
+	_, err := db.Exec(`UPDATE runs SET status = 'failed', error_message = ?, finished_at = ? WHERE id = ?;`, strings.TrimSpace(message), nowText(), runID)
+	return err
 }
 
 func (s *Store) MarkJobSucceeded(jobID int64) error {
-	return s.execSQL(fmt.Sprintf(`UPDATE jobs SET status = 'succeeded', finished_at = %s WHERE id = %d;`, nowExpr(), jobID))
+	return s.withTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`UPDATE jobs SET status = 'succeeded', finished_at = ? WHERE id = ?;`, nowText(), jobID); err != nil {
+			return err
+		}
+		return settleJobStatus(tx, jobID)
+	})
 }
 
 func (s *Store) MarkJobFailed(jobID int64, message string) error {
-	return s.execSQL(fmt.Sprintf(`UPDATE jobs SET status = 'failed', error_message = %s, finished_at = %s WHERE id = %d;`, q(strings.TrimSpace(message)), nowExpr(), jobID))
+	return s.withTx(func(tx *sql.Tx) error {
+		return markJobFailed(tx, jobID, message)
+	})
+}
+
+func markJobFailed(tx *sql.Tx, jobID int64, message string) error {
+	if _, err := tx.Exec(`UPDATE jobs SET status = 'failed', error_message = ?, finished_at = ? WHERE id = ?;`, strings.TrimSpace(message), nowText(), jobID); err != nil {
+		return err
+	}
+	return settleJobStatus(tx, jobID)
+}
+
+func (s *Store) AddRunImage(runID int64, filename string, relPath string, format string, model string, meta ImageMeta) error {
+	return addRunImage(s.db, runID, filename, relPath, format, model, meta)
 }
 
-func (s *Store) AddRunImage(runID int64, filename string, relPath string, format string) error {
-	return s.execSQL(fmt.Sprintf(`
-		INSERT INTO run_images (run_id, filename, rel_path, format, created_at)
-		VALUES (%d, %s, %s, %s, %s);
-	`, runID, q(filename), q(relPath), q(format), nowExpr()))
+func addRunImage(db dbtx, runID int64, filename string, relPath string, format string, model string, meta ImageMeta) error {
+	_, err := db.Exec(`
+		INSERT INTO run_images (run_id, filename, rel_path, format, model, created_at, width, height, size_bytes, sha256, mime_type)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+	`, runID, filename, relPath, format, model, nowText(), meta.Width, meta.Height, meta.SizeBytes, meta.SHA256, meta.MIMEType)
+	return err
 }
 
-func (s *Store) ListWorkItemImages(projectSlug string, itemSlug string, limit int) ([]WorkItemImage, error) {
-	if limit <= 0 {
-		limit = 40
+func (s *Store) ListImages(filter ImageFilter) (ImagePage, error) {
+	cur, err := decodeCursor(filter.Cursor)
+	if err != nil {
+		return ImagePage{}, err
+	}
+	ranked := filter.Sort == "rating"
+	if cur != nil && cur.Ranked != ranked {
+		return ImagePage{}, errBadCursor
+	}
+	limit := pageLimit(filter.Limit, 40)
+	keyCur := cur
+	if ranked {
+		keyCur = nil
+	}
+	conds, args := listingConds("ri", filter.JobFilter, keyCur)
+	if filter.MinRating > 0 {
+		conds = append(conds, "ri.rating >= ?")
+		args = append(args, filter.MinRating)
+	}
+	if ranked && cur != nil {
+		op := "<"
+		if cur.Newer {
+			op = ">"
+		}
+		conds = append(conds, "(COALESCE(ri.rating, 0), ri.created_at, ri.id) "+op+" (?, ?, ?)")
+		args = append(args, cur.Rating, cur.CreatedAt, cur.ID)
 	}
-	rows := []imageRow{}
-	err := s.queryJSON(fmt.Sprintf(`
-		SELECT ri.id, ri.run_id, ri.filename, ri.created_at
-		FROM run_images ri
-		JOIN runs r ON r.id = ri.run_id
+	order := "DESC"
+	if cur != nil && cur.Newer {
+		order = "ASC"
+	}
+	orderBy := "ri.created_at " + order + ", ri.id " + order
+	if ranked {
+		orderBy = "COALESCE(ri.rating, 0) " + order + ", " + orderBy
+	}
+	rows, err := s.db.Query(imageSelectSQL+`
+		JOIN jobs j ON j.id = r.job_id
 		JOIN work_items w ON w.id = r.work_item_id
-		JOIN projects p ON p.id = w.project_id
-		WHERE p.slug = %s AND w.slug = %s
-		ORDER BY ri.created_at DESC
-		LIMIT %d;
-	`, q(Slugify(projectSlug)), q(Slugify(itemSlug)), limit), &rows)
+		JOIN projects p ON p.id = w.project_id`+whereClause(conds)+`
+		ORDER BY `+orderBy+`
+		LIMIT ?;
+	`, append(args, limit+1)...)
 	if err != nil {
-		return nil, err
+		return ImagePage{}, err
 	}
-	images := make([]WorkItemImage, 0, len(rows))
-	for _, row := range rows {
-		images = append(images, row.toImage())
-	}
-	return images, nil
+	images, err := collectRows(rows, scanImage)
+	if err != nil {
+		return ImagePage{}, err
+	}
+	page := ImagePage{}
+	page.Images, page.NextCursor, page.PrevCursor = finishPage(images, limit, cur, func(img WorkItemImage) pageCursor {
+		c := cursorAt(img.CreatedAt, img.ID)
+		c.Ranked, c.Rating = ranked, img.Rating
+		return c
+	})
+	return page, nil
 }
 
 func (s *Store) ListJobImages(jobID int64) ([]WorkItemImage, error) {
-	rows := []imageRow{}
-	err := s.queryJSON(fmt.Sprintf(`
-		SELECT ri.id, ri.run_id, ri.filename, ri.created_at
-		FROM run_images ri
-		JOIN runs r ON r.id = ri.run_id
-		WHERE r.job_id = %d
+	rows, err := s.db.Query(imageSelectSQL+`
+		WHERE r.job_id = ?
 		ORDER BY ri.created_at ASC;
-	`, jobID), &rows)
+	`, jobID)
 	if err != nil {
 		return nil, err
 	}
-	images := make([]WorkItemImage, 0, len(rows))
-	for _, row := range rows {
-		images = append(images, row.toImage())
-	}
-	return images, nil
+	return collectRows(rows, scanImage)
 }
 
 func (s *Store) ImagePathByID(imageID int64) (string, error) {
-	rows := []struct {
-		RelPath string `json:"rel_path"`
-	}{}
-	if err := s.queryJSON(fmt.Sprintf(`SELECT rel_path FROM run_images WHERE id = %d LIMIT 1;`, imageID), &rows); err != nil {
-		return "", err
-	}
-	if len(rows) == 0 {
-		return "", os.ErrNotExist
+	var relPath string
+	err := s.db.QueryRow(`SELECT rel_path FROM run_images WHERE id = ? LIMIT 1;`, imageID).Scan(&relPath)
+	if err != nil {
+		return "", notFound(err)
 	}
-	return filepath.Join(s.Root, rows[0].RelPath), nil
+	return filepath.Join(s.Root, relPath), nil
 }
 
 func (s *Store) WorkItemImagesDir(projectSlug string, itemSlug string, runID int64) string {
@@ -557,280 +883,225 @@ func (s *Store) RelPath(abs string) (string, error) {
 	return rel, nil
 }
 
-func (s *Store) runMigrations() error {
-	statements := []string{
-		`PRAGMA foreign_keys = ON;`,
-		`CREATE TABLE IF NOT EXISTS brands (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL,
-			slug TEXT NOT NULL UNIQUE,
-			content TEXT NOT NULL,
-			created_at TEXT NOT NULL,
-			updated_at TEXT NOT NULL
-		);`,
-		`CREATE TABLE IF NOT EXISTS projects (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL,
-			slug TEXT NOT NULL UNIQUE,
-			default_brand_id INTEGER NULL,
-			created_at TEXT NOT NULL,
-			updated_at TEXT NOT NULL,
-			FOREIGN KEY(default_brand_id) REFERENCES brands(id) ON DELETE SET NULL
-		);`,
-		`CREATE TABLE IF NOT EXISTS work_items (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			project_id INTEGER NOT NULL,
-			name TEXT NOT NULL,
-			slug TEXT NOT NULL,
-			type TEXT NOT NULL,
-			prompt TEXT NOT NULL,
-			brand_id INTEGER NULL,
-			created_at TEXT NOT NULL,
-			updated_at TEXT NOT NULL,
-			UNIQUE(project_id, slug),
-			FOREIGN KEY(project_id) REFERENCES projects(id) ON DELETE CASCADE,
-			FOREIGN KEY(brand_id) REFERENCES brands(id) ON DELETE SET NULL
-		);`,
-		`CREATE TABLE IF NOT EXISTS jobs (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			work_item_id INTEGER NOT NULL,
-			run_id INTEGER NULL,
-			status TEXT NOT NULL,
-			payload_json TEXT NOT NULL,
-			error_message TEXT NULL,
-			created_at TEXT NOT NULL,
-			started_at TEXT NULL,
-			finished_at TEXT NULL,
-			FOREIGN KEY(work_item_id) REFERENCES work_items(id) ON DELETE CASCADE
-		);`,
-		`CREATE TABLE IF NOT EXISTS runs (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			job_id INTEGER NOT NULL UNIQUE,
-			work_item_id INTEGER NOT NULL,
-			prompt_snapshot TEXT NOT NULL,
-			settings_json TEXT NOT NULL,
-			status TEXT NOT NULL,
-			error_message TEXT NULL,
-			created_at TEXT NOT NULL,
-			finished_at TEXT NULL,
-			FOREIGN KEY(job_id) REFERENCES jobs(id) ON DELETE CASCADE,
-			FOREIGN KEY(work_item_id) REFERENCES work_items(id) ON DELETE CASCADE
-		);`,
-		`CREATE TABLE IF NOT EXISTS run_images (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			run_id INTEGER NOT NULL,
-			filename TEXT NOT NULL,
-			rel_path TEXT NOT NULL,
-			format TEXT NOT NULL,
-			created_at TEXT NOT NULL,
-			FOREIGN KEY(run_id) REFERENCES runs(id) ON DELETE CASCADE
-		);`,
-		`CREATE INDEX IF NOT EXISTS idx_jobs_status_created ON jobs(status, created_at);`,
-		`CREATE INDEX IF NOT EXISTS idx_jobs_work_item_created ON jobs(work_item_id, created_at DESC);`,
-		`CREATE INDEX IF NOT EXISTS idx_runs_work_item_created ON runs(work_item_id, created_at DESC);`,
-		`CREATE INDEX IF NOT EXISTS idx_run_images_run_created ON run_images(run_id, created_at);`,
-	}
-	for _, stmt := range statements {
-		if err := s.execSQL(stmt); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 func (s *Store) projectIDBySlug(slug string) (int64, error) {
-	rows := []idRow{}
-	if err := s.queryJSON(fmt.Sprintf(`SELECT id FROM projects WHERE slug = %s LIMIT 1;`, q(Slugify(slug))), &rows); err != nil {
-		return 0, err
-	}
-	if len(rows) == 0 {
+	var id int64
+	err := s.db.QueryRow(`SELECT id FROM projects WHERE slug = ? LIMIT 1;`, Slugify(slug)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
 		return 0, fmt.Errorf("project %q not found", slug)
 	}
-	return rows[0].ID, nil
+	return id, err
 }
 
 func (s *Store) brandIDBySlug(slug string) (int64, error) {
-	rows := []idRow{}
-	if err := s.queryJSON(fmt.Sprintf(`SELECT id FROM brands WHERE slug = %s LIMIT 1;`, q(Slugify(slug))), &rows); err != nil {
-		return 0, err
-	}
-	if len(rows) == 0 {
+	var id int64
+	err := s.db.QueryRow(`SELECT id FROM brands WHERE slug = ? LIMIT 1;`, Slugify(slug)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
 		return 0, fmt.Errorf("brand %q not found", slug)
 	}
-	return rows[0].ID, nil
+	return id, err
 }
 
-func (s *Store) execSQL(sqlText string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	cmd := exec.Command("sqlite3", s.DBPath, sqlText)
-	out, err := cmd.CombinedOutput()
+func (s *Store) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return fmt.Errorf("sqlite exec failed: %w: %s", err, strings.TrimSpace(string(out)))
+		return err
 	}
-	return nil
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
-func (s *Store) queryJSON(sqlText string, target any) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	cmd := exec.Command("sqlite3", "-json", s.DBPath, sqlText)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("sqlite query failed: %w: %s", err, strings.TrimSpace(string(out)))
+func nowText() string {
+	return time.Now().UTC().Format(timestampLayout)
+}
+
+func parseTime(v string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, v)
+	return t
+}
+
+func parseTimePtr(v sql.NullString) *time.Time {
+	if !v.Valid || strings.TrimSpace(v.String) == "" {
+		return nil
 	}
-	payload := strings.TrimSpace(string(out))
-	if payload == "" {
-		payload = "[]"
+	t := parseTime(v.String)
+	return &t
+}
+
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return os.ErrNotExist
 	}
-	if err := json.Unmarshal([]byte(payload), target); err != nil {
-		return err
+	return err
+}
+
+func isUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
+
+// dbtx is what helpers shared by *sql.DB and *sql.Tx callers need.
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func collectRows[T any](rows *sql.Rows, scan func(rowScanner) (T, error)) ([]T, error) {
+	defer rows.Close()
+	items := []T{}
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func scanBrand(sc rowScanner) (Brand, error) {
+	var b Brand
+	var created, updated string
+	var archived sql.NullString
+	if err := sc.Scan(&b.ID, &b.Name, &b.Slug, &b.Content, &b.Version, &created, &updated, &archived); err != nil {
+		return Brand{}, err
+	}
+	b.CreatedAt = parseTime(created)
+	b.UpdatedAt = parseTime(updated)
+	b.ArchivedAt = parseTimePtr(archived)
+	b.Spec, b.Body, _ = ParseBrand(b.Content)
+	return b, nil
+}
+
+func scanPromptRevision(sc rowScanner) (PromptRevision, error) {
+	var r PromptRevision
+	var created string
+	if err := sc.Scan(&r.ID, &r.WorkItemID, &r.Revision, &r.Prompt, &r.Note, &created); err != nil {
+		return PromptRevision{}, err
+	}
+	r.CreatedAt = parseTime(created)
+	return r, nil
+}
+
+func scanBrandVersion(sc rowScanner) (BrandVersion, error) {
+	var v BrandVersion
+	var created string
+	if err := sc.Scan(&v.ID, &v.BrandID, &v.Version, &v.Content, &v.Author, &v.Note, &created); err != nil {
+		return BrandVersion{}, err
+	}
+	v.CreatedAt = parseTime(created)
+	return v, nil
+}
+
+func scanProject(sc rowScanner) (Project, error) {
+	var p Project
+	var created, updated string
+	var archived sql.NullString
+	if err := sc.Scan(&p.ID, &p.Name, &p.Slug, &p.DefaultBrandSlug, &created, &updated, &p.WorkItemCount, &archived); err != nil {
+		return Project{}, err
 	}
-	return nil
+	p.CreatedAt = parseTime(created)
+	p.UpdatedAt = parseTime(updated)
+	p.ArchivedAt = parseTimePtr(archived)
+	return p, nil
 }
 
-func nowExpr() string {
-	return "strftime('%Y-%m-%dT%H:%M:%fZ','now')"
+func scanWorkItem(sc rowScanner) (WorkItem, error) {
+	var w WorkItem
+	var created, updated string
+	var archived sql.NullString
+	var tags, constraints string
+	var finalID sql.NullInt64
+	var final Final
+	var finalizedAt string
+	if err := sc.Scan(&w.ID, &w.Name, &w.Slug, &w.Type, &w.Prompt, &w.ProjectID, &w.ProjectSlug, &w.BrandOverride, &w.Status, &constraints, &w.PromptRevision, &created, &updated, &archived, &tags,
+		&finalID, &final.ImageID, &final.Filename, &final.RelPath, &final.FinalizedBy, &finalizedAt); err != nil {
+		return WorkItem{}, err
+	}
+	if err := json.Unmarshal([]byte(constraints), &w.Constraints); err != nil {
+		return WorkItem{}, err
+	}
+	if finalID.Valid {
+		final.WorkItemID = finalID.Int64
+		final.FinalizedAt = parseTime(finalizedAt)
+		w.Final = &final
+	}
+	w.Tags = splitTags(tags)
+	w.CreatedAt = parseTime(created)
+	w.UpdatedAt = parseTime(updated)
+	w.ArchivedAt = parseTimePtr(archived)
+	return w, nil
+}
+
+func scanJob(sc rowScanner) (Job, error) {
+	var j Job
+	var created string
+	var started, finished sql.NullString
+	var runID int64
+	if err := sc.Scan(
+		&j.ID, &j.Status, &j.ProjectSlug, &j.ProjectName,
+		&j.WorkItemSlug, &j.WorkItemName,
+		&j.PayloadJSON, &j.ErrorMessage,
+		&created, &started, &finished, &runID,
+		&j.BrandSlug, &j.BrandVersion, &j.PromptRevision,
+	); err != nil {
+		return Job{}, err
+	}
+	j.CreatedAt = parseTime(created)
+	j.StartedAt = parseTimePtr(started)
+	j.FinishedAt = parseTimePtr(finished)
+	if runID > 0 {
+		j.RunID = &runID
+	}
+	return j, nil
 }
-
-func q(v string) string {
-	return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+
+// workItemTagsSQL selects the comma-separated tags of work_items row w.
+const workItemTagsSQL = `
+		       COALESCE((SELECT group_concat(t.name, ',' ORDER BY t.name)
+		                 FROM work_item_tags wt JOIN tags t ON t.id = wt.tag_id
+		                 WHERE wt.work_item_id = w.id), '') AS tags`
+
+// imageTagsSQL selects the comma-separated tags of run_images row ri.
+const imageTagsSQL = `
+		       COALESCE((SELECT group_concat(t.name, ',' ORDER BY t.name)
+		                 FROM image_tags it JOIN tags t ON t.id = it.tag_id
+		                 WHERE it.image_id = ri.id), '') AS tags`
+
+// imageColumnsSQL lists the columns scanImage expects from run_images ri.
+const imageColumnsSQL = `ri.id, ri.run_id, ri.filename, ri.created_at, COALESCE(ri.rating, 0), ri.rating_note,
+		       ri.width, ri.height, ri.size_bytes, ri.sha256, ri.mime_type,` + imageTagsSQL
+
+// imageSelectSQL reads imageColumnsSQL from run_images ri joined to its run r.
+const imageSelectSQL = `
+		SELECT ` + imageColumnsSQL + `
+		FROM run_images ri
+		JOIN runs r ON r.id = ri.run_id`
+
+func scanImage(sc rowScanner) (WorkItemImage, error) {
+	return scanImageWith(sc)
 }
-
-type idRow struct {
-	ID int64 `json:"id"`
-}
-
-type brandRow struct {
-	ID        int64  `json:"id"`
-	Name      string `json:"name"`
-	Slug      string `json:"slug"`
-	Content   string `json:"content"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
-}
-
-func (r brandRow) toBrand() Brand {
-	created, _ := time.Parse(time.RFC3339Nano, r.CreatedAt)
-	updated, _ := time.Parse(time.RFC3339Nano, r.UpdatedAt)
-	return Brand{ID: r.ID, Name: r.Name, Slug: r.Slug, Content: r.Content, CreatedAt: created, UpdatedAt: updated}
-}
-
-type projectRow struct {
-	ID               int64  `json:"id"`
-	Name             string `json:"name"`
-	Slug             string `json:"slug"`
-	DefaultBrandSlug string `json:"default_brand_slug"`
-	CreatedAt        string `json:"created_at"`
-	UpdatedAt        string `json:"updated_at"`
-	WorkItemCount    int    `json:"work_item_count"`
-}
-
-func (r projectRow) toProject() Project {
-	created, _ := time.Parse(time.RFC3339Nano, r.CreatedAt)
-	updated, _ := time.Parse(time.RFC3339Nano, r.UpdatedAt)
-	return Project{ID: r.ID, Name: r.Name, Slug: r.Slug, DefaultBrandSlug: r.DefaultBrandSlug, CreatedAt: created, UpdatedAt: updated, WorkItemCount: r.WorkItemCount}
-}
-
-type workItemRow struct {
-	ID            int64  `json:"id"`
-	Name          string `json:"name"`
-	Slug          string `json:"slug"`
-	Type          string `json:"type"`
-	Prompt        string `json:"prompt"`
-	ProjectID     int64  `json:"project_id"`
-	ProjectSlug   string `json:"project_slug"`
-	BrandOverride string `json:"brand_override"`
-	CreatedAt     string `json:"created_at"`
-	UpdatedAt     string `json:"updated_at"`
-}
-
-func (r workItemRow) toWorkItem() WorkItem {
-	created, _ := time.Parse(time.RFC3339Nano, r.CreatedAt)
-	updated, _ := time.Parse(time.RFC3339Nano, r.UpdatedAt)
-	return WorkItem{
-		ID:            r.ID,
-		Name:          r.Name,
-		Slug:          r.Slug,
-		Type:          r.Type,
-		Prompt:        r.Prompt,
-		ProjectID:     r.ProjectID,
-		ProjectSlug:   r.ProjectSlug,
-		BrandOverride: r.BrandOverride,
-		CreatedAt:     created,
-		UpdatedAt:     updated,
-	}
-}
-
-type jobRow struct {
-	ID           int64  `json:"id"`
-	Status       string `json:"status"`
-	ProjectSlug  string `json:"project_slug"`
-	ProjectName  string `json:"project_name"`
-	WorkItemSlug string `json:"work_item_slug"`
-	WorkItemName string `json:"work_item_name"`
-	PayloadJSON  string `json:"payload_json"`
-	ErrorMessage string `json:"error_message"`
-	CreatedAt    string `json:"created_at"`
-	StartedAt    string `json:"started_at"`
-	FinishedAt   string `json:"finished_at"`
-	RunID        int64  `json:"run_id"`
-}
-
-func (r jobRow) toJob() Job {
-	created, _ := time.Parse(time.RFC3339Nano, r.CreatedAt)
-	var startedPtr *time.Time
-	if strings.TrimSpace(r.StartedAt) != "" {
-		t, _ := time.Parse(time.RFC3339Nano, r.StartedAt)
-		startedPtr = &t
-	}
-	var finishedPtr *time.Time
-	if strings.TrimSpace(r.FinishedAt) != "" {
-		t, _ := time.Parse(time.RFC3339Nano, r.FinishedAt)
-		finishedPtr = &t
-	}
-	var runID *int64
-	if r.RunID > 0 {
-		id := r.RunID
-		runID = &id
-	}
-	return Job{
-		ID:           r.ID,
-		Status:       r.Status,
-		ProjectSlug:  r.ProjectSlug,
-		ProjectName:  r.ProjectName,
-		WorkItemSlug: r.WorkItemSlug,
-		WorkItemName: r.WorkItemName,
-		PayloadJSON:  r.PayloadJSON,
-		ErrorMessage: r.ErrorMessage,
-		CreatedAt:    created,
-		StartedAt:    startedPtr,
-		FinishedAt:   finishedPtr,
-		RunID:        runID,
-	}
-}
-
-type claimRow struct {
-	JobID        int64  `json:"job_id"`
-	WorkItemID   int64  `json:"work_item_id"`
-	ProjectSlug  string `json:"project_slug"`
-	ProjectName  string `json:"project_name"`
-	WorkItemSlug string `json:"work_item_slug"`
-	WorkItemName string `json:"work_item_name"`
-	Prompt       string `json:"prompt"`
-	BrandSlug    string `json:"brand_slug"`
-	BrandContent string `json:"brand_content"`
-	PayloadJSON  string `json:"payload_json"`
-}
-
-type imageRow struct {
-	ID        int64  `json:"id"`
-	RunID     int64  `json:"run_id"`
-	Filename  string `json:"filename"`
-	CreatedAt string `json:"created_at"`
-}
-
-func (r imageRow) toImage() WorkItemImage {
-	created, _ := time.Parse(time.RFC3339Nano, r.CreatedAt)
-	return WorkItemImage{ID: r.ID, RunID: r.RunID, Name: r.Filename, URL: fmt.Sprintf("/images/%d", r.ID), CreatedAt: created}
+
+// scanImageWith scans imageColumnsSQL followed by the extra columns.
+func scanImageWith(sc rowScanner, extra ...any) (WorkItemImage, error) {
+	var img WorkItemImage
+	var created, tags string
+	dest := append([]any{&img.ID, &img.RunID, &img.Name, &created, &img.Rating, &img.Note,
+		&img.Width, &img.Height, &img.SizeBytes, &img.SHA256, &img.MIMEType, &tags}, extra...)
+	if err := sc.Scan(dest...); err != nil {
+		return WorkItemImage{}, err
+	}
+	img.Tags = splitTags(tags)
+	img.URL = fmt.Sprintf("/images/%d", img.ID)
+	img.CreatedAt = parseTime(created)
+	return img, nil
 }
@@ -0,0 +1,125 @@
+package webapp
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// Seed sizes for the dashboard benchmarks: every work item gets
+// benchJobsPerItem succeeded jobs with benchImagesPerJob images each.
+const (
+	benchProjects      = 10
+	benchItemsPerProj  = 10
+	benchJobsPerItem   = 5
+	benchImagesPerJob  = 4
+	benchDashboardPage = 50
+)
+
+// seededStore returns a store in a temporary directory holding
+// benchProjects projects and the work items, jobs and images under them.
+func seededStore(b *testing.B) *Store {
+	b.Helper()
+	store, err := NewStore(b.TempDir())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { _ = store.Close() })
+
+	models := []string{"openai", "google"}
+	for p := range benchProjects {
+		project, err := store.CreateProject(fmt.Sprintf("Project %d", p), "")
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := range benchItemsPerProj {
+			item, err := store.CreateWorkItem(project.Slug, fmt.Sprintf("Item %d", i), "", "a prompt", "")
+			if err != nil {
+				b.Fatal(err)
+			}
+			for j := range benchJobsPerItem {
+				payload := GenerateJobPayload{Model: models[j%len(models)], OutputFormat: "png", ImageSize: "1K"}
+				if _, err := store.CreateGenerateJob(project.Slug, item.Slug, payload); err != nil {
+					b.Fatal(err)
+				}
+				job, err := store.ClaimNextQueuedJob()
+				if err != nil || job == nil {
+					b.Fatalf("claim job: %v", err)
+				}
+				runID, err := store.CreateRun(job, "a prompt", "{}")
+				if err != nil {
+					b.Fatal(err)
+				}
+				dir, err := store.RelPath(store.WorkItemImagesDir(project.Slug, item.Slug, runID))
+				if err != nil {
+					b.Fatal(err)
+				}
+				for k := range benchImagesPerJob {
+					name := fmt.Sprintf("%s-%d.png", payload.Model, k)
+					meta := ImageMeta{Width: 1024, Height: 1024, SizeBytes: 1 << 20, SHA256: fmt.Sprintf("%064x", runID*100+int64(k)), MIMEType: "image/png"}
+					if err := store.AddRunImage(runID, name, filepath.Join(dir, name), "png", payload.Model, meta); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := store.MarkRunSucceeded(runID); err != nil {
+					b.Fatal(err)
+				}
+				if err := store.MarkJobSucceeded(job.JobID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+	return store
+}
+
+func BenchmarkListJobs(b *testing.B) {
+	store := seededStore(b)
+	for _, bc := range []struct {
+		name   string
+		filter JobFilter
+	}{
+		{"all", JobFilter{Limit: benchDashboardPage}},
+		{"status", JobFilter{Status: "succeeded", Limit: benchDashboardPage}},
+		{"project", JobFilter{ProjectSlug: "project-3", Limit: benchDashboardPage}},
+		{"model", JobFilter{Model: "google", Limit: benchDashboardPage}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for b.Loop() {
+				if _, err := store.ListJobs(bc.filter); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkListImages(b *testing.B) {
+	store := seededStore(b)
+	for _, bc := range []struct {
+		name   string
+		filter ImageFilter
+	}{
+		{"all", ImageFilter{JobFilter: JobFilter{Limit: benchDashboardPage}}},
+		{"project", ImageFilter{JobFilter: JobFilter{ProjectSlug: "project-3", Limit: benchDashboardPage}}},
+		{"model", ImageFilter{JobFilter: JobFilter{Model: "google", Limit: benchDashboardPage}}},
+		{"rated", ImageFilter{JobFilter: JobFilter{Limit: benchDashboardPage}, MinRating: 1}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for b.Loop() {
+				if _, err := store.ListImages(bc.filter); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkListProjects(b *testing.B) {
+	store := seededStore(b)
+	for b.Loop() {
+		if _, err := store.ListProjects(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
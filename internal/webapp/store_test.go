@@ -0,0 +1,254 @@
+package webapp
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestStore returns an empty store in a temporary directory.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestBrandCRUD(t *testing.T) {
+	store := newTestStore(t)
+	brand, err := store.CreateBrand("Acme Corp", "Bold and bright.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if brand.Slug != "acme-corp" || brand.Name != "Acme Corp" || brand.Version != 1 {
+		t.Fatalf("created brand = %+v", brand)
+	}
+	if _, err := store.UpdateBrand("acme-corp", "Calm and muted.", "ana"); err != nil {
+		t.Fatal(err)
+	}
+	reverted, err := store.RevertBrand("acme-corp", 1, "ana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted.Content != "Bold and bright." || reverted.Version != 3 {
+		t.Fatalf("reverted brand = %+v", reverted)
+	}
+	versions, err := store.ListBrandVersions("acme-corp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || versions[0].Note != "revert to v1" || versions[1].Author != "ana" {
+		t.Fatalf("versions = %+v", versions)
+	}
+
+	if err := store.ArchiveBrand("acme-corp"); err != nil {
+		t.Fatal(err)
+	}
+	if brands, _ := store.ListBrands(); len(brands) != 0 {
+		t.Fatalf("archived brand listed: %+v", brands)
+	}
+	if brands, _ := store.ListAllBrands(); len(brands) != 1 || brands[0].ArchivedAt == nil {
+		t.Fatalf("all brands = %+v", brands)
+	}
+	if err := store.RestoreBrand("acme-corp"); err != nil {
+		t.Fatal(err)
+	}
+	if brands, _ := store.ListBrands(); len(brands) != 1 {
+		t.Fatalf("restored brand not listed: %+v", brands)
+	}
+
+	if _, err := store.GetBrand("nope"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("GetBrand(nope) error = %v, want os.ErrNotExist", err)
+	}
+	if _, err := store.UpdateBrand("nope", "x", ""); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("UpdateBrand(nope) error = %v, want os.ErrNotExist", err)
+	}
+	if err := store.ArchiveBrand("nope"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("ArchiveBrand(nope) error = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestProjectAndWorkItemCRUD(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.CreateBrand("Acme", "Bold."); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateProject("Launch", "missing-brand"); err == nil {
+		t.Fatal("project with unknown brand was created")
+	}
+	project, err := store.CreateProject("Spring Launch", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.Slug != "spring-launch" || project.DefaultBrandSlug != "acme" {
+		t.Fatalf("created project = %+v", project)
+	}
+
+	item, err := store.CreateWorkItem("spring-launch", "Hero Banner", "", "  a red kite  ", "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Slug != "hero-banner" || item.Type != "generic" || item.Prompt != "a red kite" ||
+		item.BrandOverride != "acme" || item.PromptRevision != 1 {
+		t.Fatalf("created work item = %+v", item)
+	}
+	if _, err := store.CreateWorkItem("spring-launch", "Empty", "", "  ", ""); err == nil {
+		t.Fatal("work item without prompt was created")
+	}
+
+	if _, err := store.UpdateWorkItemPrompt("spring-launch", "hero-banner", "a blue kite", "bluer"); err != nil {
+		t.Fatal(err)
+	}
+	item, err = store.RestorePromptRevision("spring-launch", "hero-banner", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Prompt != "a red kite" || item.PromptRevision != 3 {
+		t.Fatalf("restored work item = %+v", item)
+	}
+	revisions, err := store.ListPromptRevisions("spring-launch", "hero-banner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 || revisions[0].Note != "restored r1" || revisions[1].Note != "bluer" {
+		t.Fatalf("revisions = %+v", revisions)
+	}
+
+	item, err = store.UpdateWorkItemConstraints("spring-launch", "hero-banner", Constraints{AspectRatio: "16:9", Models: []string{"openai"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Constraints.AspectRatio != "16:9" || !item.Constraints.AllowsModel("openai") || item.Constraints.AllowsModel("google") {
+		t.Fatalf("constraints = %+v", item.Constraints)
+	}
+	if _, err := store.UpdateWorkItemConstraints("spring-launch", "nope", Constraints{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("constraints of unknown item error = %v, want os.ErrNotExist", err)
+	}
+
+	if project, _ = store.GetProject("spring-launch"); project.WorkItemCount != 1 {
+		t.Fatalf("work item count = %d, want 1", project.WorkItemCount)
+	}
+	if err := store.ArchiveWorkItem("spring-launch", "hero-banner"); err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := store.ListWorkItems("spring-launch"); len(items) != 0 {
+		t.Fatalf("archived work item listed: %+v", items)
+	}
+	if project, _ = store.GetProject("spring-launch"); project.WorkItemCount != 0 {
+		t.Fatalf("work item count after archive = %d, want 0", project.WorkItemCount)
+	}
+	if err := store.RestoreWorkItem("spring-launch", "hero-banner"); err != nil {
+		t.Fatal(err)
+	}
+	if items, _ := store.ListAllWorkItems("spring-launch"); len(items) != 1 || items[0].ArchivedAt != nil {
+		t.Fatalf("restored work items = %+v", items)
+	}
+	if err := store.ArchiveProject("spring-launch"); err != nil {
+		t.Fatal(err)
+	}
+	if projects, _ := store.ListProjects(); len(projects) != 0 {
+		t.Fatalf("archived project listed: %+v", projects)
+	}
+}
+
+func TestCreateDuplicateReportsAlreadyExists(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.CreateBrand("Acme", "Bold."); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateProject("Launch", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateWorkItem("launch", "Hero", "", "a kite", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name   string
+		create func() error
+		want   string
+	}{
+		{"brand", func() error { _, err := store.CreateBrand("ACME", "Other."); return err }, `brand "acme" already exists`},
+		{"project", func() error { _, err := store.CreateProject(" launch ", ""); return err }, `project "launch" already exists`},
+		{"work item", func() error { _, err := store.CreateWorkItem("launch", "hero!", "", "b", ""); return err }, `work item "hero" already exists`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.create()
+			if err == nil || err.Error() != tc.want {
+				t.Fatalf("error = %v, want %q", err, tc.want)
+			}
+		})
+	}
+	// The same work item name is free in another project.
+	if _, err := store.CreateProject("Other", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateWorkItem("other", "Hero", "", "a kite", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := store.db.Exec(`INSERT INTO tags (name, created_at) VALUES ('x', ''), ('x', '');`)
+	if !isUniqueViolation(err) {
+		t.Fatalf("isUniqueViolation(%v) = false", err)
+	}
+	_, err = store.db.Exec(`INSERT INTO work_items (project_id, name, slug, prompt, created_at, updated_at) VALUES (999, 'a', 'a', 'p', '', '');`)
+	if err == nil || isUniqueViolation(err) {
+		t.Fatalf("foreign key error %v reported as unique violation", err)
+	}
+}
+
+func TestTimestampsRoundTrip(t *testing.T) {
+	store := newTestStore(t)
+	before := time.Now().UTC().Truncate(time.Millisecond)
+	brand, err := store.CreateBrand("Acme", "Bold.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	project, err := store.CreateProject("Launch", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := time.Now().UTC()
+	for name, at := range map[string]time.Time{
+		"brand created":   brand.CreatedAt,
+		"brand updated":   brand.UpdatedAt,
+		"project created": project.CreatedAt,
+	} {
+		if at.Before(before) || at.After(after) || at.Location() != time.UTC {
+			t.Errorf("%s = %v, want UTC within [%v, %v]", name, at, before, after)
+		}
+	}
+
+	var raw string
+	if err := store.db.QueryRow(`SELECT created_at FROM brands WHERE id = ?;`, brand.ID).Scan(&raw); err != nil {
+		t.Fatal(err)
+	}
+	if got := brand.CreatedAt.Format(timestampLayout); got != raw {
+		t.Fatalf("stored %q, reformatted %q", raw, got)
+	}
+	// Stored text sorts like the times it holds, which the keyset cursors
+	// rely on.
+	if earlier, later := time.Date(2024, 1, 2, 3, 4, 5, 9e6, time.UTC), time.Date(2024, 1, 2, 3, 4, 5, 10e6, time.UTC); earlier.Format(timestampLayout) >= later.Format(timestampLayout) {
+		t.Fatal("timestamp text does not sort chronologically")
+	}
+	if !parseTime("2024-01-02T03:04:05Z").Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Fatal("parseTime rejects timestamps without milliseconds")
+	}
+
+	if err := store.ArchiveProject("launch"); err != nil {
+		t.Fatal(err)
+	}
+	projects, err := store.ListAllProjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 1 || projects[0].ArchivedAt == nil || projects[0].ArchivedAt.Before(before) {
+		t.Fatalf("archived project = %+v", projects)
+	}
+	if projects[0].ArchivedAt.Location() != time.UTC {
+		t.Fatalf("archived_at %v is not UTC", projects[0].ArchivedAt)
+	}
+}
@@ -0,0 +1,126 @@
+package webapp
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// maxTagLength caps normalized tag names so they stay usable as chips.
+const maxTagLength = 48
+
+// NormalizeTag lowercases a tag and reduces it to slug characters, so
+// "Client Favorite" and "client-favorite" are the same tag.
+func NormalizeTag(input string) string {
+	tag := Slugify(input)
+	if len(tag) > maxTagLength {
+		tag = strings.TrimRight(tag[:maxTagLength], "-")
+	}
+	return tag
+}
+
+func splitTags(joined string) []string {
+	if joined == "" {
+		return []string{}
+	}
+	return strings.Split(joined, ",")
+}
+
+// ListTags returns tags starting with prefix, most used first, for
+// autocomplete and the tag filters.
+func (s *Store) ListTags(prefix string, limit int) ([]Tag, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	prefix = NormalizeTag(prefix)
+	rows, err := s.db.Query(`
+		SELECT t.name,
+		       (SELECT COUNT(*) FROM work_item_tags wt WHERE wt.tag_id = t.id),
+		       (SELECT COUNT(*) FROM image_tags it WHERE it.tag_id = t.id) AS images
+		FROM tags t
+		WHERE substr(t.name, 1, length(?)) = ?
+		ORDER BY 2 + 3 DESC, t.name
+		LIMIT ?;
+	`, prefix, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectRows(rows, func(sc rowScanner) (Tag, error) {
+		var t Tag
+		err := sc.Scan(&t.Name, &t.WorkItems, &t.Images)
+		return t, err
+	})
+}
+
+func (s *Store) TagWorkItem(projectSlug string, itemSlug string, tag string) error {
+	item, err := s.GetWorkItem(projectSlug, itemSlug)
+	if err != nil {
+		return err
+	}
+	return s.withTx(func(tx *sql.Tx) error {
+		return addTag(tx, "work_item_tags", "work_item_id", item.ID, tag)
+	})
+}
+
+func (s *Store) UntagWorkItem(projectSlug string, itemSlug string, tag string) error {
+	item, err := s.GetWorkItem(projectSlug, itemSlug)
+	if err != nil {
+		return err
+	}
+	return s.withTx(func(tx *sql.Tx) error {
+		return removeTag(tx, "work_item_tags", "work_item_id", item.ID, tag)
+	})
+}
+
+func (s *Store) TagImage(imageID int64, tag string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		var id int64
+		if err := tx.QueryRow(`SELECT id FROM run_images WHERE id = ?;`, imageID).Scan(&id); err != nil {
+			return notFound(err)
+		}
+		return addTag(tx, "image_tags", "image_id", imageID, tag)
+	})
+}
+
+func (s *Store) UntagImage(imageID int64, tag string) error {
+	return s.withTx(func(tx *sql.Tx) error {
+		return removeTag(tx, "image_tags", "image_id", imageID, tag)
+	})
+}
+
+// addTag links a tag, creating it if needed, to the row ownerID of a link
+// table. Adding a tag that is already there is not an error.
+func addTag(tx *sql.Tx, table string, column string, ownerID int64, tag string) error {
+	name := NormalizeTag(tag)
+	if name == "" {
+		return errors.New("tag is required")
+	}
+	now := nowText()
+	if _, err := tx.Exec(`INSERT INTO tags (name, created_at) VALUES (?, ?) ON CONFLICT(name) DO NOTHING;`, name, now); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO `+table+` (`+column+`, tag_id, created_at)
+		SELECT ?, id, ? FROM tags WHERE name = ?
+		ON CONFLICT DO NOTHING;
+	`, ownerID, now, name)
+	return err
+}
+
+// removeTag unlinks a tag and drops it once nothing carries it any more.
+func removeTag(tx *sql.Tx, table string, column string, ownerID int64, tag string) error {
+	name := NormalizeTag(tag)
+	if _, err := tx.Exec(`
+		DELETE FROM `+table+`
+		WHERE `+column+` = ? AND tag_id = (SELECT id FROM tags WHERE name = ?);
+	`, ownerID, name); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		DELETE FROM tags
+		WHERE name = ?
+		  AND NOT EXISTS (SELECT 1 FROM work_item_tags WHERE tag_id = tags.id)
+		  AND NOT EXISTS (SELECT 1 FROM image_tags WHERE tag_id = tags.id);
+	`, name)
+	return err
+}
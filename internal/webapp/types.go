@@ -3,12 +3,45 @@ package webapp
 import "time"
 
 type Brand struct {
+	ID      int64
+	Name    string
+	Slug    string
+	Content string
+	// Spec and Body are Content split into its frontmatter and the text
+	// after it.
+	Spec       BrandSpec
+	Body       string
+	Version    int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	ArchivedAt *time.Time
+}
+
+type BrandVersion struct {
 	ID        int64
-	Name      string
-	Slug      string
+	BrandID   int64
+	Version   int
 	Content   string
+	Author    string
+	Note      string
+	CreatedAt time.Time
+}
+
+// BrandAsset is an image attached to a brand: a logo or a style reference
+// that jobs can hand to the models as an image input.
+type BrandAsset struct {
+	ID        int64
+	BrandID   int64
+	BrandSlug string
+	Kind      string
+	Filename  string
+	RelPath   string
+	MIMEType  string
+	Width     int
+	Height    int
+	SizeBytes int64
+	SHA256    string
 	CreatedAt time.Time
-	UpdatedAt time.Time
 }
 
 type Project struct {
@@ -19,6 +52,7 @@ type Project struct {
 	CreatedAt        time.Time
 	UpdatedAt        time.Time
 	WorkItemCount    int
+	ArchivedAt       *time.Time
 }
 
 type WorkItem struct {
@@ -30,16 +64,120 @@ type WorkItem struct {
 	ProjectID     int64
 	ProjectSlug   string
 	BrandOverride string
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
+	// Status is where the work item is in its lifecycle, one of
+	// WorkItemStatuses.
+	Status      string
+	Constraints Constraints
+	// PromptRevision is the current revision number, 0 without history.
+	PromptRevision int
+	Tags           []string
+	// Final is the chosen deliverable, nil until one is finalized.
+	Final      *Final
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	ArchivedAt *time.Time
+}
+
+// Final is the candidate chosen as a work item's deliverable. The file is a
+// copy under the work item's final/ directory, so it survives the candidate
+// being deleted; ImageID is 0 once that happens.
+type Final struct {
+	WorkItemID  int64
+	ImageID     int64
+	Filename    string
+	RelPath     string
+	FinalizedBy string
+	FinalizedAt time.Time
+}
+
+type PromptRevision struct {
+	ID         int64
+	WorkItemID int64
+	Revision   int
+	Prompt     string
+	Note       string
+	CreatedAt  time.Time
 }
 
 type WorkItemImage struct {
-	ID        int64
-	RunID     int64
-	Name      string
-	URL       string
+	ID    int64
+	RunID int64
+	Name  string
+	URL   string
+	Tags  []string
+	// Rating is 1 to 5 stars, 0 while unrated; Note is the reviewer's note.
+	Rating    int
+	Note      string
 	CreatedAt time.Time
+	ImageMeta
+}
+
+// ImageMeta describes a stored image file. Width, Height and MIMEType stay
+// zero when the file could not be decoded; SHA256 is empty until recorded.
+type ImageMeta struct {
+	Width     int
+	Height    int
+	SizeBytes int64
+	SHA256    string
+	MIMEType  string
+}
+
+// StatusChange is one step in a work item's status history. Note says what
+// caused it, such as a queued job or a finalized image.
+type StatusChange struct {
+	ID         int64
+	WorkItemID int64
+	From       string
+	To         string
+	Note       string
+	CreatedAt  time.Time
+}
+
+// BoardColumn is one status column of a project board.
+type BoardColumn struct {
+	Status string
+	Items  []WorkItem
+}
+
+// RankedImage is an image with its standing in a work item's pairwise
+// comparisons. Elo starts at 1500.
+type RankedImage struct {
+	WorkItemImage
+	Elo    float64
+	Wins   int
+	Losses int
+}
+
+// ComparisonPair is the next two candidates to put side by side.
+type ComparisonPair struct {
+	Left  RankedImage
+	Right RankedImage
+}
+
+// Comparison is one recorded pick between two images of a work item.
+type Comparison struct {
+	ID         int64
+	WorkItemID int64
+	WinnerID   int64
+	LoserID    int64
+	CreatedAt  time.Time
+}
+
+// ModelRating aggregates the ratings a project gave one model's images for
+// one work item type.
+type ModelRating struct {
+	Model    string
+	ItemType string
+	Images   int
+	Rated    int
+	Average  float64
+}
+
+// Tag is a label with the number of work items and images carrying it.
+type Tag struct {
+	Name      string
+	WorkItems int
+	Images    int
 }
 
 type GenerateJobPayload struct {
@@ -49,21 +187,44 @@ type GenerateJobPayload struct {
 	ImageSize    string `json:"image_size"`
 	AspectRatio  string `json:"aspect_ratio"`
 	Adjustment   string `json:"adjustment"`
+	MaxBytes     int    `json:"max_bytes,omitempty"`
+	// ReferenceAssetIDs are brand assets sent along as image inputs.
+	ReferenceAssetIDs []int64 `json:"reference_asset_ids,omitempty"`
+}
+
+type RunSettings struct {
+	GenerateJobPayload
+	Encodes []RunEncodeResult `json:"encodes,omitempty"`
+}
+
+// RunEncodeResult describes an image fitted under a max file size. Only the
+// fitted file is a run image; Original names the generated file it was made
+// from, which stays next to it without a record of its own.
+type RunEncodeResult struct {
+	Filename string `json:"filename"`
+	Original string `json:"original,omitempty"`
+	Quality  int    `json:"quality"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Bytes    int    `json:"bytes"`
 }
 
 type Job struct {
-	ID           int64
-	Status       string
-	ProjectSlug  string
-	ProjectName  string
-	WorkItemSlug string
-	WorkItemName string
-	PayloadJSON  string
-	ErrorMessage string
-	CreatedAt    time.Time
-	StartedAt    *time.Time
-	FinishedAt   *time.Time
-	RunID        *int64
+	ID             int64
+	Status         string
+	ProjectSlug    string
+	ProjectName    string
+	WorkItemSlug   string
+	WorkItemName   string
+	PayloadJSON    string
+	ErrorMessage   string
+	CreatedAt      time.Time
+	StartedAt      *time.Time
+	FinishedAt     *time.Time
+	RunID          *int64
+	BrandSlug      string
+	BrandVersion   int
+	PromptRevision int
 }
 
 type JobExecutionContext struct {
@@ -76,5 +237,50 @@ type JobExecutionContext struct {
 	Prompt       string
 	BrandSlug    string
 	BrandContent string
-	Payload      GenerateJobPayload
+	// BrandVersionID is the brand_versions row the content was read from,
+	// or 0 when the backend does not keep brand history.
+	BrandVersionID int64
+	// PromptRevisionID is the prompt_revisions row Prompt came from, or 0.
+	PromptRevisionID int64
+	Constraints      Constraints
+	Payload          GenerateJobPayload
+}
+
+// JobFilter selects jobs for ListJobs. Empty fields match everything; Tag
+// matches the work item's tags; From and To bound the creation time (To is
+// exclusive). Cursor is a NextCursor or PrevCursor from an earlier page.
+type JobFilter struct {
+	Status       string
+	ProjectSlug  string
+	WorkItemSlug string
+	Model        string
+	Tag          string
+	From         time.Time
+	To           time.Time
+	Cursor       string
+	Limit        int
+}
+
+// JobPage is one page of jobs, newest first. NextCursor leads to older jobs
+// and PrevCursor to newer ones; either is empty at the end of the list.
+type JobPage struct {
+	Jobs       []Job
+	NextCursor string
+	PrevCursor string
+}
+
+// ImageFilter selects generated images for ListImages. Status and Model in
+// the embedded JobFilter match the job that produced the image; Tag matches
+// the image's own tags. MinRating drops images rated lower (and unrated ones),
+// and Sort "rating" lists the best rated first instead of the newest.
+type ImageFilter struct {
+	JobFilter
+	MinRating int
+	Sort      string
+}
+
+type ImagePage struct {
+	Images     []WorkItemImage
+	NextCursor string
+	PrevCursor string
 }
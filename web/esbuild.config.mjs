@@ -17,6 +17,7 @@ const jsEntries = [
   'static/js/src/pages/project-detail.ts',
   'static/js/src/pages/work-item-detail.ts',
   'static/js/src/pages/job-detail.ts',
+  'static/js/src/pages/compare.ts',
 ];
 
 const common = {
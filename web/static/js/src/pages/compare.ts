@@ -0,0 +1,27 @@
+import { ready } from "../core/events";
+
+ready((): void => {
+	const controls = document.querySelectorAll<HTMLElement>("[data-compare-key]");
+	if (controls.length === 0) return;
+
+	document.addEventListener("keydown", (event: KeyboardEvent): void => {
+		if (event.defaultPrevented || event.repeat) return;
+		if (event.altKey || event.ctrlKey || event.metaKey) return;
+		if (isEditable(event.target)) return;
+
+		const key = event.key.toLowerCase();
+		for (const control of controls) {
+			if (control.dataset.compareKey === key) {
+				event.preventDefault();
+				control.click();
+				return;
+			}
+		}
+	});
+});
+
+function isEditable(target: EventTarget | null): boolean {
+	if (!(target instanceof HTMLElement)) return false;
+	if (target.isContentEditable) return true;
+	return ["INPUT", "TEXTAREA", "SELECT"].includes(target.tagName);
+}
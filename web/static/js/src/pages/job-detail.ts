@@ -27,6 +27,57 @@ ready((): void => {
 	void pollJob(jobID, status, badge, pollNotice);
 });
 
+ready((): void => {
+	const form = document.getElementById(
+		"print-export-form",
+	) as HTMLFormElement | null;
+	const statusEl = document.getElementById(
+		"print-export-status",
+	) as HTMLElement | null;
+	if (!form || !statusEl) return;
+
+	form.addEventListener("submit", async (event) => {
+		event.preventDefault();
+		const data = new FormData(form);
+		const imageID = String(data.get("image_id") ?? "");
+		const params = new URLSearchParams();
+		for (const key of ["width_in", "height_in", "dpi", "format"]) {
+			params.set(key, String(data.get(key) ?? ""));
+		}
+
+		const res = await fetch(`/images/${imageID}/print?${params.toString()}`);
+		if (!res.ok) {
+			showPrintStatus(statusEl, (await res.text()).trim(), true);
+			return;
+		}
+
+		const warnings = res.headers.get("X-Print-Warning");
+		if (warnings) {
+			showPrintStatus(statusEl, `Warning: ${warnings}`, true);
+		} else {
+			showPrintStatus(statusEl, "Print file ready.", false);
+		}
+
+		const disposition = res.headers.get("Content-Disposition") ?? "";
+		const match = disposition.match(/filename="([^"]+)"/);
+		const link = document.createElement("a");
+		link.href = URL.createObjectURL(await res.blob());
+		link.download = match?.[1] ?? "print";
+		link.click();
+		URL.revokeObjectURL(link.href);
+	});
+});
+
+function showPrintStatus(
+	el: HTMLElement,
+	message: string,
+	isError: boolean,
+): void {
+	el.classList.remove("hidden", "form-status--error", "form-status--success");
+	el.classList.add(isError ? "form-status--error" : "form-status--success");
+	el.textContent = message;
+}
+
 async function pollJob(
 	jobID: number,
 	initialStatus: string,